@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/events"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/prober"
+	"github.com/CloudNativeWorks/elchi-discovery/recording"
+	"github.com/CloudNativeWorks/elchi-discovery/status"
+)
+
+// agent runs discovery cycles and keeps the state carried between them
+type agent struct {
+	log       *logger.Logger
+	discovery *discovery.Service
+	client    *api.Client
+	// scope limits the nodes sent with client, nil sends every node
+	scope *discovery.Scope
+	// projects are additional Elchi projects, each sent its own part of the cluster
+	projects []*project
+	// status publishes the agent state into the cluster, nil when disabled
+	status *status.Publisher
+	// events records Kubernetes Events, nil when disabled
+	events *events.Recorder
+	// remote bounds directives from the API, nil when remote configuration is disabled
+	remote *api.DirectiveBounds
+	// recorder stores every payload for replay, nil when recording is disabled
+	recorder *recording.Writer
+	// prober health checks node ports in the background, nil when probing is disabled
+	prober *prober.Prober
+	// guard holds back snapshots after a sudden node drop, nil when disabled
+	guard *snapshotGuard
+
+	interval    time.Duration
+	ticker      *time.Ticker
+	pausedUntil time.Time
+	resync      bool
+
+	lastResult *discovery.DiscoveryResult
+	// lastDelivered is the scoped snapshot Elchi last accepted, or left
+	// unchanged; the status reports it rather than the raw discovery
+	lastDelivered *discovery.DiscoveryResult
+	// lastSnapshot is the last snapshot chosen for sending, resent marked as
+	// stale while discovery fails
+	lastSnapshot *discovery.DiscoveryResult
+	// staleSince is when discovery started failing
+	staleSince time.Time
+	// serveStale resends lastSnapshot when discovery fails
+	serveStale    bool
+	lastSend      time.Time
+	lastError     error
+	lastErrorTime time.Time
+
+	// identity is reported in heartbeats
+	identity api.AgentIdentity
+	// fullSnapshotInterval is how often an unchanged snapshot is resent, zero
+	// sends every cycle
+	fullSnapshotInterval time.Duration
+	// lastSentFingerprint is the fingerprint of the last snapshot accepted by the API
+	lastSentFingerprint string
+	// heartbeat is the state reported by the heartbeat loop
+	heartbeat atomic.Pointer[api.Heartbeat]
+}
+
+func newAgent(log *logger.Logger, discoveryService *discovery.Service, apiClient *api.Client) *agent {
+	return &agent{
+		log:        log,
+		discovery:  discoveryService,
+		client:     apiClient,
+		interval:   30 * time.Second,
+		identity:   newAgentIdentity(),
+		serveStale: true,
+	}
+}
+
+// run discovers immediately and then on every tick until ctx is cancelled
+func (a *agent) run(ctx context.Context) {
+	a.ticker = time.NewTicker(a.interval)
+	defer a.ticker.Stop()
+
+	// Run discovery immediately on startup
+	a.runCycle(ctx)
+
+	// Then run on schedule
+	for {
+		select {
+		case <-a.ticker.C:
+			a.runCycle(ctx)
+		case <-ctx.Done():
+			a.log.Info("Shutdown signal received, stopping discovery")
+			return
+		}
+	}
+}
+
+// runCycle runs discovery once, plus a single extra run when the API
+// requested a resync
+func (a *agent) runCycle(ctx context.Context) {
+	a.runDiscovery(ctx)
+
+	if a.resync {
+		a.resync = false
+		a.log.Info("Running full resync requested by API")
+		a.runDiscovery(ctx)
+	}
+}
+
+func (a *agent) runDiscovery(ctx context.Context) {
+	var cycleErr error
+	defer func() {
+		a.updateHeartbeat(cycleErr)
+		a.publishStatus(ctx)
+	}()
+
+	// Perform discovery
+	result, err := a.discovery.DiscoverNodes(ctx)
+	var send *discovery.DiscoveryResult
+	if err != nil {
+		a.log.WithError(err).Error("Failed to discover nodes")
+		a.recordError(err)
+		cycleErr = err
+		// Tell Elchi the agent is alive but cannot see the cluster
+		if send = a.staleSnapshot(err); send == nil {
+			return
+		}
+	} else {
+		a.staleSince = time.Time{}
+		if a.prober != nil {
+			// Probes run on their own schedule, the snapshot carries their last results
+			a.prober.SetNodes(result.Nodes)
+			a.prober.Attach(result)
+		}
+		a.lastResult = result
+
+		if result.ClusterInfo.PreviousID != "" {
+			a.log.WithFields(map[string]interface{}{
+				"cluster_name":        result.ClusterInfo.Name,
+				"cluster_id":          result.ClusterInfo.ID,
+				"previous_cluster_id": result.ClusterInfo.PreviousID,
+			}).Warn("Cluster ID changed for the configured cluster name; check cluster_name is unique")
+		}
+
+		// While a sudden node drop is refused, send is the last good snapshot
+		send, err = a.guardSnapshot(ctx, result)
+		if err != nil {
+			a.recordError(err)
+			cycleErr = err
+		}
+		a.lastSnapshot = send
+	}
+
+	scoped, err := a.discovery.ApplyScope(ctx, send, a.scope)
+	if err != nil {
+		a.log.WithError(err).Error("Failed to scope discovery result")
+		a.recordError(err)
+		cycleErr = err
+		return
+	}
+
+	// Get the exact payload that will be sent to API
+	payload, err := a.client.GetDiscoveryPayload(scoped)
+	if err != nil {
+		a.log.WithError(err).Error("Failed to create discovery payload")
+		a.recordError(err)
+		cycleErr = err
+		return
+	}
+
+	// Print as pretty JSON to stdout (same as what gets sent to API)
+	jsonOutput, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		a.log.WithError(err).Error("Failed to marshal discovery payload to JSON")
+		a.recordError(err)
+		cycleErr = err
+		return
+	}
+
+	fmt.Println(string(jsonOutput))
+
+	if a.paused() {
+		a.log.WithField("paused_until", a.pausedUntil).Debug("Sending paused by API directive, skipping send")
+		a.recordPayload(&recording.Entry{Payload: payload, Status: recording.StatusSkipped})
+		// Heartbeats may have delivered directives while paused
+		a.applyDirectives(ctx)
+		return
+	}
+
+	if err := a.sendToProjects(ctx, send); err != nil {
+		a.recordError(err)
+		cycleErr = err
+	}
+
+	fingerprint := scoped.Fingerprint()
+	if send.Hold == nil && send.Stale == nil && a.snapshotUnchanged(fingerprint) {
+		a.log.WithField("fingerprint", fingerprint).Debug("Cluster unchanged since last snapshot, skipping send")
+		a.recordPayload(&recording.Entry{Payload: payload, Status: recording.StatusSkipped})
+		a.lastDelivered = scoped
+		a.applyDirectives(ctx)
+		return
+	}
+
+	// Send to API if configured
+	initial := !a.client.HandshakeCompleted()
+	sendStart := time.Now()
+	err = a.client.SendPayload(ctx, payload)
+	a.recordSend(payload, initial, sendStart, err)
+	if err != nil {
+		a.log.WithError(err).Error("Failed to send discovery result to API")
+		a.recordError(err)
+		cycleErr = err
+		if a.events != nil {
+			a.events.DeliveryFailed(err)
+		}
+		// Don't return here - we still want to continue discovery even if API fails
+	} else if a.client.Enabled() {
+		a.guard.accept(send)
+		a.lastSend = time.Now()
+		a.lastSentFingerprint = fingerprint
+		a.lastDelivered = scoped
+		if a.events != nil {
+			a.events.DeliverySucceeded()
+			a.events.ObserveNodes(scoped.Nodes)
+		}
+		a.applyDirectives(ctx)
+	}
+
+	if send.Stale != nil {
+		return
+	}
+	a.log.WithFields(map[string]interface{}{
+		"node_count":      result.NodeCount,
+		"duration":        result.Duration,
+		"cluster_name":    result.ClusterInfo.Name,
+		"cluster_id":      result.ClusterInfo.ID,
+		"cluster_version": result.ClusterInfo.Version,
+	}).Info("Discovery completed")
+}
+
+// staleSnapshot returns a copy of the last snapshot marked as stale after
+// discovery failed with err, or nil when there is none to send
+func (a *agent) staleSnapshot(err error) *discovery.DiscoveryResult {
+	if !a.serveStale || a.lastSnapshot == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if a.staleSince.IsZero() {
+		a.staleSince = now
+	}
+
+	stale := *a.lastSnapshot
+	stale.Stale = &discovery.Staleness{
+		Since:      a.staleSince,
+		AgeSeconds: now.Sub(stale.Timestamp).Seconds(),
+		Error:      err.Error(),
+	}
+
+	a.log.WithFields(map[string]interface{}{
+		"snapshot_age":  now.Sub(stale.Timestamp).Round(time.Second).String(),
+		"failing_since": a.staleSince,
+		"node_count":    stale.NodeCount,
+	}).Warn("Sending last known snapshot marked stale")
+	return &stale
+}
+
+// recordSend records the outcome of sending payload, started at sendStart
+func (a *agent) recordSend(payload *api.DiscoveryPayload, initial bool, sendStart time.Time, err error) {
+	entry := &recording.Entry{
+		Time:         sendStart,
+		Payload:      payload,
+		Initial:      initial,
+		Status:       recording.StatusSent,
+		SendDuration: time.Since(sendStart),
+	}
+	if err != nil {
+		entry.Status = recording.StatusFailed
+		entry.Error = err.Error()
+	} else if !a.client.Enabled() {
+		entry = &recording.Entry{Time: sendStart, Payload: payload, Status: recording.StatusSkipped}
+	}
+	a.recordPayload(entry)
+}
+
+// recordPayload appends entry to the recording, if enabled. Entries are
+// timed when they are sent, not by the snapshot timestamp, which stale and
+// held snapshots repeat.
+func (a *agent) recordPayload(entry *recording.Entry) {
+	if a.recorder == nil {
+		return
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if err := a.recorder.Write(entry); err != nil {
+		a.log.WithError(err).Warn("Failed to record discovery payload")
+	}
+}
+
+func (a *agent) paused() bool {
+	return time.Now().Before(a.pausedUntil)
+}
+
+// applyDirectives validates and applies directives returned by the API and
+// queues the acknowledgement for the next payload. A directive set with any
+// invalid entry is rejected as a whole.
+func (a *agent) applyDirectives(ctx context.Context) {
+	directives := a.client.TakeDirectives()
+	if directives == nil {
+		return
+	}
+
+	ack := &api.DirectiveAck{ID: directives.ID, At: time.Now()}
+	defer a.client.Acknowledge(ack)
+
+	if a.remote == nil {
+		ack.Errors = []string{"remote configuration is disabled"}
+		a.log.WithField("directive_id", directives.ID).Debug("Ignoring API directives, remote configuration is disabled")
+		return
+	}
+
+	effective, errs := directives.Validate(*a.remote)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			ack.Errors = append(ack.Errors, err.Error())
+		}
+		err := errors.Join(errs...)
+		a.log.WithError(err).WithField("directive_id", directives.ID).Warn("Rejected invalid API directives")
+		if a.events != nil {
+			a.events.ConfigReloadFailed(err)
+		}
+		return
+	}
+
+	if effective.DiscoveryInterval > 0 {
+		a.setInterval(time.Duration(effective.DiscoveryInterval) * time.Second)
+	}
+	if len(effective.Include) > 0 {
+		// Sections and selector were validated above
+		_ = a.discovery.SetSections(effective.Include)
+	}
+	if effective.NodeSelector != nil {
+		_ = a.discovery.SetNodeSelector(*effective.NodeSelector)
+	}
+	if effective.PauseSending {
+		a.pausedUntil = time.Now().Add(time.Duration(effective.PauseSeconds) * time.Second)
+		pausedUntil := a.pausedUntil
+		ack.PausedUntil = &pausedUntil
+	}
+	if effective.Resync {
+		a.client.ResetHandshake(ctx)
+		a.resync = true
+	}
+	if effective.AcceptSnapshot {
+		if a.guard.holding() {
+			a.guard.override = overrideDirective
+		} else {
+			a.log.WithField("directive_id", directives.ID).Debug("No snapshot held, ignoring accept_snapshot")
+		}
+	}
+
+	ack.Applied = true
+	ack.DiscoveryInterval = int(a.interval / time.Second)
+
+	a.log.WithFields(map[string]interface{}{
+		"directive_id":       directives.ID,
+		"discovery_interval": a.interval.String(),
+		"include":            effective.Include,
+		"node_selector":      a.discovery.NodeSelector(),
+		"resync":             effective.Resync,
+		"accept_snapshot":    effective.AcceptSnapshot,
+		"paused_until":       ack.PausedUntil,
+	}).Info("Applied API directives")
+}
+
+func (a *agent) setInterval(interval time.Duration) {
+	if interval == a.interval {
+		return
+	}
+	a.interval = interval
+	if a.ticker != nil {
+		a.ticker.Reset(interval)
+	}
+}
+
+func (a *agent) recordError(err error) {
+	a.lastError = err
+	a.lastErrorTime = time.Now()
+}
+
+// statusReport summarises the last cycle for the status publisher. The node
+// counts and fingerprint are those of the snapshot Elchi has, which differs
+// from the last discovery while the guard holds a snapshot or a scope applies.
+func (a *agent) statusReport() *status.Report {
+	report := &status.Report{Fingerprint: a.lastSentFingerprint}
+
+	if result := a.lastResult; result != nil {
+		report.ClusterName = result.ClusterInfo.Name
+		report.ClusterID = result.ClusterInfo.ID
+		report.LastDiscoveryTime = &result.Timestamp
+	}
+	if delivered := a.lastDelivered; delivered != nil {
+		report.NodeCount = delivered.NodeCount
+		report.ReadyNodeCount = delivered.ReadyCount()
+	}
+	if !a.lastSend.IsZero() {
+		report.LastSuccessfulSend = &a.lastSend
+	}
+	if a.lastError != nil {
+		report.LastError = a.lastError.Error()
+		report.LastErrorTime = &a.lastErrorTime
+	}
+	if response := a.client.LastResponse(); response != nil {
+		report.APIMessage = response.Message
+		if report.APIMessage == "" {
+			report.APIMessage = response.Error
+		}
+	}
+	report.ActiveEndpoint = a.client.ActiveEndpoint()
+	if expiresAt := a.client.TokenExpiry(); !expiresAt.IsZero() {
+		report.TokenExpiresAt = &expiresAt
+	}
+
+	return report
+}
+
+func (a *agent) publishStatus(ctx context.Context) {
+	if a.status == nil {
+		return
+	}
+
+	if err := a.status.Publish(ctx, a.statusReport()); err != nil {
+		a.log.WithError(err).Warn("Failed to publish discovery status")
+	}
+}
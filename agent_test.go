@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/events"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/recording"
+	"github.com/CloudNativeWorks/elchi-discovery/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestAgentPublishesStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Discovery processed",
+		})
+	}))
+	defer server.Close()
+
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	})
+
+	cfg := &config.Config{
+		ClusterName: "test-cluster",
+		Elchi: config.ElchiConfig{
+			APIEndpoint: server.URL,
+			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
+		},
+	}
+	log := logger.New(&logger.Config{Level: "error"})
+
+	agent := newAgent(log, discovery.NewService(client, cfg.ClusterName), newAPIClient(cfg, log))
+	agent.status = status.NewConfigMapPublisher(client, "elchi", "elchi-discovery-status")
+
+	ctx := context.Background()
+	agent.runDiscovery(ctx)
+
+	cm, err := client.CoreV1().ConfigMaps("elchi").Get(ctx, "elchi-discovery-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected status configmap to be published: %v", err)
+	}
+	if cm.Data["nodeCount"] != "1" || cm.Data["readyNodeCount"] != "1" {
+		t.Errorf("Unexpected node counts: %v", cm.Data)
+	}
+	if cm.Data["apiMessage"] != "Discovery processed" {
+		t.Errorf("Expected API message in status, got %q", cm.Data["apiMessage"])
+	}
+	if cm.Data["lastSuccessfulSendTime"] == "" {
+		t.Error("Expected last successful send time to be set")
+	}
+	if cm.Data["fingerprint"] == "" {
+		t.Error("Expected snapshot fingerprint to be set")
+	}
+	if _, ok := cm.Data["lastError"]; ok {
+		t.Errorf("Expected no last error, got %q", cm.Data["lastError"])
+	}
+}
+
+func TestAgentStatusRecordsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		ClusterName: "test-cluster",
+		Elchi: config.ElchiConfig{
+			APIEndpoint: server.URL,
+			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
+		},
+	}
+	log := logger.New(&logger.Config{Level: "error"})
+
+	agent := newAgent(log, discovery.NewService(fake.NewSimpleClientset(), cfg.ClusterName), newAPIClient(cfg, log))
+	agent.runDiscovery(context.Background())
+
+	report := agent.statusReport()
+	if report.LastError == "" || report.LastErrorTime == nil {
+		t.Errorf("Expected last error to be reported, got %+v", report)
+	}
+	if report.LastSuccessfulSend != nil {
+		t.Error("Expected no successful send to be reported")
+	}
+}
+
+func TestAgentRecordsDeliveryEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		ClusterName: "test-cluster",
+		Elchi: config.ElchiConfig{
+			APIEndpoint: server.URL,
+			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
+		},
+	}
+	log := logger.New(&logger.Config{Level: "error"})
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	podRef := &v1.ObjectReference{Kind: "Pod", Namespace: "elchi", Name: "elchi-discovery-0"}
+
+	agent := newAgent(log, discovery.NewService(fake.NewSimpleClientset(), cfg.ClusterName), newAPIClient(cfg, log))
+	agent.events = events.NewRecorder(fakeRecorder, podRef)
+	agent.runDiscovery(context.Background())
+
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.HasPrefix(event, "Warning "+events.ReasonTokenRejected) {
+			t.Errorf("Expected TokenRejected event, got %s", event)
+		}
+	default:
+		t.Error("Expected an event to be recorded")
+	}
+}
+
+func TestAgentSendsStaleSnapshot(t *testing.T) {
+	server, received := directiveServer(t, nil)
+
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+	agent := newDirectiveAgent(server.URL)
+	agent.discovery = discovery.NewService(client, "test-cluster")
+
+	agent.runDiscovery(context.Background())
+
+	client.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+
+	if len(*received) != 3 {
+		t.Fatalf("Expected the snapshot to be resent while discovery fails, got %d requests", len(*received))
+	}
+	first, stale := (*received)[0].Data, (*received)[2].Data
+	if first.Stale != nil {
+		t.Errorf("Expected a fresh first snapshot, got %+v", first.Stale)
+	}
+	if stale.Stale == nil || !strings.Contains(stale.Stale.Error, "connection refused") || stale.Stale.AgeSeconds <= 0 {
+		t.Fatalf("Expected a stale snapshot with age and error, got %+v", stale.Stale)
+	}
+	if stale.NodeCount != 1 || !stale.Timestamp.Equal(first.Timestamp) {
+		t.Errorf("Expected the last known snapshot, got %d nodes at %s", stale.NodeCount, stale.Timestamp)
+	}
+	if !(*received)[1].Data.Stale.Since.Equal(stale.Stale.Since) {
+		t.Error("Expected failing since to stay at the first failure")
+	}
+	if agent.heartbeat.Load().Health != api.HealthDegraded {
+		t.Error("Expected degraded health while discovery fails")
+	}
+
+	// Nothing is resent when serving stale snapshots is disabled
+	agent.serveStale = false
+	agent.runDiscovery(context.Background())
+	if len(*received) != 3 {
+		t.Errorf("Expected no stale snapshot when disabled, got %d requests", len(*received))
+	}
+}
+
+// directiveServer answers every request with the given directives and
+// records the received payloads
+func directiveServer(t *testing.T, directives map[string]interface{}) (*httptest.Server, *[]api.DiscoveryPayload) {
+	t.Helper()
+
+	var received []api.DiscoveryPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.DiscoveryPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result":  map[string]interface{}{"directives": directives},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &received
+}
+
+func newDirectiveAgent(serverURL string) *agent {
+	cfg := &config.Config{
+		ClusterName: "test-cluster",
+		Elchi: config.ElchiConfig{
+			APIEndpoint: serverURL,
+			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
+		},
+	}
+	log := logger.New(&logger.Config{Level: "error"})
+
+	a := newAgent(log, discovery.NewService(fake.NewSimpleClientset(), cfg.ClusterName), newAPIClient(cfg, log))
+	a.remote = &api.DirectiveBounds{
+		MinInterval: 10 * time.Second,
+		MaxInterval: time.Hour,
+		MaxPause:    time.Hour,
+	}
+	return a
+}
+
+func TestAgentAppliesDirectives(t *testing.T) {
+	server, received := directiveServer(t, map[string]interface{}{
+		"id":                 "d-1",
+		"discovery_interval": 5,
+		"include":            []string{"roles"},
+		"node_selector":      "pool=edge",
+		"pause_sending":      true,
+		"pause_seconds":      600,
+	})
+
+	agent := newDirectiveAgent(server.URL)
+	agent.runDiscovery(context.Background())
+
+	if agent.interval != 10*time.Second {
+		t.Errorf("Expected interval clamped to 10s, got %s", agent.interval)
+	}
+	if agent.discovery.NodeSelector() != "pool=edge" {
+		t.Errorf("Expected node selector pool=edge, got %q", agent.discovery.NodeSelector())
+	}
+	if !agent.paused() {
+		t.Error("Expected sending to be paused")
+	}
+
+	// Paused agents keep discovering but do not send
+	agent.runDiscovery(context.Background())
+	if len(*received) != 1 {
+		t.Fatalf("Expected no request while paused, got %d", len(*received))
+	}
+
+	agent.pausedUntil = time.Time{}
+	agent.runDiscovery(context.Background())
+	if len(*received) != 2 {
+		t.Fatalf("Expected a request after the pause, got %d", len(*received))
+	}
+
+	ack := (*received)[1].DirectiveAck
+	if ack == nil || ack.ID != "d-1" || !ack.Applied || ack.DiscoveryInterval != 10 || ack.PausedUntil == nil {
+		t.Errorf("Expected applied ack for d-1, got %+v", ack)
+	}
+}
+
+func TestAgentRejectsInvalidDirectives(t *testing.T) {
+	server, received := directiveServer(t, map[string]interface{}{
+		"id":      "d-2",
+		"include": []string{"labels"},
+	})
+
+	agent := newDirectiveAgent(server.URL)
+	fakeRecorder := record.NewFakeRecorder(10)
+	agent.events = events.NewRecorder(fakeRecorder, &v1.ObjectReference{Kind: "Pod", Namespace: "elchi", Name: "agent"})
+
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+
+	ack := (*received)[1].DirectiveAck
+	if ack == nil || ack.Applied || len(ack.Errors) != 1 {
+		t.Errorf("Expected rejected ack with one error, got %+v", ack)
+	}
+
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.HasPrefix(event, "Warning "+events.ReasonConfigReloadFailed) {
+			t.Errorf("Expected ConfigReloadFailed event, got %s", event)
+		}
+	default:
+		t.Error("Expected ConfigReloadFailed event")
+	}
+}
+
+func TestAgentIgnoresDirectivesWhenDisabled(t *testing.T) {
+	server, received := directiveServer(t, map[string]interface{}{
+		"id":                 "d-3",
+		"discovery_interval": 60,
+	})
+
+	agent := newDirectiveAgent(server.URL)
+	agent.remote = nil
+
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+
+	if agent.interval != 30*time.Second {
+		t.Errorf("Expected interval to stay 30s, got %s", agent.interval)
+	}
+	ack := (*received)[1].DirectiveAck
+	if ack == nil || ack.Applied {
+		t.Errorf("Expected unapplied ack, got %+v", ack)
+	}
+}
+
+func TestAgentResync(t *testing.T) {
+	var initialHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		initialHeaders = append(initialHeaders, r.Header.Get("initial"))
+
+		result := map[string]interface{}{}
+		if len(initialHeaders) == 2 {
+			result["directives"] = map[string]interface{}{"id": "d-4", "resync": true}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	defer server.Close()
+
+	agent := newDirectiveAgent(server.URL)
+	agent.runCycle(context.Background())
+	agent.runCycle(context.Background())
+
+	expected := []string{"true", "false", "true"}
+	if strings.Join(initialHeaders, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected initial headers %v, got %v", expected, initialHeaders)
+	}
+}
+
+func TestAgentRecordsPayloads(t *testing.T) {
+	server, _ := directiveServer(t, nil)
+
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	recorder, err := recording.NewWriter(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	agent := newDirectiveAgent(server.URL)
+	agent.recorder = recorder
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+	agent.pausedUntil = time.Now().Add(time.Hour)
+	agent.runDiscovery(context.Background())
+	recorder.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+	entries, err := recording.Read(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Status != recording.StatusSent || !entries[0].Initial || entries[0].SendDuration <= 0 {
+		t.Errorf("Expected initial sent entry, got %+v", entries[0])
+	}
+	if entries[1].Status != recording.StatusSent || entries[1].Initial {
+		t.Errorf("Expected non-initial sent entry, got %+v", entries[1])
+	}
+	if entries[2].Status != recording.StatusSkipped {
+		t.Errorf("Expected skipped entry while paused, got %+v", entries[2])
+	}
+	if entries[0].Payload == nil || entries[0].Payload.Project != "683b2148ff7e3ae67d825cfa" {
+		t.Errorf("Expected recorded payload, got %+v", entries[0].Payload)
+	}
+}
+
+func TestAgentRecordsStaleSendTime(t *testing.T) {
+	server, _ := directiveServer(t, nil)
+
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	recorder, err := recording.NewWriter(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+	agent := newDirectiveAgent(server.URL)
+	agent.discovery = discovery.NewService(client, "test-cluster")
+	agent.recorder = recorder
+	agent.runDiscovery(context.Background())
+
+	client.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	agent.runDiscovery(context.Background())
+	recorder.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+	entries, err := recording.Read(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The stale resend repeats the snapshot timestamp but is recorded when sent
+	if len(entries) != 2 || entries[1].Payload.Data.Stale == nil {
+		t.Fatalf("Expected a fresh and a stale entry, got %+v", entries)
+	}
+	if !entries[1].Time.After(entries[0].Time) || !entries[1].Time.After(entries[1].Payload.Data.Timestamp) {
+		t.Errorf("Expected the stale entry recorded at its send time, got %s after %s", entries[1].Time, entries[0].Time)
+	}
+}
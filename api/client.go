@@ -2,91 +2,210 @@ package api
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/CloudNativeWorks/elchi-discovery/discovery"
-	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/signing"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/token"
+	"github.com/CloudNativeWorks/elchi-discovery/state"
 )
 
+// Sender delivers discovery data to the Elchi API. Client implements it;
+// callers that only send can depend on Sender and substitute it in tests.
+type Sender interface {
+	SendDiscoveryResult(ctx context.Context, result *discovery.DiscoveryResult) error
+	SendPayload(ctx context.Context, payload *DiscoveryPayload) error
+	SendHeartbeat(ctx context.Context, heartbeat *Heartbeat) error
+}
+
+var _ Sender = (*Client)(nil)
+
 type Client struct {
 	httpClient *http.Client
-	config     *config.Config
-	logger     *logger.Logger
-	// initialCompleted is used to send initial:false after success is received
-	initialCompleted atomic.Bool
+	// endpoints are tried in the order picked by pool, see WithEndpoints
+	endpoints      []string
+	endpointPolicy string
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	pool           *endpointPool
+	token          string
+	// tokenInfo is read from the token once, tokenErr is set for unusable tokens
+	tokenInfo token.Info
+	tokenErr  error
+	// tokenExpiryWarning is how long before expiry warnings start
+	tokenExpiryWarning time.Duration
+	tokenWarnedAt      atomic.Int64
+	heartbeatPath      string
+	insecureSkipVerify bool
+	timeout            time.Duration
+	proxy              ProxyConfig
+	transport          TransportConfig
+	// compression is applied to bodies of at least compressionThreshold bytes
+	compression          string
+	compressionThreshold int
+	// maxPayloadSize splits larger bodies into parts, zero disables splitting
+	maxPayloadSize int
+	// signingSecret signs every request when set
+	signingSecret []byte
+	agentID       string
+	// sequence numbers signed requests, monotonic for the life of the client.
+	// With a state store, sequenceReserved is the highest number persisted
+	// as reserved, always at least the last number signed.
+	sequence         atomic.Uint64
+	sequenceMu       sync.Mutex
+	sequenceReserved uint64
+	logger           *logger.Logger
+	// handshakes maps every endpoint that accepted an initial payload to its
+	// handshake epoch; others are sent initial:true so a failover starts with
+	// a full resync
+	handshakeMu sync.Mutex
+	handshakes  map[string]string
+	// acknowledged is the last snapshot the API accepted
+	acknowledged atomic.Pointer[acknowledgement]
+	// state persists the handshake across restarts, nil keeps it in memory
+	state *state.Store
+	// lastResponse holds the most recent response body decoded from the API
+	lastResponse atomic.Pointer[APIResponse]
+	// directives holds directives received from the API until the agent takes them
+	directives atomic.Pointer[Directives]
+	// ack is attached to payloads until the API has accepted one carrying it
+	ack atomic.Pointer[DirectiveAck]
 }
 
 // DiscoveryPayload wraps the discovery result with project information
 type DiscoveryPayload struct {
-	Project string                     `json:"project"`
-	Data    *discovery.DiscoveryResult `json:"data"`
+	Project      string                     `json:"project"`
+	Data         *discovery.DiscoveryResult `json:"data"`
+	DirectiveAck *DirectiveAck              `json:"directive_ack,omitempty"`
 }
 
 // APIResponse represents the response from the API
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Result  interface{} `json:"result"`
-	Message string      `json:"message"`
-	Error   string      `json:"error"`
+	Success bool `json:"success"`
+	// Result may carry Directives for the agent
+	Result  json.RawMessage `json:"result"`
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
 }
 
-// extractProjectFromToken extracts project ID from token format: "uuid--project"
-func extractProjectFromToken(token string) string {
-	parts := strings.SplitN(token, "--", 2) // Split only on first occurrence
-	if len(parts) == 2 {
-		return parts[1]
+// extractProjectFromToken extracts the project ID from a "uuid--project"
+// token or from the project claim of a JWT
+func extractProjectFromToken(value string) string {
+	info, err := token.Parse(value)
+	if err != nil {
+		return ""
 	}
-	return ""
+	return info.Project
 }
 
-func NewClient(cfg *config.Config, log *logger.Logger) *Client {
-	// Create HTTP client with custom transport
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: cfg.Elchi.InsecureSkipVerify,
-		},
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		timeout:        DefaultTimeout,
+		transport:      DefaultTransportConfig,
+		endpointPolicy: EndpointPolicyFailover,
+		backoff:        DefaultEndpointBackoff,
+		maxBackoff:     DefaultEndpointMaxBackoff,
+
+		tokenExpiryWarning: DefaultTokenExpiryWarning,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.tokenInfo, c.tokenErr = token.Parse(c.token)
+	c.pool = newEndpointPool(c.endpoints, c.endpointPolicy, c.backoff, c.maxBackoff)
 
-	httpClient := &http.Client{
-		Transport: transport,
-		Timeout:   15 * time.Second,
+	if c.logger == nil {
+		c.logger = logger.NewDefault()
 	}
 
-	return &Client{
-		httpClient: httpClient,
-		config:     cfg,
-		logger:     log,
+	if c.httpClient == nil {
+		// Create HTTP client with custom transport
+		c.httpClient = &http.Client{
+			Transport: c.newTransport(),
+			Timeout:   c.timeout,
+		}
 	}
+
+	return c
 }
 
-func (c *Client) SendDiscoveryResult(result *discovery.DiscoveryResult) error {
-	return c.sendDiscoveryResult(result, true)
+// Enabled reports whether an API endpoint is configured
+func (c *Client) Enabled() bool {
+	return c.pool.len() > 0
 }
 
-func (c *Client) GetDiscoveryPayload(result *discovery.DiscoveryResult) (*DiscoveryPayload, error) {
-	// Extract project ID from token
-	projectID := extractProjectFromToken(c.config.Elchi.Token)
-	if projectID == "" {
-		return nil, fmt.Errorf("invalid token format: expected 'uuid--project' format")
-	}
+// ActiveEndpoint returns the endpoint that accepted the last request, or the
+// primary endpoint before any request succeeded
+func (c *Client) ActiveEndpoint() string {
+	return c.pool.activeURL()
+}
 
-	// Create payload with project information
-	return &DiscoveryPayload{
-		Project: projectID,
-		Data:    result,
-	}, nil
+// EndpointStatus returns the health of every configured endpoint
+func (c *Client) EndpointStatus() []EndpointStatus {
+	return c.pool.status()
+}
+
+// Project returns the project ID carried by the token, empty when the token
+// is not in the expected format
+func (c *Client) Project() string {
+	return c.tokenInfo.Project
+}
+
+// LastResponse returns the most recent response decoded from the API, or nil
+// if none has been received yet
+func (c *Client) LastResponse() *APIResponse {
+	return c.lastResponse.Load()
+}
+
+// TakeDirectives returns directives received since the last call, or nil
+func (c *Client) TakeDirectives() *Directives {
+	return c.directives.Swap(nil)
+}
+
+// Acknowledge attaches the outcome of applied directives to the next payloads
+// until one of them is accepted by the API
+func (c *Client) Acknowledge(ack *DirectiveAck) {
+	c.ack.Store(ack)
+}
+
+// HandshakeCompleted reports whether the endpoint the next payload goes to
+// has accepted an initial payload
+func (c *Client) HandshakeCompleted() bool {
+	_, ok := c.handshakeEpoch(c.pool.peek())
+	return ok
+}
+
+// HasPendingAck reports whether a directive acknowledgement awaits delivery
+func (c *Client) HasPendingAck() bool {
+	return c.ack.Load() != nil
+}
+
+// ResetHandshake makes the next payload be sent as initial, which asks the
+// API to treat it as a full resync. The reset is persisted so a restart
+// before the next payload does not restore the dropped handshakes.
+func (c *Client) ResetHandshake(ctx context.Context) {
+	c.handshakeMu.Lock()
+	c.handshakes = nil
+	c.handshakeMu.Unlock()
+
+	for _, endpoint := range c.pool.urls() {
+		c.saveState(ctx, endpoint)
+	}
 }
 
-func (c *Client) sendDiscoveryResult(result *discovery.DiscoveryResult, shouldSend bool) error {
-	// Check if API endpoint is configured and shouldSend is true
-	if c.config.Elchi.APIEndpoint == "" || !shouldSend {
+// SendDiscoveryResult wraps result in a payload for the configured project
+// and sends it
+func (c *Client) SendDiscoveryResult(ctx context.Context, result *discovery.DiscoveryResult) error {
+	// Check if API endpoint is configured
+	if !c.Enabled() {
 		c.logger.Debug("No API endpoint configured, skipping send")
 		return nil
 	}
@@ -97,9 +216,31 @@ func (c *Client) sendDiscoveryResult(result *discovery.DiscoveryResult, shouldSe
 		return err
 	}
 
-	c.logger.Debug("Successfully extracted project from token", map[string]interface{}{
-		"project_id": payload.Project,
-	})
+	return c.SendPayload(ctx, payload)
+}
+
+func (c *Client) GetDiscoveryPayload(result *discovery.DiscoveryResult) (*DiscoveryPayload, error) {
+	// Extract project ID from token
+	if c.tokenErr != nil {
+		return nil, c.tokenErr
+	}
+
+	// Create payload with project information
+	return &DiscoveryPayload{
+		Project:      c.tokenInfo.Project,
+		Data:         result,
+		DirectiveAck: c.ack.Load(),
+	}, nil
+}
+
+// SendPayload posts a payload as is. It is used to send exactly the payload
+// that was printed or recorded, and to replay recorded payloads.
+func (c *Client) SendPayload(ctx context.Context, payload *DiscoveryPayload) error {
+	if !c.Enabled() {
+		c.logger.Debug("No API endpoint configured, skipping send")
+		return nil
+	}
+	c.checkTokenExpiry()
 
 	// Marshal payload to JSON
 	jsonData, err := json.Marshal(payload)
@@ -117,94 +258,233 @@ func (c *Client) sendDiscoveryResult(result *discovery.DiscoveryResult, shouldSe
 		preview += "..."
 	}
 
+	parts, err := c.encodeParts(jsonData)
+	if err != nil {
+		return err
+	}
+
+	encodedSize := 0
+	for _, part := range parts {
+		encodedSize += len(part.body)
+	}
+
 	c.logger.Debug("Sending discovery payload to API", map[string]interface{}{
-		"endpoint":     c.config.Elchi.APIEndpoint,
+		"endpoint":     c.pool.activeURL(),
 		"project":      payload.Project,
 		"payload_size": len(jsonData),
+		"encoded_size": encodedSize,
+		"parts":        len(parts),
 		"json_preview": preview,
 	})
 
-	// Create request
-	req, err := http.NewRequest("POST", c.config.Elchi.APIEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+	var lastErr error
+	for _, endpoint := range c.pool.candidates() {
+		resp, apiResponse, err := c.sendParts(ctx, endpoint, parts)
+		if err != nil {
+			err = fmt.Errorf("failed to send request: %w", err)
+			if ctx.Err() != nil {
+				return err
+			}
+			c.endpointFailed(endpoint, err)
+			lastErr = err
+			continue
+		}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("from-elchi", "yes")
-	if c.initialCompleted.Load() {
-		req.Header.Set("initial", "false")
-	} else {
-		// Send initial:true until success is received
-		req.Header.Set("initial", "true")
-	}
-	if c.config.Elchi.Token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.Elchi.Token))
+		err = c.handleResponse(ctx, endpoint, payload, resp, apiResponse)
+		if shouldFailover(resp.StatusCode) {
+			c.endpointFailed(endpoint, err)
+			lastErr = err
+			continue
+		}
+		c.endpointSucceeded(endpoint)
+		return err
 	}
 
-	// Send request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+	return lastErr
+}
+
+// handleResponse logs the response to a payload sent to endpoint and
+// returns the error it describes, if any
+func (c *Client) handleResponse(ctx context.Context, endpoint string, payload *DiscoveryPayload, resp *http.Response, apiResponse *APIResponse) error {
+	fields := map[string]interface{}{
+		"status_code": resp.StatusCode,
+		"endpoint":    endpoint,
+		"project":     payload.Project,
 	}
-	defer resp.Body.Close()
 
 	// Check HTTP status code first
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		// Try to parse error response
-		var apiResponse APIResponse
-		if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err == nil && apiResponse.Error != "" {
-			c.logger.WithFields(map[string]interface{}{
-				"status_code": resp.StatusCode,
-				"endpoint":    c.config.Elchi.APIEndpoint,
-				"project":     payload.Project,
-				"error":       apiResponse.Error,
-			}).Error("API returned error response")
-			return fmt.Errorf("API error (HTTP %d): %s", resp.StatusCode, apiResponse.Error)
-		} else {
-			c.logger.WithFields(map[string]interface{}{
-				"status_code": resp.StatusCode,
-				"endpoint":    c.config.Elchi.APIEndpoint,
-				"project":     payload.Project,
-			}).Error("API returned non-success HTTP status")
-			return fmt.Errorf("API returned non-success status: %d", resp.StatusCode)
+		apiErr := newAPIError(resp, apiResponse)
+		fields["error"] = apiErr.Message
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			c.logger.WithFields(fields).Error("API rejected the token")
+		case resp.StatusCode == http.StatusTooManyRequests:
+			fields["retry_after"] = apiErr.RetryAfter.String()
+			c.logger.WithFields(fields).Warn("API rate limited the agent")
+		case apiErr.Message != "":
+			c.lastResponse.Store(apiResponse)
+			c.logger.WithFields(fields).Error("API returned error response")
+		default:
+			c.logger.WithFields(fields).Error("API returned non-success HTTP status")
 		}
+		return apiErr
 	}
 
 	// Parse successful response body
-	var apiResponse APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		c.logger.WithFields(map[string]interface{}{
-			"status_code": resp.StatusCode,
-			"endpoint":    c.config.Elchi.APIEndpoint,
-			"project":     payload.Project,
-			"error":       err.Error(),
-		}).Warn("Failed to parse API response, but HTTP status indicates success")
+	if apiResponse == nil {
+		c.logger.WithFields(fields).Warn("Failed to parse API response, but HTTP status indicates success")
 		return nil
 	}
+	c.lastResponse.Store(apiResponse)
 
 	// Log based on response success
-	if apiResponse.Success {
-		// After success, initial:false will be sent
-		c.initialCompleted.Store(true)
-		c.logger.WithFields(map[string]interface{}{
-			"status_code": resp.StatusCode,
-			"endpoint":    c.config.Elchi.APIEndpoint,
-			"project":     payload.Project,
-			"message":     apiResponse.Message,
-		}).Info("Discovery result processed successfully by API")
-	} else {
-		c.logger.WithFields(map[string]interface{}{
-			"status_code": resp.StatusCode,
-			"endpoint":    c.config.Elchi.APIEndpoint,
-			"project":     payload.Project,
-			"error":       apiResponse.Error,
-		}).Error("API reported processing error for discovery result")
+	if !apiResponse.Success {
+		fields["error"] = apiResponse.Error
+		c.logger.WithFields(fields).Error("API reported processing error for discovery result")
 
 		// Return error if API explicitly reported failure
-		return fmt.Errorf("API processing failed: %s", apiResponse.Error)
+		return &APIError{StatusCode: resp.StatusCode, Message: apiResponse.Error}
 	}
 
+	// After success, initial:false will be sent to this endpoint
+	c.completeHandshake(ctx, endpoint, resp.Header.Get(HeaderHandshakeEpoch), payload.Data)
+	if payload.DirectiveAck != nil {
+		c.ack.CompareAndSwap(payload.DirectiveAck, nil)
+	}
+	if directives := parseDirectives(apiResponse.Result); directives != nil {
+		c.directives.Store(directives)
+	}
+	fields["message"] = apiResponse.Message
+	c.logger.WithFields(fields).Info("Discovery result processed successfully by API")
+
 	return nil
 }
+
+// endpointFailed backs endpoint off after a failed request
+func (c *Client) endpointFailed(endpoint string, err error) {
+	backoff := c.pool.failure(endpoint, err)
+	if c.pool.len() < 2 {
+		return
+	}
+	c.logger.WithFields(map[string]interface{}{
+		"endpoint": endpoint,
+		"backoff":  backoff.String(),
+	}).WithError(err).Warn("API endpoint failed")
+}
+
+// endpointSucceeded marks endpoint healthy and logs when the client fails
+// over or returns to an endpoint
+func (c *Client) endpointSucceeded(endpoint string) {
+	changed, recovered := c.pool.success(endpoint)
+	if c.pool.len() < 2 {
+		return
+	}
+	fields := map[string]interface{}{"active_endpoint": endpoint}
+	switch {
+	case changed && c.endpointPolicy == EndpointPolicyFailover:
+		c.logger.WithFields(fields).Info("Active API endpoint changed")
+	case recovered:
+		c.logger.WithFields(fields).Info("API endpoint recovered")
+	}
+}
+
+// postParts posts each part in order and returns the response to the last
+// one, or to the first part the API did not accept.
+func (c *Client) postParts(ctx context.Context, endpoint string, parts []requestPart, headers map[string]string) (*http.Response, *APIResponse, error) {
+	if len(parts) == 1 {
+		return c.post(ctx, endpoint, endpoint, parts[0], headers)
+	}
+
+	snapshotID := newSnapshotID()
+	var resp *http.Response
+	var apiResponse *APIResponse
+	for i, part := range parts {
+		partHeaders := map[string]string{
+			HeaderSnapshotID: snapshotID,
+			HeaderPart:       strconv.Itoa(i + 1),
+			HeaderParts:      strconv.Itoa(len(parts)),
+		}
+		for key, value := range headers {
+			partHeaders[key] = value
+		}
+
+		var err error
+		resp, apiResponse, err = c.post(ctx, endpoint, endpoint, part, partHeaders)
+		if err != nil {
+			return nil, nil, fmt.Errorf("part %d of %d: %w", i+1, len(parts), err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 || (apiResponse != nil && !apiResponse.Success) {
+			return resp, apiResponse, nil
+		}
+	}
+
+	return resp, apiResponse, nil
+}
+
+// post sends body to url, a path of endpoint, with the agent headers and
+// decodes the response envelope. The returned APIResponse is nil when the body
+// is not a valid envelope.
+func (c *Client) post(ctx context.Context, endpoint, url string, body requestPart, headers map[string]string) (*http.Response, *APIResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body.body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("from-elchi", "yes")
+	if body.encoding != "" {
+		req.Header.Set("Content-Encoding", body.encoding)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	}
+	if len(c.signingSecret) > 0 {
+		signing.Sign(req, body.body, c.signingSecret, c.agentID, c.nextSequence(ctx, endpoint), time.Now())
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResponse APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+		return resp, nil, nil
+	}
+	return resp, &apiResponse, nil
+}
+
+// newAPIError describes a non-success response
+func newAPIError(resp *http.Response, apiResponse *APIResponse) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if apiResponse != nil {
+		apiErr.Message = apiResponse.Error
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return apiErr
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
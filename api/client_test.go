@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,22 +11,23 @@ import (
 	"time"
 
 	"github.com/CloudNativeWorks/elchi-discovery/discovery"
-	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
 )
 
 func TestNewClient(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			InsecureSkipVerify: true,
-		},
-	}
 	log := logger.NewDefault()
 
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithInsecureSkipVerify(true),
+		WithLogger(log),
+	)
 
-	if client.config != cfg {
-		t.Error("Expected config to be set")
+	if !client.insecureSkipVerify {
+		t.Error("Expected insecureSkipVerify to be set")
+	}
+	if client.timeout != DefaultTimeout {
+		t.Errorf("Expected default timeout %s, got %s", DefaultTimeout, client.timeout)
 	}
 	if client.logger != log {
 		t.Error("Expected logger to be set")
@@ -73,13 +76,11 @@ func TestExtractProjectFromToken(t *testing.T) {
 }
 
 func TestSendDiscoveryResult_NoEndpoint(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: "", // No endpoint configured
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(""), // No endpoint configured
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -101,21 +102,19 @@ func TestSendDiscoveryResult_NoEndpoint(t *testing.T) {
 		Duration: "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err != nil {
 		t.Errorf("Expected no error when endpoint is not configured, got %v", err)
 	}
 }
 
 func TestSendDiscoveryResult_InvalidToken(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: "https://api.example.com",
-			Token:       "invalid-token-format", // Missing -- separator
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint("https://api.example.com"),
+		WithToken("invalid-token-format"), // Missing -- separator
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp:   time.Now(),
@@ -125,7 +124,7 @@ func TestSendDiscoveryResult_InvalidToken(t *testing.T) {
 		Duration:    "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected error for invalid token format")
 	}
@@ -135,46 +134,17 @@ func TestSendDiscoveryResult_InvalidToken(t *testing.T) {
 }
 
 func TestSendDiscoveryResult_Success(t *testing.T) {
-	// Create test server
-	var receivedPayload DiscoveryPayload
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			t.Errorf("Expected POST method, got %s", r.Method)
-		}
-		if r.Header.Get("Content-Type") != "application/json" {
-			t.Errorf("Expected Content-Type application/json, got %s", r.Header.Get("Content-Type"))
-		}
-		if r.Header.Get("from-elchi") != "yes" {
-			t.Errorf("Expected from-elchi header 'yes', got %s", r.Header.Get("from-elchi"))
-		}
-		if r.Header.Get("Authorization") != "Bearer 96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa" {
-			t.Errorf("Expected Authorization header, got %s", r.Header.Get("Authorization"))
-		}
-
-		// Decode the payload to verify structure
-		if err := json.NewDecoder(r.Body).Decode(&receivedPayload); err != nil {
-			t.Errorf("Failed to decode request body: %v", err)
-		}
-
-		// Send success response
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"result":  receivedPayload,
-			"message": "Discovery processed successfully",
-		})
-	}))
+	// The mock rejects requests without the expected method and headers
+	mock := mockapi.New(mockapi.WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"))
+	server := httptest.NewServer(mock)
 	defer server.Close()
 
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: server.URL,
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -196,12 +166,24 @@ func TestSendDiscoveryResult_Success(t *testing.T) {
 		Duration: "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
 
+	requests := mock.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+	if requests[0].Initial != "true" {
+		t.Errorf("Expected initial header 'true', got %s", requests[0].Initial)
+	}
+
 	// Verify payload structure
+	var receivedPayload DiscoveryPayload
+	if err := requests[0].Decode(&receivedPayload); err != nil {
+		t.Fatalf("Failed to decode request body: %v", err)
+	}
 	if receivedPayload.Project != "683b2148ff7e3ae67d825cfa" {
 		t.Errorf("Expected project '683b2148ff7e3ae67d825cfa', got %s", receivedPayload.Project)
 	}
@@ -215,19 +197,15 @@ func TestSendDiscoveryResult_Success(t *testing.T) {
 
 func TestSendDiscoveryResult_HTTPError(t *testing.T) {
 	// Create test server that returns error
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
+	server := httptest.NewServer(mockapi.New(mockapi.WithSchedule([]mockapi.Fault{{Status: http.StatusInternalServerError}}, true)))
 	defer server.Close()
 
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: server.URL,
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -240,21 +218,19 @@ func TestSendDiscoveryResult_HTTPError(t *testing.T) {
 		Duration:  "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected error for HTTP 500, got nil")
 	}
 }
 
 func TestSendDiscoveryResult_InvalidURL(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: "invalid-url",
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint("invalid-url"),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -267,21 +243,19 @@ func TestSendDiscoveryResult_InvalidURL(t *testing.T) {
 		Duration:  "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected error for invalid URL, got nil")
 	}
 }
 
 func TestSendDiscoveryResult_WithoutToken(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: "https://api.example.com",
-			Token:       "", // No token
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint("https://api.example.com"),
+		WithToken(""), // No token
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -294,7 +268,7 @@ func TestSendDiscoveryResult_WithoutToken(t *testing.T) {
 		Duration:  "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected error for missing token, got nil")
 	}
@@ -304,14 +278,12 @@ func TestSendDiscoveryResult_WithoutToken(t *testing.T) {
 }
 
 func TestSendDiscoveryResult_ServerUnavailable(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: "http://localhost:12345", // Non-existent server
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint("http://localhost:12345"), // Non-existent server
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -324,22 +296,20 @@ func TestSendDiscoveryResult_ServerUnavailable(t *testing.T) {
 		Duration:  "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected error for unavailable server, got nil")
 	}
 }
 
 func TestSendDiscoveryResult_InsecureSkipVerify(t *testing.T) {
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint:        "https://example.com",
-			Token:              "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-			InsecureSkipVerify: true,
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint("https://example.com"),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithInsecureSkipVerify(true),
+		WithLogger(log),
+	)
 
 	// Verify that the client was created with insecure transport
 	if client.httpClient == nil {
@@ -358,14 +328,12 @@ func TestClient_Timeout(t *testing.T) {
 	}))
 	defer server.Close()
 
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: server.URL,
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	// Set a very short timeout for testing
 	client.httpClient.Timeout = 50 * time.Millisecond
@@ -378,8 +346,75 @@ func TestClient_Timeout(t *testing.T) {
 		Duration:    "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected timeout error, got nil")
 	}
 }
+
+func TestSendDiscoveryResult_Signed(t *testing.T) {
+	secret := []byte("shared-secret")
+	mock := mockapi.New(mockapi.WithSigningSecret(secret))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithCompression(CompressionGzip, 0),
+		WithSigningSecret(secret),
+		WithAgentID("agent-1"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	for i := 0; i < 2; i++ {
+		if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+			t.Fatalf("Expected signed request to be accepted, got %v", err)
+		}
+	}
+
+	requests := mock.Requests()
+	for i, req := range requests {
+		if req.AgentID != "agent-1" || req.Sequence != uint64(i+1) || req.SequenceGap != 0 {
+			t.Errorf("Unexpected signed request %d: agent %q sequence %d gap %d", i, req.AgentID, req.Sequence, req.SequenceGap)
+		}
+	}
+
+	// A client with another secret is rejected
+	other := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithSigningSecret([]byte("wrong")),
+		WithAgentID("agent-2"),
+		WithLogger(logger.NewDefault()),
+	)
+	if err := other.SendDiscoveryResult(context.Background(), result); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for a wrong secret, got %v", err)
+	}
+}
+
+func TestSendDiscoveryResult_Unauthorized(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "invalid token",
+			})
+		}))
+
+		client := NewClient(
+			WithEndpoint(server.URL),
+			WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+			WithLogger(logger.NewDefault()),
+		)
+
+		err := client.SendDiscoveryResult(context.Background(), &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}})
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("Expected ErrUnauthorized for HTTP %d, got %v", status, err)
+		}
+		server.Close()
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -9,7 +10,6 @@ import (
 	"time"
 
 	"github.com/CloudNativeWorks/elchi-discovery/discovery"
-	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
 )
 
@@ -27,14 +27,12 @@ func TestSendDiscoveryResult_APIFailureResponse(t *testing.T) {
 	}))
 	defer server.Close()
 
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: server.URL,
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -47,7 +45,7 @@ func TestSendDiscoveryResult_APIFailureResponse(t *testing.T) {
 		Duration:  "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err == nil {
 		t.Error("Expected error for API failure response, got nil")
 	}
@@ -70,14 +68,12 @@ func TestSendDiscoveryResult_SuccessResponse(t *testing.T) {
 	}))
 	defer server.Close()
 
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: server.URL,
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp: time.Now(),
@@ -99,7 +95,7 @@ func TestSendDiscoveryResult_SuccessResponse(t *testing.T) {
 		Duration: "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	if err != nil {
 		t.Errorf("Expected no error for successful API response, got %v", err)
 	}
@@ -113,14 +109,12 @@ func TestSendDiscoveryResult_InvalidJSONResponse(t *testing.T) {
 	}))
 	defer server.Close()
 
-	cfg := &config.Config{
-		Elchi: config.ElchiConfig{
-			APIEndpoint: server.URL,
-			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
-		},
-	}
 	log := logger.NewDefault()
-	client := NewClient(cfg, log)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(log),
+	)
 
 	result := &discovery.DiscoveryResult{
 		Timestamp:   time.Now(),
@@ -130,7 +124,7 @@ func TestSendDiscoveryResult_InvalidJSONResponse(t *testing.T) {
 		Duration:    "100ms",
 	}
 
-	err := client.SendDiscoveryResult(result)
+	err := client.SendDiscoveryResult(context.Background(), result)
 	// Should not error - invalid JSON is handled gracefully with a warning
 	if err != nil {
 		t.Errorf("Expected no error for invalid JSON response (should be handled gracefully), got %v", err)
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Request compression algorithms
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Headers sent with each part of a chunked payload
+const (
+	HeaderSnapshotID = "snapshot-id"
+	HeaderPart       = "part"
+	HeaderParts      = "parts"
+)
+
+// maxParts bounds the number of parts a payload is split into
+const maxParts = 1000
+
+// ValidateCompression checks a configured compression algorithm
+func ValidateCompression(algorithm string) error {
+	switch algorithm {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("invalid compression %q: expected %q, %q or %q", algorithm, CompressionNone, CompressionGzip, CompressionZstd)
+}
+
+// requestPart is a request body with its Content-Encoding
+type requestPart struct {
+	body     []byte
+	encoding string
+}
+
+// encode compresses data when it reaches the compression threshold
+func (c *Client) encode(data []byte) (requestPart, error) {
+	if c.compression == "" || c.compression == CompressionNone || len(data) < c.compressionThreshold {
+		return requestPart{body: data}, nil
+	}
+
+	var buf bytes.Buffer
+	switch c.compression {
+	case CompressionGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return requestPart{}, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return requestPart{}, fmt.Errorf("failed to compress payload: %w", err)
+		}
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return requestPart{}, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			w.Close()
+			return requestPart{}, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return requestPart{}, fmt.Errorf("failed to compress payload: %w", err)
+		}
+	default:
+		return requestPart{}, ValidateCompression(c.compression)
+	}
+
+	return requestPart{body: buf.Bytes(), encoding: c.compression}, nil
+}
+
+// encodeParts encodes data as a single request body, or splits it into
+// ordered parts when the encoded body exceeds the maximum payload size. Parts
+// are byte ranges of the JSON document, each encoded on its own, which the
+// API concatenates in order after decoding.
+func (c *Client) encodeParts(data []byte) ([]requestPart, error) {
+	whole, err := c.encode(data)
+	if err != nil {
+		return nil, err
+	}
+	if c.maxPayloadSize <= 0 || len(whole.body) <= c.maxPayloadSize {
+		return []requestPart{whole}, nil
+	}
+
+	// Start from the number of parts the encoded size suggests and split
+	// further while any part is still too large. The last attempt uses the
+	// largest count allowed, however far doubling would overshoot it.
+	limit := min(maxParts, len(data))
+	count := (len(whole.body) + c.maxPayloadSize - 1) / c.maxPayloadSize
+	for count <= limit {
+		parts, ok, err := c.split(data, count)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return parts, nil
+		}
+		if count == limit {
+			break
+		}
+		count = min(2*count, limit)
+	}
+
+	return nil, fmt.Errorf("payload of %d bytes cannot be split into parts of at most %d bytes", len(data), c.maxPayloadSize)
+}
+
+// split encodes data in count parts and reports whether all of them fit
+func (c *Client) split(data []byte, count int) ([]requestPart, bool, error) {
+	size := (len(data) + count - 1) / count
+	parts := make([]requestPart, 0, count)
+
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		part, err := c.encode(data[start:end])
+		if err != nil {
+			return nil, false, err
+		}
+		if len(part.body) > c.maxPayloadSize {
+			return nil, false, nil
+		}
+		parts = append(parts, part)
+	}
+
+	return parts, true, nil
+}
+
+// newSnapshotID identifies the parts of one chunked payload
+func newSnapshotID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
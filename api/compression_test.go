@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+)
+
+// largeResult returns a result whose JSON is tens of kilobytes
+func largeResult() *discovery.DiscoveryResult {
+	result := &discovery.DiscoveryResult{
+		Timestamp:   time.Now(),
+		ClusterInfo: discovery.ClusterInfo{Name: "test-cluster"},
+	}
+	for i := 0; i < 300; i++ {
+		result.Nodes = append(result.Nodes, discovery.NodeInfo{
+			Name:           fmt.Sprintf("node-%d", i),
+			Status:         "Ready",
+			Roles:          []string{"worker"},
+			PrimaryAddress: fmt.Sprintf("10.0.%d.%d", i/250, i%250),
+		})
+	}
+	result.NodeCount = len(result.Nodes)
+	return result
+}
+
+func TestValidateCompression(t *testing.T) {
+	for _, algorithm := range []string{"", CompressionNone, CompressionGzip, CompressionZstd} {
+		if err := ValidateCompression(algorithm); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", algorithm, err)
+		}
+	}
+	if err := ValidateCompression("brotli"); err == nil {
+		t.Error("Expected error for unsupported compression")
+	}
+}
+
+func TestEncode_Threshold(t *testing.T) {
+	client := NewClient(WithCompression(CompressionGzip, 100))
+
+	part, err := client.encode([]byte(`{"small":true}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if part.encoding != "" {
+		t.Errorf("Expected small body to stay uncompressed, got %q", part.encoding)
+	}
+
+	data := make([]byte, 1000)
+	part, err = client.encode(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if part.encoding != CompressionGzip || len(part.body) >= len(data) {
+		t.Errorf("Expected gzip body smaller than %d bytes, got %q with %d bytes", len(data), part.encoding, len(part.body))
+	}
+}
+
+func TestSendDiscoveryResult_Compressed(t *testing.T) {
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			mock := mockapi.New()
+			server := httptest.NewServer(mock)
+			defer server.Close()
+
+			client := NewClient(
+				WithEndpoint(server.URL),
+				WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+				WithCompression(algorithm, 1024),
+				WithLogger(logger.NewDefault()),
+			)
+
+			if err := client.SendDiscoveryResult(context.Background(), largeResult()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			requests := mock.Requests()
+			if len(requests) != 1 {
+				t.Fatalf("Expected 1 request, got %d", len(requests))
+			}
+			if requests[0].Encoding != algorithm {
+				t.Errorf("Expected Content-Encoding %s, got %q", algorithm, requests[0].Encoding)
+			}
+			var payload DiscoveryPayload
+			if err := requests[0].Decode(&payload); err != nil {
+				t.Fatalf("Failed to decode payload: %v", err)
+			}
+			if payload.Data.NodeCount != 300 {
+				t.Errorf("Expected 300 nodes, got %d", payload.Data.NodeCount)
+			}
+		})
+	}
+}
+
+func TestSendDiscoveryResult_Chunked(t *testing.T) {
+	// Signed parts cover their position and encoding
+	mock := mockapi.New(mockapi.WithSigningSecret([]byte("secret")))
+	mock.SetResult([]byte(`{"directives":{"id":"d-1"}}`))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithCompression(CompressionGzip, 0),
+		WithMaxPayloadSize(1024),
+		WithSigningSecret([]byte("secret")),
+		WithLogger(logger.NewDefault()),
+	)
+
+	if err := client.SendDiscoveryResult(context.Background(), largeResult()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	requests := mock.Requests()
+	if len(requests) < 2 {
+		t.Fatalf("Expected several parts, got %d requests", len(requests))
+	}
+	snapshotID := requests[0].SnapshotID
+	for i, req := range requests {
+		if req.SnapshotID != snapshotID || req.Part != i+1 || req.Parts != len(requests) {
+			t.Errorf("Unexpected part %d: %+v", i, req)
+		}
+		if req.Initial != "true" {
+			t.Errorf("Expected every part to carry initial:true, got %q", req.Initial)
+		}
+	}
+
+	var payload DiscoveryPayload
+	if err := requests[len(requests)-1].Decode(&payload); err != nil {
+		t.Fatalf("Failed to decode reassembled payload: %v", err)
+	}
+	if payload.Data.NodeCount != 300 {
+		t.Errorf("Expected 300 nodes, got %d", payload.Data.NodeCount)
+	}
+	if !client.HandshakeCompleted() {
+		t.Error("Expected handshake to complete after the last part")
+	}
+	if directives := client.TakeDirectives(); directives == nil || directives.ID != "d-1" {
+		t.Errorf("Expected directives from the last part, got %+v", directives)
+	}
+}
+
+func TestEncodeParts_TooLarge(t *testing.T) {
+	client := NewClient(WithMaxPayloadSize(1))
+
+	if _, err := client.encodeParts(make([]byte, 2*maxParts+1)); err == nil {
+		t.Error("Expected error when the payload needs too many parts")
+	}
+}
+
+func TestEncodeParts_MaxParts(t *testing.T) {
+	// The compressed payload suggests far fewer parts than needed, and
+	// doubling that estimate skips over maxParts
+	client := NewClient(WithCompression(CompressionGzip, 2), WithMaxPayloadSize(1))
+
+	parts, err := client.encodeParts(make([]byte, maxParts))
+	if err != nil {
+		t.Fatalf("Expected the payload to be split into %d parts, got %v", maxParts, err)
+	}
+	if len(parts) != maxParts {
+		t.Errorf("Expected %d parts, got %d", maxParts, len(parts))
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Directives are agent settings the Elchi API can return in the "result"
+// field of a response: {"result": {"directives": {...}}}
+type Directives struct {
+	// ID identifies the directive set and is echoed in the acknowledgement
+	ID string `json:"id"`
+	// DiscoveryInterval is the desired discovery interval in seconds
+	DiscoveryInterval int `json:"discovery_interval,omitempty"`
+	// Include limits the optional payload sections; an empty list keeps the current set
+	Include []string `json:"include,omitempty"`
+	// NodeSelector is a label selector for discovered nodes; "" selects all nodes
+	NodeSelector *string `json:"node_selector,omitempty"`
+	// Resync requests an immediate full snapshot sent as initial
+	Resync bool `json:"resync,omitempty"`
+	// PauseSending stops snapshot delivery for PauseSeconds (bounded by the agent)
+	PauseSending bool `json:"pause_sending,omitempty"`
+	PauseSeconds int  `json:"pause_seconds,omitempty"`
+	// AcceptSnapshot lets through a snapshot the agent holds back after a
+	// sudden drop in nodes
+	AcceptSnapshot bool `json:"accept_snapshot,omitempty"`
+}
+
+// DirectiveBounds are the limits the agent enforces on remote settings
+type DirectiveBounds struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	MaxPause    time.Duration
+}
+
+// DirectiveAck reports the outcome of a directive set in the next payload
+type DirectiveAck struct {
+	ID      string    `json:"id"`
+	Applied bool      `json:"applied"`
+	Errors  []string  `json:"errors,omitempty"`
+	At      time.Time `json:"at"`
+	// DiscoveryInterval is the interval in effect after clamping, in seconds
+	DiscoveryInterval int `json:"discovery_interval,omitempty"`
+	// PausedUntil is set while sending is paused
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
+
+// responseResult is the typed form of APIResponse.Result
+type responseResult struct {
+	Directives *Directives `json:"directives"`
+}
+
+// parseDirectives extracts directives from a response result. Results that
+// are not objects, or carry no directives, yield nil.
+func parseDirectives(raw json.RawMessage) *Directives {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var result responseResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil
+	}
+	return result.Directives
+}
+
+// Validate checks the directives and clamps the interval and pause duration
+// into bounds. The returned directives are safe to apply; any error means
+// none of them should be applied.
+func (d *Directives) Validate(bounds DirectiveBounds) (*Directives, []error) {
+	effective := *d
+	var errs []error
+
+	if d.DiscoveryInterval < 0 {
+		errs = append(errs, fmt.Errorf("discovery_interval must be positive, got %d", d.DiscoveryInterval))
+	} else if d.DiscoveryInterval > 0 {
+		interval := time.Duration(d.DiscoveryInterval) * time.Second
+		interval = max(bounds.MinInterval, min(interval, bounds.MaxInterval))
+		effective.DiscoveryInterval = int(interval / time.Second)
+	}
+
+	for _, section := range d.Include {
+		if !slices.Contains(discovery.Sections, section) {
+			errs = append(errs, fmt.Errorf("unknown section %q", section))
+		}
+	}
+
+	if d.NodeSelector != nil {
+		if _, err := labels.Parse(*d.NodeSelector); err != nil {
+			errs = append(errs, fmt.Errorf("invalid node_selector %q: %w", *d.NodeSelector, err))
+		}
+	}
+
+	if d.PauseSending && bounds.MaxPause <= 0 {
+		errs = append(errs, errors.New("pause_sending is disabled by the agent (max_pause is 0)"))
+	} else if d.PauseSending {
+		pause := time.Duration(d.PauseSeconds) * time.Second
+		if pause <= 0 || pause > bounds.MaxPause {
+			pause = bounds.MaxPause
+		}
+		effective.PauseSeconds = int(pause / time.Second)
+	}
+
+	return &effective, errs
+}
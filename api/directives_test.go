@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+)
+
+var testBounds = DirectiveBounds{
+	MinInterval: 10 * time.Second,
+	MaxInterval: time.Hour,
+	MaxPause:    30 * time.Minute,
+}
+
+func TestParseDirectives(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{name: "empty", raw: "", expected: ""},
+		{name: "null", raw: "null", expected: ""},
+		{name: "string result", raw: `"accepted"`, expected: ""},
+		{name: "object without directives", raw: `{"nodes": 3}`, expected: ""},
+		{name: "directives", raw: `{"directives": {"id": "d-1", "discovery_interval": 60}}`, expected: "d-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directives := parseDirectives(json.RawMessage(tt.raw))
+			if tt.expected == "" {
+				if directives != nil {
+					t.Errorf("Expected no directives, got %+v", directives)
+				}
+				return
+			}
+			if directives == nil || directives.ID != tt.expected {
+				t.Errorf("Expected directives %s, got %+v", tt.expected, directives)
+			}
+		})
+	}
+}
+
+func TestDirectivesValidate(t *testing.T) {
+	selector := "pool=edge"
+	invalidSelector := "pool in (edge"
+
+	tests := []struct {
+		name             string
+		directives       Directives
+		expectErrs       int
+		expectedInterval int
+		expectedPause    int
+	}{
+		{
+			name:             "interval within bounds",
+			directives:       Directives{DiscoveryInterval: 60, NodeSelector: &selector},
+			expectedInterval: 60,
+		},
+		{
+			name:             "interval clamped to minimum",
+			directives:       Directives{DiscoveryInterval: 1},
+			expectedInterval: 10,
+		},
+		{
+			name:             "interval clamped to maximum",
+			directives:       Directives{DiscoveryInterval: 86400},
+			expectedInterval: 3600,
+		},
+		{
+			name:          "pause defaults to maximum",
+			directives:    Directives{PauseSending: true},
+			expectedPause: 1800,
+		},
+		{
+			name:          "pause within bounds",
+			directives:    Directives{PauseSending: true, PauseSeconds: 120},
+			expectedPause: 120,
+		},
+		{
+			name:       "invalid entries",
+			directives: Directives{DiscoveryInterval: -5, Include: []string{discovery.SectionRoles, "labels"}, NodeSelector: &invalidSelector},
+			expectErrs: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effective, errs := tt.directives.Validate(testBounds)
+			if len(errs) != tt.expectErrs {
+				t.Fatalf("Expected %d errors, got %v", tt.expectErrs, errs)
+			}
+			if tt.expectErrs > 0 {
+				return
+			}
+			if effective.DiscoveryInterval != tt.expectedInterval {
+				t.Errorf("Expected interval %d, got %d", tt.expectedInterval, effective.DiscoveryInterval)
+			}
+			if effective.PauseSeconds != tt.expectedPause {
+				t.Errorf("Expected pause %d, got %d", tt.expectedPause, effective.PauseSeconds)
+			}
+		})
+	}
+}
+
+func TestDirectivesValidate_PauseDisabled(t *testing.T) {
+	bounds := testBounds
+	bounds.MaxPause = 0
+
+	// A pause the agent does not allow is rejected rather than acked as a no-op
+	if _, errs := (&Directives{PauseSending: true, PauseSeconds: 60}).Validate(bounds); len(errs) != 1 {
+		t.Errorf("Expected pause_sending to be rejected with max_pause 0, got %v", errs)
+	}
+	if _, errs := (&Directives{DiscoveryInterval: 60}).Validate(bounds); len(errs) != 0 {
+		t.Errorf("Expected directives without a pause to be accepted, got %v", errs)
+	}
+}
+
+func TestSendDiscoveryResult_DirectivesAndAck(t *testing.T) {
+	var received []DiscoveryPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload DiscoveryPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result": map[string]interface{}{
+				"directives": map[string]interface{}{"id": "d-1", "discovery_interval": 60},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("SendDiscoveryResult() error = %v", err)
+	}
+
+	directives := client.TakeDirectives()
+	if directives == nil || directives.ID != "d-1" || directives.DiscoveryInterval != 60 {
+		t.Fatalf("Expected directives d-1, got %+v", directives)
+	}
+	if client.TakeDirectives() != nil {
+		t.Error("Expected directives to be consumed")
+	}
+
+	client.Acknowledge(&DirectiveAck{ID: "d-1", Applied: true})
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("SendDiscoveryResult() error = %v", err)
+	}
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("SendDiscoveryResult() error = %v", err)
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(received))
+	}
+	if received[0].DirectiveAck != nil {
+		t.Error("Expected no ack before directives were applied")
+	}
+	if received[1].DirectiveAck == nil || received[1].DirectiveAck.ID != "d-1" || !received[1].DirectiveAck.Applied {
+		t.Errorf("Expected ack for d-1 in second payload, got %+v", received[1].DirectiveAck)
+	}
+	if received[2].DirectiveAck != nil {
+		t.Error("Expected ack to be cleared once accepted")
+	}
+}
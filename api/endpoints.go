@@ -0,0 +1,275 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Endpoint selection policies
+const (
+	// EndpointPolicyFailover sends to the first healthy endpoint in the
+	// configured order and returns to the primary once it has recovered
+	EndpointPolicyFailover = "failover"
+	// EndpointPolicyRoundRobin spreads requests over the healthy endpoints
+	EndpointPolicyRoundRobin = "round_robin"
+)
+
+// Default backoff of an endpoint after failed requests
+const (
+	DefaultEndpointBackoff    = 5 * time.Second
+	DefaultEndpointMaxBackoff = 5 * time.Minute
+)
+
+// ValidateEndpointPolicy reports whether policy is a supported selection policy
+func ValidateEndpointPolicy(policy string) error {
+	switch policy {
+	case EndpointPolicyFailover, EndpointPolicyRoundRobin:
+		return nil
+	default:
+		return fmt.Errorf("unsupported endpoint policy %q, expected %s or %s",
+			policy, EndpointPolicyFailover, EndpointPolicyRoundRobin)
+	}
+}
+
+// EndpointStatus is the health of one API endpoint as seen by the client
+type EndpointStatus struct {
+	URL                 string     `json:"url"`
+	Active              bool       `json:"active"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+type endpointState struct {
+	url       string
+	failures  int
+	retryAt   time.Time
+	lastError string
+}
+
+// endpointPool tracks endpoint health and picks the endpoints to try for a
+// request. An endpoint that fails is skipped until its backoff has elapsed,
+// the backoff doubling with every consecutive failure up to maxBackoff.
+type endpointPool struct {
+	mu         sync.Mutex
+	endpoints  []*endpointState
+	policy     string
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// active is the endpoint that accepted the last request, -1 before that
+	active int
+	// next is the round robin position
+	next int
+	now  func() time.Time
+}
+
+func newEndpointPool(urls []string, policy string, backoff, maxBackoff time.Duration) *endpointPool {
+	p := &endpointPool{
+		policy:     policy,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		active:     -1,
+		now:        time.Now,
+	}
+	if p.policy == "" {
+		p.policy = EndpointPolicyFailover
+	}
+	if p.backoff <= 0 {
+		p.backoff = DefaultEndpointBackoff
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+	for _, url := range urls {
+		if url != "" {
+			p.endpoints = append(p.endpoints, &endpointState{url: url})
+		}
+	}
+	return p
+}
+
+func (p *endpointPool) len() int {
+	return len(p.endpoints)
+}
+
+// urls returns the endpoints in configured order
+func (p *endpointPool) urls() []string {
+	urls := make([]string, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		urls = append(urls, e.url)
+	}
+	return urls
+}
+
+// candidates returns the endpoints to try in order. Healthy endpoints come
+// first; endpoints in backoff follow, soonest retry first, so a request is
+// still attempted when every endpoint is failing.
+func (p *endpointPool) candidates() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	urls := p.order()
+	if p.policy == EndpointPolicyRoundRobin {
+		p.next++
+	}
+	return urls
+}
+
+// peek returns the endpoint the next request is sent to first, without
+// advancing the round robin position
+func (p *endpointPool) peek() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if urls := p.order(); len(urls) > 0 {
+		return urls[0]
+	}
+	return ""
+}
+
+// order lists the endpoints in the order they are tried, p.mu held
+func (p *endpointPool) order() []string {
+	if len(p.endpoints) == 0 {
+		return nil
+	}
+
+	order := make([]*endpointState, 0, len(p.endpoints))
+	start := 0
+	if p.policy == EndpointPolicyRoundRobin {
+		start = p.next % len(p.endpoints)
+	}
+	for i := range p.endpoints {
+		order = append(order, p.endpoints[(start+i)%len(p.endpoints)])
+	}
+
+	now := p.now()
+	var healthy, backingOff []*endpointState
+	for _, e := range order {
+		if e.retryAt.After(now) {
+			backingOff = append(backingOff, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	// Stable insertion sort, there are only a handful of endpoints
+	for i := 1; i < len(backingOff); i++ {
+		for j := i; j > 0 && backingOff[j].retryAt.Before(backingOff[j-1].retryAt); j-- {
+			backingOff[j], backingOff[j-1] = backingOff[j-1], backingOff[j]
+		}
+	}
+
+	urls := make([]string, 0, len(order))
+	for _, e := range append(healthy, backingOff...) {
+		urls = append(urls, e.url)
+	}
+	return urls
+}
+
+// success marks url healthy and active. It reports whether a different
+// endpoint was active before and whether url was recovering from failures.
+func (p *endpointPool) success(url string) (changed, recovered bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.index(url)
+	if i < 0 {
+		return false, false
+	}
+	e := p.endpoints[i]
+	recovered = e.failures > 0
+	e.failures = 0
+	e.retryAt = time.Time{}
+	e.lastError = ""
+
+	changed = p.active >= 0 && p.active != i
+	p.active = i
+	return changed, recovered
+}
+
+// failure records a failed request to url and returns how long it is
+// skipped. A Retry-After from the API extends the backoff.
+func (p *endpointPool) failure(url string, err error) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.index(url)
+	if i < 0 {
+		return 0
+	}
+	e := p.endpoints[i]
+	e.failures++
+
+	backoff := p.backoff
+	for n := 1; n < e.failures && backoff < p.maxBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > backoff {
+		backoff = apiErr.RetryAfter
+	}
+
+	e.retryAt = p.now().Add(backoff)
+	e.lastError = err.Error()
+	return backoff
+}
+
+// activeURL returns the endpoint that accepted the last request, or the
+// first configured endpoint before any request succeeded
+func (p *endpointPool) activeURL() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.endpoints) == 0 {
+		return ""
+	}
+	if p.active < 0 {
+		return p.endpoints[0].url
+	}
+	return p.endpoints[p.active].url
+}
+
+func (p *endpointPool) status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	statuses := make([]EndpointStatus, 0, len(p.endpoints))
+	for i, e := range p.endpoints {
+		status := EndpointStatus{
+			URL:                 e.url,
+			Active:              i == p.active,
+			Healthy:             e.failures == 0,
+			ConsecutiveFailures: e.failures,
+			LastError:           e.lastError,
+		}
+		if e.retryAt.After(now) {
+			retryAt := e.retryAt
+			status.RetryAt = &retryAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (p *endpointPool) index(url string) int {
+	for i, e := range p.endpoints {
+		if e.url == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// shouldFailover reports whether a response status means another endpoint
+// may succeed. Client errors such as a rejected token are returned at once,
+// the other endpoints would reject the request the same way.
+func shouldFailover(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+)
+
+func TestEndpointPool_Failover(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pool := newEndpointPool([]string{"primary", "secondary"}, EndpointPolicyFailover, time.Second, 4*time.Second)
+	pool.now = func() time.Time { return now }
+
+	if got := pool.candidates(); !reflect.DeepEqual(got, []string{"primary", "secondary"}) {
+		t.Errorf("Expected primary first, got %v", got)
+	}
+
+	var backoffs []time.Duration
+	for i := 0; i < 4; i++ {
+		backoffs = append(backoffs, pool.failure("primary", errors.New("connection refused")))
+	}
+	if !reflect.DeepEqual(backoffs, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}) {
+		t.Errorf("Expected doubling backoff capped at 4s, got %v", backoffs)
+	}
+	if got := pool.candidates(); !reflect.DeepEqual(got, []string{"secondary", "primary"}) {
+		t.Errorf("Expected secondary first while primary backs off, got %v", got)
+	}
+
+	changed, _ := pool.success("secondary")
+	if changed || pool.activeURL() != "secondary" {
+		t.Errorf("Expected secondary to become active, got %s", pool.activeURL())
+	}
+
+	// The primary is tried again, and preferred, once its backoff elapsed
+	now = now.Add(5 * time.Second)
+	if got := pool.candidates(); !reflect.DeepEqual(got, []string{"primary", "secondary"}) {
+		t.Errorf("Expected primary first after backoff, got %v", got)
+	}
+	changed, recovered := pool.success("primary")
+	if !changed || !recovered {
+		t.Errorf("Expected return to the recovered primary, got changed=%v recovered=%v", changed, recovered)
+	}
+
+	status := pool.status()
+	if !status[0].Active || !status[0].Healthy || status[1].Active {
+		t.Errorf("Expected healthy active primary, got %+v", status)
+	}
+}
+
+func TestEndpointPool_RetryAfter(t *testing.T) {
+	pool := newEndpointPool([]string{"primary"}, EndpointPolicyFailover, time.Second, time.Minute)
+
+	backoff := pool.failure("primary", &APIError{StatusCode: 429, RetryAfter: 30 * time.Second})
+	if backoff != 30*time.Second {
+		t.Errorf("Expected Retry-After to extend the backoff to 30s, got %s", backoff)
+	}
+	status := pool.status()
+	if status[0].Healthy || status[0].RetryAt == nil || status[0].ConsecutiveFailures != 1 {
+		t.Errorf("Expected backing off endpoint, got %+v", status[0])
+	}
+
+	// Every endpoint is still tried when all of them are failing
+	if got := pool.candidates(); !reflect.DeepEqual(got, []string{"primary"}) {
+		t.Errorf("Expected failing endpoint to remain a candidate, got %v", got)
+	}
+}
+
+func TestEndpointPool_RoundRobin(t *testing.T) {
+	pool := newEndpointPool([]string{"a", "b", "c"}, EndpointPolicyRoundRobin, time.Second, time.Second)
+
+	var firsts []string
+	for i := 0; i < 4; i++ {
+		firsts = append(firsts, pool.candidates()[0])
+	}
+	if !reflect.DeepEqual(firsts, []string{"a", "b", "c", "a"}) {
+		t.Errorf("Expected rotation a, b, c, a, got %v", firsts)
+	}
+
+	pool.failure("b", errors.New("timeout"))
+	if got := pool.candidates(); !reflect.DeepEqual(got, []string{"c", "a", "b"}) {
+		t.Errorf("Expected failing endpoint last, got %v", got)
+	}
+}
+
+func TestValidateEndpointPolicy(t *testing.T) {
+	for _, policy := range []string{EndpointPolicyFailover, EndpointPolicyRoundRobin} {
+		if err := ValidateEndpointPolicy(policy); err != nil {
+			t.Errorf("Expected %s to be valid, got %v", policy, err)
+		}
+	}
+	if err := ValidateEndpointPolicy("random"); err == nil {
+		t.Error("Expected error for unsupported policy")
+	}
+}
+
+func TestSendDiscoveryResult_Failover(t *testing.T) {
+	failing, _ := mockapi.ParseSchedule("503")
+	primaryAPI := mockapi.New(mockapi.WithSchedule(failing, true))
+	primary := httptest.NewServer(primaryAPI)
+	defer primary.Close()
+	secondaryAPI := mockapi.New()
+	secondary := httptest.NewServer(secondaryAPI)
+	defer secondary.Close()
+
+	client := NewClient(
+		WithEndpoints(primary.URL, secondary.URL),
+		WithEndpointBackoff(10*time.Millisecond, 10*time.Millisecond),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Expected failover to the secondary, got %v", err)
+	}
+	if client.ActiveEndpoint() != secondary.URL {
+		t.Errorf("Expected active endpoint %s, got %s", secondary.URL, client.ActiveEndpoint())
+	}
+	if requests := secondaryAPI.Requests(); len(requests) != 1 || requests[0].Initial != "true" {
+		t.Errorf("Expected one initial request on the secondary, got %+v", requests)
+	}
+
+	// While the primary backs off the secondary is used directly
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(primaryAPI.Requests()) != 1 {
+		t.Errorf("Expected the backing off primary to be skipped, got %d requests", len(primaryAPI.Requests()))
+	}
+
+	// The recovered primary takes over again and gets a full resync
+	primaryAPI.SetSchedule(nil, false)
+	time.Sleep(20 * time.Millisecond)
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.ActiveEndpoint() != primary.URL {
+		t.Errorf("Expected return to the primary, got %s", client.ActiveEndpoint())
+	}
+	requests := primaryAPI.Requests()
+	if last := requests[len(requests)-1]; last.Initial != "true" || last.Status != 200 {
+		t.Errorf("Expected an accepted initial request on the primary, got %+v", last)
+	}
+	if !client.HandshakeCompleted() {
+		t.Error("Expected handshake with the primary to be completed")
+	}
+}
+
+func TestSendDiscoveryResult_NoFailoverOnClientError(t *testing.T) {
+	rejected, _ := mockapi.ParseSchedule("401")
+	primaryAPI := mockapi.New(mockapi.WithSchedule(rejected, true))
+	primary := httptest.NewServer(primaryAPI)
+	defer primary.Close()
+	secondaryAPI := mockapi.New()
+	secondary := httptest.NewServer(secondaryAPI)
+	defer secondary.Close()
+
+	client := NewClient(
+		WithEndpoints(primary.URL, secondary.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	if err := client.SendDiscoveryResult(context.Background(), result); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+	if len(secondaryAPI.Requests()) != 0 {
+		t.Error("Expected no request on the secondary after a client error")
+	}
+}
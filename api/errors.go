@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned when the API rejects the configured token
+	ErrUnauthorized = errors.New("token rejected by API")
+	// ErrRateLimited is returned when the API asks the agent to slow down
+	ErrRateLimited = errors.New("rate limited by API")
+)
+
+// APIError is returned when the API answers with a non-success status or a
+// success:false body. It wraps ErrUnauthorized or ErrRateLimited where they
+// apply, so callers can use errors.Is as well as errors.As.
+type APIError struct {
+	StatusCode int
+	// Message is the error reported by the server, if any
+	Message string
+	// RetryAfter is the delay requested by a 429 response, zero if none
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("API returned non-success status: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("API error (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case 401, 403:
+		return ErrUnauthorized
+	case 429:
+		return ErrRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+)
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *APIError
+		message  string
+		sentinel error
+	}{
+		{"unauthorized", &APIError{StatusCode: 401, Message: "invalid token"}, "API error (HTTP 401): invalid token", ErrUnauthorized},
+		{"forbidden", &APIError{StatusCode: 403}, "API returned non-success status: 403", ErrUnauthorized},
+		{"rate limited", &APIError{StatusCode: 429}, "API returned non-success status: 429", ErrRateLimited},
+		{"server error", &APIError{StatusCode: 500, Message: "boom"}, "API error (HTTP 500): boom", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err.Error() != tt.message {
+				t.Errorf("Expected %q, got %q", tt.message, tt.err.Error())
+			}
+			if tt.sentinel != nil && !errors.Is(tt.err, tt.sentinel) {
+				t.Errorf("Expected error to match %v", tt.sentinel)
+			}
+			if tt.sentinel == nil && (errors.Is(tt.err, ErrUnauthorized) || errors.Is(tt.err, ErrRateLimited)) {
+				t.Error("Expected error not to match a sentinel")
+			}
+		})
+	}
+}
+
+func TestSendDiscoveryResult_TypedErrors(t *testing.T) {
+	schedule, _ := mockapi.ParseSchedule("429+retry=7s,503,fail")
+	server := httptest.NewServer(mockapi.New(mockapi.WithSchedule(schedule, false)))
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	err := client.SendDiscoveryResult(context.Background(), result)
+	var apiErr *APIError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &apiErr) || apiErr.RetryAfter != 7*time.Second {
+		t.Errorf("Expected rate limited APIError with 7s retry, got %v (%+v)", err, apiErr)
+	}
+
+	err = client.SendDiscoveryResult(context.Background(), result)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Message != "Service Unavailable" {
+		t.Errorf("Expected APIError 503 with server message, got %v", err)
+	}
+
+	err = client.SendDiscoveryResult(context.Background(), result)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusOK || apiErr.Message != "injected failure" {
+		t.Errorf("Expected APIError for success:false, got %v", err)
+	}
+}
+
+func TestSendDiscoveryResult_ContextCancelled(t *testing.T) {
+	schedule, _ := mockapi.ParseSchedule("ok+delay=5s")
+	server := httptest.NewServer(mockapi.New(mockapi.WithSchedule(schedule, false)))
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.SendDiscoveryResult(ctx, &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected request to be cancelled promptly, took %s", time.Since(start))
+	}
+}
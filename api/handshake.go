@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/state"
+)
+
+// HeaderHandshakeEpoch carries the API's identifier of a handshake. The API
+// may return it when accepting an initial payload; the client then sends it
+// with every non-initial payload, and the API answers 409 Conflict when it no
+// longer knows the epoch, which invalidates the stored handshake.
+const HeaderHandshakeEpoch = "elchi-handshake-epoch"
+
+// Errors the API returns with 409 Conflict to invalidate a handshake. Other
+// conflicts, such as a rejected request sequence, leave the handshake alone.
+const (
+	ErrUnknownHandshakeEpoch  = "unknown handshake epoch"
+	ErrInitialPayloadRequired = "initial payload required"
+)
+
+// sequenceBlock is how many signing sequence numbers are reserved in the state
+// store at a time
+const sequenceBlock = 100
+
+// acknowledgement is a snapshot accepted by the API
+type acknowledgement struct {
+	fingerprint string
+	at          time.Time
+}
+
+// LastAcknowledged returns the fingerprint and time of the last snapshot
+// accepted by the API, including one restored from the state store
+func (c *Client) LastAcknowledged() (string, time.Time) {
+	ack := c.acknowledged.Load()
+	if ack == nil {
+		return "", time.Time{}
+	}
+	return ack.fingerprint, ack.at
+}
+
+// RestoreState loads the handshake state stored by a previous run. Every
+// configured endpoint with a completed handshake is resumed, so the next
+// payload to it is not sent as initial. The signing agent ID and sequence are
+// resumed too so the API keeps accepting the signed requests. It must be
+// called before the first request.
+func (c *Client) RestoreState(ctx context.Context) error {
+	if c.state == nil || c.tokenErr != nil {
+		return nil
+	}
+
+	var entries []*state.Entry
+	var latest *state.Entry
+	for _, endpoint := range c.pool.urls() {
+		entry, err := c.state.Get(ctx, endpoint, c.tokenInfo.Project)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if latest == nil || entry.UpdatedAt.After(latest.UpdatedAt) {
+			latest = entry
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	// The API tracks the sequence per agent ID, a new ID starts over
+	if latest.AgentID != "" {
+		c.agentID = latest.AgentID
+	}
+	for _, entry := range entries {
+		if entry.AgentID == c.agentID && entry.Sequence > c.sequence.Load() {
+			c.sequence.Store(entry.Sequence)
+			c.sequenceReserved = entry.Sequence
+		}
+		if entry.HandshakeCompleted {
+			c.setHandshake(entry.Endpoint, entry.Epoch)
+		}
+	}
+	if latest.Fingerprint != "" {
+		c.acknowledged.Store(&acknowledgement{fingerprint: latest.Fingerprint, at: latest.AcknowledgedAt})
+	}
+
+	c.logger.WithFields(map[string]interface{}{
+		"endpoints":   len(entries),
+		"project":     latest.Project,
+		"agent_id":    c.agentID,
+		"fingerprint": latest.Fingerprint,
+		"sequence":    c.sequence.Load(),
+	}).Info("Restored handshake state")
+	return nil
+}
+
+// handshakeEpoch returns the epoch of the handshake with endpoint and whether
+// endpoint has accepted an initial payload
+func (c *Client) handshakeEpoch(endpoint string) (string, bool) {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	epoch, ok := c.handshakes[endpoint]
+	return epoch, ok
+}
+
+func (c *Client) setHandshake(endpoint, epoch string) {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if c.handshakes == nil {
+		c.handshakes = make(map[string]string)
+	}
+	c.handshakes[endpoint] = epoch
+}
+
+// invalidateHandshake forgets the handshake with endpoint unless it was
+// replaced since epoch was sent
+func (c *Client) invalidateHandshake(endpoint, epoch string) {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if current, ok := c.handshakes[endpoint]; ok && current == epoch {
+		delete(c.handshakes, endpoint)
+	}
+}
+
+// handshakeInvalidated reports whether the API rejected a non-initial payload
+// because it no longer knows the handshake
+func handshakeInvalidated(resp *http.Response, apiResponse *APIResponse) bool {
+	if resp.StatusCode != http.StatusConflict || apiResponse == nil {
+		return false
+	}
+	return apiResponse.Error == ErrUnknownHandshakeEpoch || apiResponse.Error == ErrInitialPayloadRequired
+}
+
+// sendParts posts parts to endpoint as initial until the endpoint has
+// accepted a payload. When the API answers that it no longer knows the
+// handshake, the parts are resent as initial.
+func (c *Client) sendParts(ctx context.Context, endpoint string, parts []requestPart) (*http.Response, *APIResponse, error) {
+	epoch, ok := c.handshakeEpoch(endpoint)
+	if !ok {
+		return c.postParts(ctx, endpoint, parts, map[string]string{"initial": "true"})
+	}
+
+	headers := map[string]string{"initial": "false"}
+	if epoch != "" {
+		headers[HeaderHandshakeEpoch] = epoch
+	}
+	resp, apiResponse, err := c.postParts(ctx, endpoint, parts, headers)
+	if err != nil || !handshakeInvalidated(resp, apiResponse) {
+		return resp, apiResponse, err
+	}
+
+	c.logger.WithFields(map[string]interface{}{
+		"endpoint": endpoint,
+		"epoch":    epoch,
+		"error":    apiResponse.Error,
+	}).Warn("API invalidated the handshake, resending as initial payload")
+	c.invalidateHandshake(endpoint, epoch)
+	c.saveState(ctx, endpoint)
+
+	return c.postParts(ctx, endpoint, parts, map[string]string{"initial": "true"})
+}
+
+// completeHandshake records that endpoint accepted a payload for result
+func (c *Client) completeHandshake(ctx context.Context, endpoint, epoch string, result *discovery.DiscoveryResult) {
+	// Keep the epoch of the ongoing handshake when the API does not repeat it
+	if current, ok := c.handshakeEpoch(endpoint); epoch == "" && ok {
+		epoch = current
+	}
+	c.setHandshake(endpoint, epoch)
+	if result != nil {
+		c.acknowledged.Store(&acknowledgement{fingerprint: result.Fingerprint(), at: time.Now()})
+	}
+	c.saveState(ctx, endpoint)
+}
+
+// nextSequence returns the sequence number of the next signed request. With a
+// state store, numbers are reserved in blocks whose end is persisted before
+// the first of them is signed, so a restarted agent resumes above every
+// number the API has seen, whatever requests were sent since the last save.
+func (c *Client) nextSequence(ctx context.Context, endpoint string) uint64 {
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+
+	sequence := c.sequence.Add(1)
+	if c.state != nil && sequence > c.sequenceReserved {
+		c.sequenceReserved = sequence + sequenceBlock - 1
+		c.putState(ctx, endpoint, c.sequenceReserved)
+	}
+	return sequence
+}
+
+// saveState persists the handshake state for endpoint. Failures are logged,
+// the state only saves a resync after a restart.
+func (c *Client) saveState(ctx context.Context, endpoint string) {
+	if c.state == nil {
+		return
+	}
+
+	c.sequenceMu.Lock()
+	reserved := c.sequenceReserved
+	c.sequenceMu.Unlock()
+	c.putState(ctx, endpoint, reserved)
+}
+
+// putState writes the state entry for endpoint with the reserved sequence
+func (c *Client) putState(ctx context.Context, endpoint string, sequence uint64) {
+	entry := state.Entry{
+		Endpoint:  endpoint,
+		Project:   c.tokenInfo.Project,
+		AgentID:   c.agentID,
+		Sequence:  sequence,
+		UpdatedAt: time.Now(),
+	}
+	entry.Epoch, entry.HandshakeCompleted = c.handshakeEpoch(endpoint)
+	if ack := c.acknowledged.Load(); ack != nil {
+		entry.Fingerprint = ack.fingerprint
+		entry.AcknowledgedAt = ack.at
+	}
+
+	if err := c.state.Put(ctx, entry); err != nil {
+		c.logger.WithError(err).WithField("endpoint", endpoint).Warn("Failed to persist handshake state")
+	}
+}
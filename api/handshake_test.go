@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+	"github.com/CloudNativeWorks/elchi-discovery/state"
+)
+
+func TestHandshakeState_Restore(t *testing.T) {
+	mock := mockapi.New(mockapi.WithRequireInitial(true), mockapi.WithSigningSecret([]byte("secret")))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	newClient := func(agentID string) *Client {
+		return NewClient(
+			WithEndpoint(server.URL),
+			WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+			WithSigningSecret([]byte("secret")),
+			WithAgentID(agentID),
+			WithStateStore(state.NewFileStore(path)),
+			WithLogger(logger.NewDefault()),
+		)
+	}
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{{Name: "node-1"}}}
+
+	first := newClient("first-run")
+	if err := first.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A restarted agent resumes the handshake and the signing agent ID
+	restarted := newClient("second-run")
+	if err := restarted.RestoreState(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restarted.HandshakeCompleted() {
+		t.Fatal("Expected restored handshake")
+	}
+	if fingerprint, _ := restarted.LastAcknowledged(); fingerprint != result.Fingerprint() {
+		t.Errorf("Expected restored fingerprint %s, got %s", result.Fingerprint(), fingerprint)
+	}
+	if err := restarted.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	if requests[1].Initial != "false" || requests[1].HandshakeEpoch != requests[0].HandshakeEpoch || requests[1].HandshakeEpoch == "" {
+		t.Errorf("Expected non-initial request with the first epoch, got %+v", requests[1])
+	}
+	if requests[1].AgentID != "first-run" || requests[1].Sequence <= requests[0].Sequence {
+		t.Errorf("Expected the sequence of first-run to continue after restart, got %s/%d after %d", requests[1].AgentID, requests[1].Sequence, requests[0].Sequence)
+	}
+}
+
+func TestHandshakeState_RestoreAfterHeartbeats(t *testing.T) {
+	mock := mockapi.New(mockapi.WithSigningSecret([]byte("secret")))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	newClient := func() *Client {
+		return NewClient(
+			WithEndpoint(server.URL),
+			WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+			WithSigningSecret([]byte("secret")),
+			WithAgentID("agent"),
+			WithStateStore(state.NewFileStore(path)),
+			WithLogger(logger.NewDefault()),
+		)
+	}
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{{Name: "node-1"}}}
+
+	first := newClient()
+	if err := first.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Heartbeats sign more sequence numbers without an acknowledged snapshot
+	for i := 0; i < 3; i++ {
+		if err := first.SendHeartbeat(context.Background(), &Heartbeat{Health: HealthHealthy}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	restarted := newClient()
+	if err := restarted.RestoreState(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := restarted.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Expected the restarted agent to resume above the last signed sequence, got %v", err)
+	}
+
+	requests := mock.Requests()
+	last, previous := requests[len(requests)-1], requests[len(requests)-2]
+	if last.Status != 200 || last.Sequence <= previous.Sequence {
+		t.Errorf("Expected sequence above %d to be accepted, got %d (status %d)", previous.Sequence, last.Sequence, last.Status)
+	}
+}
+
+func TestHandshakeState_ResetPersisted(t *testing.T) {
+	mock := mockapi.New()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	store := state.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	newClient := func() *Client {
+		return NewClient(
+			WithEndpoint(server.URL),
+			WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+			WithStateStore(store),
+			WithLogger(logger.NewDefault()),
+		)
+	}
+
+	client := newClient()
+	if err := client.SendDiscoveryResult(context.Background(), &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.ResetHandshake(context.Background())
+
+	// A restart before the next payload keeps the resync requested by the API
+	restarted := newClient()
+	if err := restarted.RestoreState(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restarted.HandshakeCompleted() {
+		t.Error("Expected the reset handshake not to be restored")
+	}
+}
+
+func TestHandshakeState_InvalidatedByAPI(t *testing.T) {
+	mock := mockapi.New()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := state.NewFileStore(path)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithStateStore(store),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first, _ := store.Get(context.Background(), server.URL, "683b2148ff7e3ae67d825cfa")
+
+	// The API forgets the handshake; its epoch is no longer accepted
+	mock.Reset()
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Expected the payload to be resent as initial, got %v", err)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 2 || requests[0].Status != 409 || requests[1].Initial != "true" || requests[1].Status != 200 {
+		t.Fatalf("Expected rejected epoch followed by an accepted initial request, got %+v", requests)
+	}
+	second, _ := store.Get(context.Background(), server.URL, "683b2148ff7e3ae67d825cfa")
+	if second == nil || !second.HandshakeCompleted || second.Epoch == "" || second.Epoch == first.Epoch {
+		t.Errorf("Expected a new persisted epoch, got %+v (was %+v)", second, first)
+	}
+}
+
+func TestHandshakeState_OtherConflict(t *testing.T) {
+	conflict := []mockapi.Fault{{}, {Status: http.StatusConflict, Error: "snapshot already processed"}}
+	mock := mockapi.New(mockapi.WithSchedule(conflict, false))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A conflict that does not concern the handshake is not resent as initial
+	if err := client.SendDiscoveryResult(context.Background(), result); err == nil {
+		t.Fatal("Expected the conflict to be reported")
+	}
+	if !client.HandshakeCompleted() {
+		t.Error("Expected the handshake to be kept")
+	}
+	if requests := mock.Requests(); len(requests) != 2 {
+		t.Errorf("Expected no initial resend, got %+v", requests)
+	}
+}
+
+func TestHandshakeState_RoundRobin(t *testing.T) {
+	var mocks []*mockapi.Server
+	var urls []string
+	for i := 0; i < 2; i++ {
+		mock := mockapi.New(mockapi.WithRequireInitial(true))
+		server := httptest.NewServer(mock)
+		defer server.Close()
+		mocks = append(mocks, mock)
+		urls = append(urls, server.URL)
+	}
+
+	client := NewClient(
+		WithEndpoints(urls...),
+		WithEndpointPolicy(EndpointPolicyRoundRobin),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithLogger(logger.NewDefault()),
+	)
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+
+	for i := 0; i < 4; i++ {
+		if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if !client.HandshakeCompleted() {
+		t.Error("Expected the handshake with the next endpoint to be completed")
+	}
+
+	// Every endpoint keeps its own handshake
+	for i, mock := range mocks {
+		requests := mock.Requests()
+		if len(requests) != 2 || requests[0].Initial != "true" || requests[1].Initial != "false" || requests[1].Status != 200 {
+			t.Errorf("Expected an initial then a non-initial request on endpoint %d, got %+v", i, requests)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Agent health values reported in heartbeats
+const (
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+)
+
+// AgentIdentity identifies a single running agent
+type AgentIdentity struct {
+	// InstanceID is generated at startup and changes on every restart
+	InstanceID string `json:"instance_id"`
+	Version    string `json:"version"`
+	PodName    string `json:"pod_name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+}
+
+// Heartbeat is the lightweight liveness message sent between snapshots
+type Heartbeat struct {
+	Project     string        `json:"project"`
+	Agent       AgentIdentity `json:"agent"`
+	ClusterName string        `json:"cluster_name"`
+	ClusterID   string        `json:"cluster_id,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+	// Fingerprint is the fingerprint of the last snapshot accepted by the API
+	Fingerprint      string     `json:"fingerprint,omitempty"`
+	LastSnapshotTime *time.Time `json:"last_snapshot_time,omitempty"`
+	Health           string     `json:"health"`
+	LastError        string     `json:"last_error,omitempty"`
+	// ActiveEndpoint is the API endpoint that accepted the last request
+	ActiveEndpoint string `json:"active_endpoint,omitempty"`
+}
+
+// heartbeatURL resolves the heartbeat path against an API endpoint. An
+// absolute path replaces the endpoint path, a full URL is used as is.
+func (c *Client) heartbeatURL(endpoint string) (string, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid API endpoint: %w", err)
+	}
+	ref, err := url.Parse(c.heartbeatPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid heartbeat path: %w", err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// SendHeartbeat posts a heartbeat. Directives in the response are queued the
+// same way as for snapshots, so a paused agent can still be reconfigured.
+func (c *Client) SendHeartbeat(ctx context.Context, heartbeat *Heartbeat) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if c.tokenErr != nil {
+		return c.tokenErr
+	}
+	c.checkTokenExpiry()
+	projectID := c.tokenInfo.Project
+	heartbeat.Project = projectID
+
+	jsonData, err := json.Marshal(heartbeat)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+
+	var lastErr error
+	for _, endpoint := range c.pool.candidates() {
+		target, err := c.heartbeatURL(endpoint)
+		if err != nil {
+			return err
+		}
+
+		resp, apiResponse, err := c.post(ctx, endpoint, target, requestPart{body: jsonData}, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to send heartbeat: %w", err)
+			if ctx.Err() != nil {
+				return err
+			}
+			c.endpointFailed(endpoint, err)
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			apiErr := newAPIError(resp, apiResponse)
+			if shouldFailover(resp.StatusCode) {
+				c.endpointFailed(endpoint, apiErr)
+				lastErr = apiErr
+				continue
+			}
+			return apiErr
+		}
+		c.endpointSucceeded(endpoint)
+
+		if apiResponse != nil {
+			if directives := parseDirectives(apiResponse.Result); directives != nil {
+				c.directives.Store(directives)
+			}
+		}
+
+		c.logger.WithFields(map[string]interface{}{
+			"endpoint": target,
+			"project":  projectID,
+			"health":   heartbeat.Health,
+		}).Debug("Heartbeat sent")
+
+		return nil
+	}
+
+	return lastErr
+}
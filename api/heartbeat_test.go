@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+)
+
+func TestHeartbeatURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		path     string
+		expected string
+	}{
+		{"absolute path", "https://api.example.com/api/v1/discovery", "/heartbeat", "https://api.example.com/heartbeat"},
+		{"relative path", "https://api.example.com/api/v1/discovery", "heartbeat", "https://api.example.com/api/v1/heartbeat"},
+		{"full URL", "https://api.example.com/discovery", "https://hb.example.com/beat", "https://hb.example.com/beat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(
+				WithEndpoint(tt.endpoint),
+				WithHeartbeatPath(tt.path),
+				WithLogger(logger.NewDefault()),
+			)
+
+			result, err := client.heartbeatURL(tt.endpoint)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSendHeartbeat(t *testing.T) {
+	var received Heartbeat
+	var authHeader, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		authHeader = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result":  map[string]interface{}{"directives": map[string]interface{}{"id": "d-1", "resync": true}},
+		})
+	}))
+	defer server.Close()
+
+	token := "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"
+	client := NewClient(
+		WithEndpoint(server.URL+"/discovery"),
+		WithToken(token),
+		WithHeartbeatPath("/heartbeat"),
+		WithLogger(logger.NewDefault()),
+	)
+
+	err := client.SendHeartbeat(context.Background(), &Heartbeat{
+		ClusterName: "test-cluster",
+		Fingerprint: "abc",
+		Health:      HealthHealthy,
+		Agent:       AgentIdentity{InstanceID: "instance-1", Version: "v1.0.0"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if path != "/heartbeat" {
+		t.Errorf("Expected path /heartbeat, got %s", path)
+	}
+	if authHeader != "Bearer "+token {
+		t.Errorf("Expected Authorization header, got %q", authHeader)
+	}
+	if received.Project != "683b2148ff7e3ae67d825cfa" {
+		t.Errorf("Expected project 683b2148ff7e3ae67d825cfa, got %s", received.Project)
+	}
+	if received.Fingerprint != "abc" || received.Agent.InstanceID != "instance-1" {
+		t.Errorf("Unexpected heartbeat: %+v", received)
+	}
+
+	directives := client.TakeDirectives()
+	if directives == nil || directives.ID != "d-1" || !directives.Resync {
+		t.Errorf("Expected directives from heartbeat response, got %+v", directives)
+	}
+}
+
+func TestSendHeartbeat_Unauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		WithHeartbeatPath("/heartbeat"),
+		WithLogger(logger.NewDefault()),
+	)
+
+	err := client.SendHeartbeat(context.Background(), &Heartbeat{Health: HealthHealthy})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/state"
+)
+
+// DefaultTimeout bounds a single request to the API
+const DefaultTimeout = 15 * time.Second
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithEndpoint sets the discovery endpoint URL; without it nothing is sent
+func WithEndpoint(endpoint string) Option {
+	return WithEndpoints(endpoint)
+}
+
+// WithEndpoints sets several discovery endpoint URLs, the first one being
+// the primary. Empty URLs are ignored.
+func WithEndpoints(endpoints ...string) Option {
+	return func(c *Client) {
+		c.endpoints = endpoints
+	}
+}
+
+// WithEndpointPolicy sets how endpoints are picked, EndpointPolicyFailover
+// when unset
+func WithEndpointPolicy(policy string) Option {
+	return func(c *Client) {
+		c.endpointPolicy = policy
+	}
+}
+
+// WithEndpointBackoff sets how long a failing endpoint is skipped; the
+// backoff doubles with every consecutive failure up to max
+func WithEndpointBackoff(initial, max time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = initial
+		c.maxBackoff = max
+	}
+}
+
+// WithToken sets the bearer token, either in the "uuid--project" format or a
+// JWT carrying a project claim
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithTokenExpiryWarning sets how long before a JWT token expires the client
+// starts warning, DefaultTokenExpiryWarning when unset. Zero disables the
+// warning.
+func WithTokenExpiryWarning(warning time.Duration) Option {
+	return func(c *Client) {
+		c.tokenExpiryWarning = warning
+	}
+}
+
+// WithHeartbeatPath sets the path heartbeats are posted to, resolved
+// against the endpoint
+func WithHeartbeatPath(path string) Option {
+	return func(c *Client) {
+		c.heartbeatPath = path
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification
+func WithInsecureSkipVerify(insecure bool) Option {
+	return func(c *Client) {
+		c.insecureSkipVerify = insecure
+	}
+}
+
+// WithTimeout bounds a whole request including reading the response body,
+// DefaultTimeout when unset
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithHTTPClient replaces the HTTP client, e.g. in tests. TLS, proxy,
+// transport and timeout options are ignored when it is set.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sets the logger, logger.NewDefault() when unset
+func WithLogger(log *logger.Logger) Option {
+	return func(c *Client) {
+		c.logger = log
+	}
+}
+
+// WithCompression compresses request bodies of at least threshold bytes with
+// the given algorithm
+func WithCompression(algorithm string, threshold int) Option {
+	return func(c *Client) {
+		c.compression = algorithm
+		c.compressionThreshold = threshold
+	}
+}
+
+// WithMaxPayloadSize splits request bodies larger than size bytes, after
+// compression, into ordered parts. Zero disables splitting.
+func WithMaxPayloadSize(size int) Option {
+	return func(c *Client) {
+		c.maxPayloadSize = size
+	}
+}
+
+// WithSigningSecret signs every request with an HMAC using secret, see
+// package signing for the headers
+func WithSigningSecret(secret []byte) Option {
+	return func(c *Client) {
+		c.signingSecret = secret
+	}
+}
+
+// WithAgentID identifies this agent in signed requests; the server tracks
+// sequence numbers per agent ID
+func WithAgentID(agentID string) Option {
+	return func(c *Client) {
+		c.agentID = agentID
+	}
+}
+
+// WithProxy sets the outbound proxy; without it the proxy environment
+// variables apply
+func WithProxy(proxy ProxyConfig) Option {
+	return func(c *Client) {
+		c.proxy = proxy
+	}
+}
+
+// WithTransport tunes timeouts and connection pooling
+func WithTransport(transport TransportConfig) Option {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+// WithStateStore persists the handshake state, see Client.RestoreState.
+// Clients for different projects may share a store.
+func WithStateStore(store *state.Store) Option {
+	return func(c *Client) {
+		c.state = store
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import "time"
+
+// DefaultTokenExpiryWarning is how long before the token expires the client
+// starts warning
+const DefaultTokenExpiryWarning = 24 * time.Hour
+
+// tokenWarningInterval limits how often the expiry warning is repeated
+const tokenWarningInterval = time.Hour
+
+// TokenExpiry returns when the token expires, zero for tokens without an
+// expiry such as the legacy "uuid--project" format
+func (c *Client) TokenExpiry() time.Time {
+	return c.tokenInfo.ExpiresAt
+}
+
+// TokenError returns why the token cannot be used, nil for a valid token
+func (c *Client) TokenError() error {
+	return c.tokenErr
+}
+
+// checkTokenExpiry logs a warning once the token is about to expire and an
+// error once it has expired, each at most once per tokenWarningInterval
+func (c *Client) checkTokenExpiry() {
+	expiresAt := c.tokenInfo.ExpiresAt
+	if expiresAt.IsZero() || c.tokenExpiryWarning <= 0 {
+		return
+	}
+
+	now := time.Now()
+	remaining := expiresAt.Sub(now)
+	if remaining > c.tokenExpiryWarning {
+		return
+	}
+
+	last := c.tokenWarnedAt.Load()
+	if last != 0 && now.Sub(time.Unix(0, last)) < tokenWarningInterval {
+		return
+	}
+	if !c.tokenWarnedAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"project":    c.tokenInfo.Project,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	}
+	if remaining <= 0 {
+		c.logger.WithFields(fields).Error("API token has expired, replace it to keep reporting")
+		return
+	}
+	fields["expires_in"] = remaining.Round(time.Second).String()
+	c.logger.WithFields(fields).Warn("API token expires soon")
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+)
+
+func testJWT(project string, expiresAt time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"project":%q,"exp":%d}`, project, expiresAt.Unix())))
+	return header + "." + claims + ".c2lnbmF0dXJl"
+}
+
+func TestSendDiscoveryResult_JWT(t *testing.T) {
+	mock := mockapi.New()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log := logger.NewDefault()
+	log.Logger.SetOutput(&logs)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	client := NewClient(
+		WithEndpoint(server.URL),
+		WithToken(testJWT("team-a", expiresAt)),
+		WithLogger(log),
+	)
+	if client.TokenError() != nil || client.Project() != "team-a" || !client.TokenExpiry().Equal(expiresAt) {
+		t.Fatalf("Expected JWT for team-a expiring at %v, got %q %v (%v)", expiresAt, client.Project(), client.TokenExpiry(), client.TokenError())
+	}
+
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{}}
+	for i := 0; i < 2; i++ {
+		if err := client.SendDiscoveryResult(context.Background(), result); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if requests := mock.Requests(); len(requests) != 2 || requests[0].Project != "team-a" {
+		t.Errorf("Expected two requests for team-a, got %+v", requests)
+	}
+	if count := strings.Count(logs.String(), "API token expires soon"); count != 1 {
+		t.Errorf("Expected one expiry warning, got %d", count)
+	}
+}
+
+func TestCheckTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		warning   time.Duration
+		expected  string
+	}{
+		{"far from expiry", time.Now().Add(48 * time.Hour), DefaultTokenExpiryWarning, ""},
+		{"within warning", time.Now().Add(time.Hour), DefaultTokenExpiryWarning, "API token expires soon"},
+		{"expired", time.Now().Add(-time.Minute), DefaultTokenExpiryWarning, "API token has expired"},
+		{"warning disabled", time.Now().Add(time.Hour), 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log := logger.NewDefault()
+			log.Logger.SetOutput(&logs)
+
+			client := NewClient(
+				WithToken(testJWT("team-a", tt.expiresAt)),
+				WithTokenExpiryWarning(tt.warning),
+				WithLogger(log),
+			)
+			client.checkTokenExpiry()
+
+			if tt.expected == "" && logs.Len() > 0 {
+				t.Errorf("Expected no log, got %q", logs.String())
+			}
+			if tt.expected != "" && !strings.Contains(logs.String(), tt.expected) {
+				t.Errorf("Expected %q in log, got %q", tt.expected, logs.String())
+			}
+		})
+	}
+}
+
+func TestGetDiscoveryPayload_InvalidToken(t *testing.T) {
+	client := NewClient(WithToken(testJWT("", time.Now().Add(time.Hour))), WithLogger(logger.NewDefault()))
+
+	if _, err := client.GetDiscoveryPayload(&discovery.DiscoveryResult{}); err == nil || !strings.Contains(err.Error(), "project claim") {
+		t.Errorf("Expected missing project claim error, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig selects the outbound proxy. Empty fields fall back to the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+type ProxyConfig struct {
+	// URL of the proxy, used for both http and https endpoints
+	URL string
+	// NoProxy lists hosts, domains and CIDRs reached directly
+	NoProxy string
+	// Username and Password authenticate to the proxy, whether it comes
+	// from URL or from the environment, and override credentials in the URL
+	Username string
+	Password string
+}
+
+// Validate checks the proxy URL
+func (p ProxyConfig) Validate() error {
+	_, err := p.proxyURL()
+	return err
+}
+
+// proxyURL returns the configured proxy URL, or "" when the environment decides
+func (p ProxyConfig) proxyURL() (string, error) {
+	if p.URL == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(p.URL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid proxy URL %q: expected scheme://host:port", p.URL)
+	}
+	return u.String(), nil
+}
+
+// proxyFunc resolves the proxy for each request. The environment is read
+// when the client is created rather than once per process, unlike
+// http.ProxyFromEnvironment.
+func (p ProxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+
+	proxyURL, err := p.proxyURL()
+	if err != nil {
+		return func(*http.Request) (*url.URL, error) {
+			return nil, err
+		}
+	}
+	if proxyURL != "" {
+		cfg.HTTPProxy = proxyURL
+		cfg.HTTPSProxy = proxyURL
+	}
+	if p.NoProxy != "" {
+		cfg.NoProxy = p.NoProxy
+	}
+
+	resolve := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := resolve(req.URL)
+		if err != nil || u == nil || p.Username == "" {
+			return u, err
+		}
+		withUser := *u
+		withUser.User = url.UserPassword(p.Username, p.Password)
+		return &withUser, nil
+	}
+}
+
+// DefaultTransportConfig matches http.DefaultTransport
+var DefaultTransportConfig = TransportConfig{
+	DialTimeout:         30 * time.Second,
+	KeepAlive:           30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	IdleConnTimeout:     90 * time.Second,
+	MaxIdleConns:        100,
+}
+
+// TransportConfig tunes connections to the API. Zero values mean no limit,
+// except MaxIdleConnsPerHost which falls back to the net/http default.
+type TransportConfig struct {
+	// DialTimeout bounds establishing the TCP connection
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keepalive period
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the response headers after
+	// the request was written
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout closes pooled connections idle for longer
+	IdleConnTimeout     time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits connections per host, zero means no limit
+	MaxConnsPerHost int
+}
+
+// newTransport builds the transport from the client options
+func (c *Client) newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   c.transport.DialTimeout,
+		KeepAlive: c.transport.KeepAlive,
+	}
+
+	return &http.Transport{
+		Proxy:                 c.proxy.proxyFunc(),
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   c.transport.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.transport.ResponseHeaderTimeout,
+		IdleConnTimeout:       c.transport.IdleConnTimeout,
+		MaxIdleConns:          c.transport.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.transport.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.transport.MaxConnsPerHost,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: c.insecureSkipVerify,
+		},
+	}
+}
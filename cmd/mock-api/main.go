@@ -0,0 +1,79 @@
+// Command mock-api runs a local stand-in for the Elchi discovery API.
+//
+//	mock-api -listen :8080 -schedule "ok,500,429+retry=5s" -loop
+//
+// Point the agent at http://localhost:8080/ and inspect what it sent with
+// curl http://localhost:8080/_mock/requests.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	token := flag.String("token", "", "only accept this bearer token; any uuid--project token when empty")
+	schedule := flag.String("schedule", "", "faults applied to requests in order, e.g. \"ok,500,429+retry=5s,ok+delay=2s\"")
+	loop := flag.Bool("loop", false, "repeat the schedule when exhausted")
+	requireInitial := flag.Bool("require-initial", false, "reject initial:false requests before an initial request")
+	signingSecret := flag.String("signing-secret", os.Getenv("ELCHI_SIGNING_SECRET"), "require requests signed with this secret")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Parse()
+
+	log := logger.New(&logger.Config{Level: *logLevel, Format: "text", Output: "stdout"})
+
+	faults, err := mockapi.ParseSchedule(*schedule)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid schedule")
+		return
+	}
+
+	mock := mockapi.New(
+		mockapi.WithToken(*token),
+		mockapi.WithRequireInitial(*requireInitial),
+		mockapi.WithSchedule(faults, *loop),
+		mockapi.WithSigningSecret([]byte(*signingSecret)),
+	)
+
+	server := &http.Server{
+		Addr: *listen,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.WithFields(map[string]interface{}{
+				"method":  r.Method,
+				"path":    r.URL.Path,
+				"initial": r.Header.Get("initial"),
+			}).Info("Request received")
+			mock.ServeHTTP(w, r)
+		}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.WithFields(map[string]interface{}{
+		"listen":   *listen,
+		"schedule": len(faults),
+		"loop":     *loop,
+	}).Info("Mock Elchi API listening")
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithError(err).Fatal("Mock Elchi API failed")
+	}
+}
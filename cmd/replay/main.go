@@ -0,0 +1,87 @@
+// Command replay re-sends payloads recorded by elchi-discovery to an API
+// endpoint.
+//
+//	replay -file elchi-discovery-record.jsonl -endpoint https://elchi.example.com/api/discovery -token <token> -speed 10
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/recording"
+)
+
+func main() {
+	file := flag.String("file", "elchi-discovery-record.jsonl", "recording to replay")
+	endpoint := flag.String("endpoint", os.Getenv("ELCHI_API_ENDPOINT"), "API endpoint to send payloads to")
+	token := flag.String("token", os.Getenv("ELCHI_TOKEN"), "API token")
+	insecure := flag.Bool("insecure-skip-verify", false, "skip TLS certificate verification")
+	speed := flag.Float64("speed", 1, "pace relative to the recording, e.g. 10 for ten times faster; 0 sends back to back")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Parse()
+
+	log := logger.New(&logger.Config{Level: *logLevel, Format: "text", Output: "stdout"})
+
+	if *endpoint == "" {
+		log.Fatal("API endpoint is required. Please set -endpoint or ELCHI_API_ENDPOINT environment variable")
+		return
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to open recording")
+		return
+	}
+	entries, err := recording.Read(f)
+	f.Close()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to read recording")
+		return
+	}
+
+	client := api.NewClient(
+		api.WithEndpoint(*endpoint),
+		api.WithToken(*token),
+		api.WithInsecureSkipVerify(*insecure),
+		api.WithLogger(log),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.WithFields(map[string]interface{}{
+		"file":     *file,
+		"entries":  len(entries),
+		"endpoint": *endpoint,
+		"speed":    *speed,
+	}).Info("Replaying recording")
+
+	result, err := recording.Replay(ctx, entries, client, *speed, func(entry recording.Entry, err error) {
+		fields := map[string]interface{}{
+			"recorded_at":     entry.Time,
+			"recorded_status": entry.Status,
+			"initial":         entry.Initial,
+		}
+		if err != nil {
+			log.WithFields(fields).WithError(err).Warn("Replayed payload failed")
+			return
+		}
+		log.WithFields(fields).Debug("Replayed payload")
+	})
+
+	fields := map[string]interface{}{
+		"sent":    result.Sent,
+		"failed":  result.Failed,
+		"skipped": result.Skipped,
+	}
+	if err != nil {
+		log.WithFields(fields).WithError(err).Warn("Replay interrupted")
+		return
+	}
+	log.WithFields(fields).Info("Replay completed")
+}
@@ -0,0 +1,115 @@
+package discovery
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// IP families reported in NodeAddress.Family
+const (
+	FamilyIPv4 = "IPv4"
+	FamilyIPv6 = "IPv6"
+)
+
+// DefaultAddressPreference picks internal addresses before external ones and
+// IPv4 before IPv6 within the same address type
+var DefaultAddressPreference = []string{
+	"InternalIP/IPv4",
+	"InternalIP/IPv6",
+	"ExternalIP/IPv4",
+	"ExternalIP/IPv6",
+}
+
+// AddressPreference selects node addresses by type and, optionally, IP family
+type AddressPreference struct {
+	Type   string
+	Family string
+}
+
+// ParseAddressPreference parses entries of the form "Type" or "Type/Family",
+// for example "InternalIP/IPv4" or "ExternalIP".
+func ParseAddressPreference(entries []string) ([]AddressPreference, error) {
+	prefs := make([]AddressPreference, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		addrType, family, _ := strings.Cut(entry, "/")
+		switch v1.NodeAddressType(addrType) {
+		case v1.NodeInternalIP, v1.NodeExternalIP, v1.NodeHostName,
+			v1.NodeInternalDNS, v1.NodeExternalDNS:
+		default:
+			return nil, fmt.Errorf("invalid address preference %q: unknown address type %q", entry, addrType)
+		}
+
+		switch strings.ToLower(family) {
+		case "":
+		case "ipv4":
+			family = FamilyIPv4
+		case "ipv6":
+			family = FamilyIPv6
+		default:
+			return nil, fmt.Errorf("invalid address preference %q: unknown IP family %q", entry, family)
+		}
+
+		prefs = append(prefs, AddressPreference{Type: addrType, Family: family})
+	}
+	return prefs, nil
+}
+
+// getNodeAddresses keeps every node address in the order reported by the
+// kubelet, tagging IP addresses with their family
+func getNodeAddresses(node *v1.Node) []NodeAddress {
+	addresses := make([]NodeAddress, 0, len(node.Status.Addresses))
+	for _, address := range node.Status.Addresses {
+		addresses = append(addresses, NodeAddress{
+			Type:    string(address.Type),
+			Address: address.Address,
+			Family:  addressFamily(address.Address),
+		})
+	}
+	return addresses
+}
+
+// legacyAddressMap builds the old type-keyed map exactly as earlier versions
+// did: the last address of each type wins, which is the secondary IP family
+// on dual-stack nodes.
+func legacyAddressMap(addresses []NodeAddress) map[string]string {
+	legacy := make(map[string]string, len(addresses))
+	for _, address := range addresses {
+		legacy[address.Type] = address.Address
+	}
+	return legacy
+}
+
+// primaryAddress returns the first address matching the preference order
+func primaryAddress(addresses []NodeAddress, prefs []AddressPreference) string {
+	for _, pref := range prefs {
+		for _, address := range addresses {
+			if address.Type != pref.Type {
+				continue
+			}
+			if pref.Family != "" && address.Family != pref.Family {
+				continue
+			}
+			return address.Address
+		}
+	}
+	return ""
+}
+
+func addressFamily(address string) string {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return ""
+	}
+	if ip.Is4() || ip.Is4In6() {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
@@ -0,0 +1,181 @@
+package discovery
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func dualStackNode() *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "dual-stack",
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "192.168.1.10"},
+				{Type: v1.NodeInternalIP, Address: "fd00::10"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.10"},
+				{Type: v1.NodeHostName, Address: "dual-stack"},
+			},
+		},
+	}
+}
+
+func TestParseAddressPreference(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []string
+		expected  []AddressPreference
+		expectErr bool
+	}{
+		{
+			name:    "type and family",
+			entries: []string{"InternalIP/IPv6", "ExternalIP/ipv4"},
+			expected: []AddressPreference{
+				{Type: "InternalIP", Family: FamilyIPv6},
+				{Type: "ExternalIP", Family: FamilyIPv4},
+			},
+		},
+		{
+			name:     "type only",
+			entries:  []string{" Hostname ", ""},
+			expected: []AddressPreference{{Type: "Hostname"}},
+		},
+		{
+			name:      "unknown type",
+			entries:   []string{"PublicIP"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown family",
+			entries:   []string{"InternalIP/IPv5"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs, err := ParseAddressPreference(tt.entries)
+			if tt.expectErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAddressPreference() error = %v", err)
+			}
+			if len(prefs) != len(tt.expected) {
+				t.Fatalf("Expected %d preferences, got %d", len(tt.expected), len(prefs))
+			}
+			for i := range prefs {
+				if prefs[i] != tt.expected[i] {
+					t.Errorf("Expected preference %d to be %+v, got %+v", i, tt.expected[i], prefs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetNodeAddressesDualStack(t *testing.T) {
+	addresses := getNodeAddresses(dualStackNode())
+
+	expected := []NodeAddress{
+		{Type: "InternalIP", Address: "192.168.1.10", Family: FamilyIPv4},
+		{Type: "InternalIP", Address: "fd00::10", Family: FamilyIPv6},
+		{Type: "ExternalIP", Address: "203.0.113.10", Family: FamilyIPv4},
+		{Type: "Hostname", Address: "dual-stack"},
+	}
+	if len(addresses) != len(expected) {
+		t.Fatalf("Expected %d addresses, got %d", len(expected), len(addresses))
+	}
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Errorf("Expected address %d to be %+v, got %+v", i, expected[i], addresses[i])
+		}
+	}
+
+	legacy := legacyAddressMap(addresses)
+	if legacy["InternalIP"] != "fd00::10" {
+		t.Errorf("Expected legacy InternalIP to keep the last address, got %s", legacy["InternalIP"])
+	}
+}
+
+func TestPrimaryAddress(t *testing.T) {
+	addresses := getNodeAddresses(dualStackNode())
+
+	tests := []struct {
+		name     string
+		entries  []string
+		expected string
+	}{
+		{
+			name:     "default preference",
+			entries:  DefaultAddressPreference,
+			expected: "192.168.1.10",
+		},
+		{
+			name:     "prefer IPv6",
+			entries:  []string{"InternalIP/IPv6", "InternalIP/IPv4"},
+			expected: "fd00::10",
+		},
+		{
+			name:     "prefer external",
+			entries:  []string{"ExternalIP", "InternalIP"},
+			expected: "203.0.113.10",
+		},
+		{
+			name:     "no match",
+			entries:  []string{"ExternalDNS"},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs, err := ParseAddressPreference(tt.entries)
+			if err != nil {
+				t.Fatalf("ParseAddressPreference() error = %v", err)
+			}
+			if result := primaryAddress(addresses, prefs); result != tt.expected {
+				t.Errorf("primaryAddress() = %s, expected %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiscoverNodesLegacyAddresses(t *testing.T) {
+	client := fake.NewSimpleClientset(dualStackNode())
+
+	// Compatibility mode reproduces the baseline map, last address per type
+	compat, err := NewService(client, "test-cluster").DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverNodes() error = %v", err)
+	}
+	expected := map[string]string{"InternalIP": "fd00::10", "ExternalIP": "203.0.113.10", "Hostname": "dual-stack"}
+	if !maps.Equal(compat.Nodes[0].Addresses, expected) {
+		t.Errorf("Expected baseline legacy address map %v, got %v", expected, compat.Nodes[0].Addresses)
+	}
+
+	service := NewService(client, "test-cluster", WithLegacyAddresses(false))
+	result, err := service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverNodes() error = %v", err)
+	}
+
+	node := result.Nodes[0]
+	if node.Addresses != nil {
+		t.Errorf("Expected no legacy address map, got %v", node.Addresses)
+	}
+	if len(node.AddressList) != 4 {
+		t.Errorf("Expected 4 addresses, got %d", len(node.AddressList))
+	}
+	if node.PrimaryAddress != "192.168.1.10" {
+		t.Errorf("Expected primary address 192.168.1.10, got %s", node.PrimaryAddress)
+	}
+}
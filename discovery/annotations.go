@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Node annotations read by discovery, so cluster admins can control what
+// Elchi sees per node without changing the agent configuration
+const (
+	// AnnotationExclude set to "true" leaves the node out of every payload
+	AnnotationExclude = "elchi.io/exclude"
+
+	// AnnotationWeight overrides the node's load balancing weight
+	AnnotationWeight = "elchi.io/weight"
+
+	// AnnotationAddress replaces the primary address picked from the node status
+	AnnotationAddress = "elchi.io/address"
+
+	// AnnotationMetadataPrefix prefixes free-form metadata passed to Elchi,
+	// metadata.elchi.io/<key> is reported as Metadata[<key>]
+	AnnotationMetadataPrefix = "metadata.elchi.io/"
+)
+
+// MaxWeight is the largest weight accepted in AnnotationWeight
+const MaxWeight = 1000
+
+// isExcluded reports whether the node opted out of discovery. Unparsable
+// values do not exclude the node; they are reported by applyAnnotations.
+func isExcluded(node *v1.Node) bool {
+	excluded, err := strconv.ParseBool(node.Annotations[AnnotationExclude])
+	return err == nil && excluded
+}
+
+// applyAnnotations carries the weight, address and metadata annotations into
+// nodeInfo. Invalid values are ignored and listed in AnnotationErrors.
+func applyAnnotations(node *v1.Node, nodeInfo *NodeInfo) {
+	for key, value := range node.Annotations {
+		switch {
+		case key == AnnotationExclude:
+			if _, err := strconv.ParseBool(value); err != nil {
+				nodeInfo.AnnotationErrors = append(nodeInfo.AnnotationErrors,
+					fmt.Sprintf("%s: expected true or false, got %q", key, value))
+			}
+
+		case key == AnnotationWeight:
+			weight, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || weight < 0 || weight > MaxWeight {
+				nodeInfo.AnnotationErrors = append(nodeInfo.AnnotationErrors,
+					fmt.Sprintf("%s: expected an integer between 0 and %d, got %q", key, MaxWeight, value))
+				continue
+			}
+			nodeInfo.Weight = &weight
+
+		case key == AnnotationAddress:
+			address := strings.TrimSpace(value)
+			if address == "" || strings.ContainsAny(address, " /") {
+				nodeInfo.AnnotationErrors = append(nodeInfo.AnnotationErrors,
+					fmt.Sprintf("%s: expected an IP address or host name, got %q", key, value))
+				continue
+			}
+			nodeInfo.PrimaryAddress = address
+			nodeInfo.AddressOverridden = true
+
+		case strings.HasPrefix(key, AnnotationMetadataPrefix):
+			name := strings.TrimPrefix(key, AnnotationMetadataPrefix)
+			if nodeInfo.Metadata == nil {
+				nodeInfo.Metadata = make(map[string]string)
+			}
+			nodeInfo.Metadata[name] = value
+		}
+	}
+
+	// Annotations are a map, keep the output independent of iteration order
+	slices.Sort(nodeInfo.AnnotationErrors)
+}
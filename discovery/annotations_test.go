@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestApplyAnnotations(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		weight       int
+		address      string
+		overridden   bool
+		metadata     map[string]string
+		errorCount   int
+		expectWeight bool
+	}{
+		{
+			name:    "no annotations",
+			address: "192.168.1.10",
+		},
+		{
+			name:         "weight",
+			annotations:  map[string]string{AnnotationWeight: " 25 "},
+			weight:       25,
+			expectWeight: true,
+			address:      "192.168.1.10",
+		},
+		{
+			name:        "address override",
+			annotations: map[string]string{AnnotationAddress: "10.0.0.5"},
+			address:     "10.0.0.5",
+			overridden:  true,
+		},
+		{
+			name: "metadata",
+			annotations: map[string]string{
+				AnnotationMetadataPrefix + "zone-group": "blue",
+				AnnotationMetadataPrefix + "rack":       "r12",
+				"example.com/other":                     "ignored",
+			},
+			address:  "192.168.1.10",
+			metadata: map[string]string{"zone-group": "blue", "rack": "r12"},
+		},
+		{
+			name: "invalid values",
+			annotations: map[string]string{
+				AnnotationExclude: "maybe",
+				AnnotationWeight:  "1001",
+				AnnotationAddress: "10.0.0.0/8",
+			},
+			address:    "192.168.1.10",
+			errorCount: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := dualStackNode()
+			node.Annotations = tt.annotations
+			nodeInfo := NewService(fake.NewSimpleClientset(), "test-cluster").buildNodeInfo(node)
+
+			if tt.expectWeight != (nodeInfo.Weight != nil) || (nodeInfo.Weight != nil && *nodeInfo.Weight != tt.weight) {
+				t.Errorf("Expected weight %d (set %v), got %v", tt.weight, tt.expectWeight, nodeInfo.Weight)
+			}
+			if nodeInfo.PrimaryAddress != tt.address || nodeInfo.AddressOverridden != tt.overridden {
+				t.Errorf("Expected address %s (overridden %v), got %s (%v)", tt.address, tt.overridden, nodeInfo.PrimaryAddress, nodeInfo.AddressOverridden)
+			}
+			if len(nodeInfo.Metadata) != len(tt.metadata) {
+				t.Errorf("Expected metadata %v, got %v", tt.metadata, nodeInfo.Metadata)
+			}
+			for key, value := range tt.metadata {
+				if nodeInfo.Metadata[key] != value {
+					t.Errorf("Expected metadata %s=%s, got %q", key, value, nodeInfo.Metadata[key])
+				}
+			}
+			if len(nodeInfo.AnnotationErrors) != tt.errorCount {
+				t.Errorf("Expected %d annotation errors, got %v", tt.errorCount, nodeInfo.AnnotationErrors)
+			}
+		})
+	}
+}
+
+func TestDiscoverNodes_ExcludeAnnotation(t *testing.T) {
+	node := func(name, exclude string) *v1.Node {
+		n := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if exclude != "" {
+			n.Annotations = map[string]string{AnnotationExclude: exclude}
+		}
+		return n
+	}
+	client := fake.NewSimpleClientset(
+		node("node-1", ""),
+		node("node-2", "true"),
+		node("node-3", "false"),
+		node("node-4", "invalid"),
+	)
+
+	result, err := NewService(client, "test-cluster").DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.NodeCount != 3 || len(result.Nodes) != 3 {
+		t.Fatalf("Expected 3 nodes, got %d", result.NodeCount)
+	}
+	for _, n := range result.Nodes {
+		if n.Name == "node-2" {
+			t.Error("Expected excluded node to be dropped")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DistributionKubernetes is reported when no specific distribution is recognised
+const DistributionKubernetes = "kubernetes"
+
+// versionDistributions maps markers found in the server GitVersion to a distribution
+var versionDistributions = []struct {
+	marker       string
+	distribution string
+}{
+	{"-eks-", "eks"},
+	{"-gke.", "gke"},
+	{"+k3s", "k3s"},
+	{"+rke2", "rke2"},
+	{"+vmware", "tkg"},
+	{"-mirantis", "mke"},
+}
+
+// providerPlatforms maps node providerID schemes to an infrastructure platform
+var providerPlatforms = map[string]string{
+	"aws":          "aws",
+	"gce":          "gcp",
+	"azure":        "azure",
+	"digitalocean": "digitalocean",
+	"openstack":    "openstack",
+	"vsphere":      "vsphere",
+	"ibm":          "ibm",
+	"oci":          "oracle",
+	"hcloud":       "hetzner",
+	"linode":       "linode",
+	"equinixmetal": "equinix",
+	"kind":         "kind",
+	"k3s":          "k3s",
+}
+
+// providerDistributions are providerID schemes that also identify the distribution
+var providerDistributions = map[string]string{
+	"kind": "kind",
+	"k3s":  "k3s",
+}
+
+// DefaultClusterInfoRefresh is how long the cluster ID, API server endpoints
+// and CIDRs are reused before they are read again
+const DefaultClusterInfoRefresh = 10 * time.Minute
+
+// clusterDetails are the parts of ClusterInfo that almost never change
+type clusterDetails struct {
+	id                 string
+	apiServerEndpoints []string
+	podCIDRs           []string
+	serviceCIDRs       []string
+	fetchedAt          time.Time
+}
+
+// detailsCache keeps the cluster details between discovery cycles, saving a
+// handful of API calls on every cycle
+type detailsCache struct {
+	refresh time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	details *clusterDetails
+}
+
+// WithClusterInfoRefresh sets how long the cluster details are cached. Zero
+// reads them on every discovery cycle.
+func WithClusterInfoRefresh(refresh time.Duration) Option {
+	return func(s *Service) {
+		if refresh >= 0 {
+			s.details.refresh = refresh
+		}
+	}
+}
+
+func (s *Service) getClusterInfo(ctx context.Context) ClusterInfo {
+	info := ClusterInfo{
+		Name:    s.clusterName, // Cluster name is required from config
+		Version: "unknown",
+	}
+
+	// Get cluster version from server version, a single cheap call that
+	// notices upgrades on the next cycle
+	if version, err := s.client.Discovery().ServerVersion(); err == nil && version != nil {
+		info.Version = version.GitVersion
+	}
+	info.Distribution = distributionFromVersion(info.Version)
+
+	details := s.clusterDetails(ctx)
+	info.ID = details.id
+	info.APIServerEndpoints = slices.Clone(details.apiServerEndpoints)
+	info.PodCIDRs = slices.Clone(details.podCIDRs)
+	info.ServiceCIDRs = slices.Clone(details.serviceCIDRs)
+
+	return info
+}
+
+// clusterDetails returns the cached cluster details, reading them again once
+// the refresh period has passed. Details are only cached once the kube-system
+// namespace could be read, so a failed read is retried on the next cycle.
+// They are read whatever the payload sections; excluded details are stripped
+// from the result.
+func (s *Service) clusterDetails(ctx context.Context) clusterDetails {
+	cache := &s.details
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := cache.now()
+	if cache.details != nil && now.Sub(cache.details.fetchedAt) < cache.refresh {
+		return *cache.details
+	}
+
+	details := clusterDetails{fetchedAt: now}
+	// The kube-system namespace UID is stable for the lifetime of the cluster
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err == nil {
+		details.id = string(ns.UID)
+	}
+	details.apiServerEndpoints = s.getAPIServerEndpoints(ctx)
+	details.podCIDRs, details.serviceCIDRs = s.getClusterCIDRs(ctx)
+
+	if err == nil {
+		cache.details = &details
+	}
+	return details
+}
+
+// applyProviderID fills the platform, and the distribution when the version
+// string did not identify one, from a node providerID such as "aws:///zone/id"
+func (info *ClusterInfo) applyProviderID(providerID string) {
+	if info.Platform != "" || providerID == "" {
+		return
+	}
+
+	scheme, _, ok := strings.Cut(providerID, "://")
+	if !ok {
+		return
+	}
+
+	info.Platform = providerPlatforms[scheme]
+	if distribution, ok := providerDistributions[scheme]; ok && info.Distribution == DistributionKubernetes {
+		info.Distribution = distribution
+	}
+}
+
+func distributionFromVersion(version string) string {
+	for _, vd := range versionDistributions {
+		if strings.Contains(version, vd.marker) {
+			return vd.distribution
+		}
+	}
+	return DistributionKubernetes
+}
+
+// getAPIServerEndpoints reads the addresses behind the default/kubernetes service
+func (s *Service) getAPIServerEndpoints(ctx context.Context) []string {
+	endpoints, err := s.client.CoreV1().Endpoints(metav1.NamespaceDefault).Get(ctx, "kubernetes", metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+
+	var result []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			for _, port := range subset.Ports {
+				result = append(result, net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// kubeadmClusterConfiguration is the subset of kubeadm's ClusterConfiguration we read
+type kubeadmClusterConfiguration struct {
+	Networking struct {
+		PodSubnet     string `yaml:"podSubnet"`
+		ServiceSubnet string `yaml:"serviceSubnet"`
+	} `yaml:"networking"`
+}
+
+// getClusterCIDRs looks for the pod and service ranges in the kubeadm-config
+// ConfigMap and, failing that, in the flags of the control plane static pods.
+// Managed platforms usually expose neither, in which case both are empty.
+func (s *Service) getClusterCIDRs(ctx context.Context) (podCIDRs, serviceCIDRs []string) {
+	cm, err := s.client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(ctx, "kubeadm-config", metav1.GetOptions{})
+	if err == nil {
+		var clusterConfig kubeadmClusterConfiguration
+		if yaml.Unmarshal([]byte(cm.Data["ClusterConfiguration"]), &clusterConfig) == nil {
+			podCIDRs = splitCIDRs(clusterConfig.Networking.PodSubnet)
+			serviceCIDRs = splitCIDRs(clusterConfig.Networking.ServiceSubnet)
+		}
+	}
+
+	if len(podCIDRs) == 0 {
+		podCIDRs = s.controlPlaneFlag(ctx, "kube-controller-manager", "--cluster-cidr")
+	}
+	if len(serviceCIDRs) == 0 {
+		serviceCIDRs = s.controlPlaneFlag(ctx, "kube-apiserver", "--service-cluster-ip-range")
+	}
+
+	return podCIDRs, serviceCIDRs
+}
+
+// controlPlaneFlag returns the CIDR list passed as flag to a kubeadm style
+// static pod labelled component=<component> in kube-system
+func (s *Service) controlPlaneFlag(ctx context.Context, component, flag string) []string {
+	pods, err := s.client.CoreV1().Pods(metav1.NamespaceSystem).List(ctx, metav1.ListOptions{
+		LabelSelector: "component=" + component,
+		Limit:         1,
+	})
+	if err != nil || len(pods.Items) == 0 {
+		return nil
+	}
+
+	for _, container := range pods.Items[0].Spec.Containers {
+		if value := flagValue(container, flag); value != "" {
+			return splitCIDRs(value)
+		}
+	}
+	return nil
+}
+
+func flagValue(container v1.Container, flag string) string {
+	args := append(slices.Clone(container.Command), container.Args...)
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return value
+		}
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func splitCIDRs(value string) []string {
+	var cidrs []string
+	for _, cidr := range strings.Split(value, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
@@ -0,0 +1,197 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	discoveryFake "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDistributionFromVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"v1.28.2-eks-a5df82a", "eks"},
+		{"v1.27.8-gke.1067004", "gke"},
+		{"v1.28.4+k3s2", "k3s"},
+		{"v1.28.5+rke2r1", "rke2"},
+		{"v1.28.2", DistributionKubernetes},
+		{"unknown", DistributionKubernetes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if result := distributionFromVersion(tt.version); result != tt.expected {
+				t.Errorf("distributionFromVersion(%s) = %s, expected %s", tt.version, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyProviderID(t *testing.T) {
+	tests := []struct {
+		name                 string
+		distribution         string
+		providerIDs          []string
+		expectedPlatform     string
+		expectedDistribution string
+	}{
+		{
+			name:                 "aws",
+			distribution:         DistributionKubernetes,
+			providerIDs:          []string{"aws:///eu-west-1a/i-0123456789"},
+			expectedPlatform:     "aws",
+			expectedDistribution: DistributionKubernetes,
+		},
+		{
+			name:                 "kind",
+			distribution:         DistributionKubernetes,
+			providerIDs:          []string{"kind://docker/kind/kind-control-plane"},
+			expectedPlatform:     "kind",
+			expectedDistribution: "kind",
+		},
+		{
+			name:                 "first recognised provider wins",
+			distribution:         DistributionKubernetes,
+			providerIDs:          []string{"", "custom://node", "gce://project/zone/node", "aws:///zone/id"},
+			expectedPlatform:     "gcp",
+			expectedDistribution: DistributionKubernetes,
+		},
+		{
+			name:             "platform without distribution",
+			providerIDs:      []string{"kind://docker/kind/kind-control-plane"},
+			expectedPlatform: "kind",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := ClusterInfo{Distribution: tt.distribution}
+			for _, providerID := range tt.providerIDs {
+				info.applyProviderID(providerID)
+			}
+			if info.Platform != tt.expectedPlatform {
+				t.Errorf("Expected platform %s, got %s", tt.expectedPlatform, info.Platform)
+			}
+			if info.Distribution != tt.expectedDistribution {
+				t.Errorf("Expected distribution %s, got %s", tt.expectedDistribution, info.Distribution)
+			}
+		})
+	}
+}
+
+func TestGetClusterInfoDetails(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: types.UID("8f0c6a3e-1111-2222-3333-444455556666")},
+		},
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}},
+					Ports:     []v1.EndpointPort{{Name: "https", Port: 6443}},
+				},
+			},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubeadm-config", Namespace: "kube-system"},
+			Data: map[string]string{
+				"ClusterConfiguration": "networking:\n  podSubnet: 10.244.0.0/16,fd00:10:244::/56\n",
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kube-apiserver-cp1",
+				Namespace: "kube-system",
+				Labels:    map[string]string{"component": "kube-apiserver"},
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{
+						Name:    "kube-apiserver",
+						Command: []string{"kube-apiserver", "--secure-port=6443", "--service-cluster-ip-range=10.96.0.0/12"},
+					},
+				},
+			},
+		},
+	)
+	client.Discovery().(*discoveryFake.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.28.4+k3s2"}
+
+	service := NewService(client, "test-cluster")
+	info := service.getClusterInfo(context.Background())
+
+	if info.ID != "8f0c6a3e-1111-2222-3333-444455556666" {
+		t.Errorf("Expected cluster ID from kube-system UID, got %s", info.ID)
+	}
+	if info.Distribution != "k3s" {
+		t.Errorf("Expected distribution k3s, got %s", info.Distribution)
+	}
+	if !slices.Equal(info.APIServerEndpoints, []string{"10.0.0.1:6443", "10.0.0.2:6443"}) {
+		t.Errorf("Unexpected API server endpoints: %v", info.APIServerEndpoints)
+	}
+	if !slices.Equal(info.PodCIDRs, []string{"10.244.0.0/16", "fd00:10:244::/56"}) {
+		t.Errorf("Unexpected pod CIDRs: %v", info.PodCIDRs)
+	}
+	if !slices.Equal(info.ServiceCIDRs, []string{"10.96.0.0/12"}) {
+		t.Errorf("Expected service CIDR from kube-apiserver flags, got %v", info.ServiceCIDRs)
+	}
+}
+
+func TestGetClusterInfoMissingDetails(t *testing.T) {
+	service := NewService(fake.NewSimpleClientset(), "test-cluster")
+	info := service.getClusterInfo(context.Background())
+
+	if info.ID != "" || info.APIServerEndpoints != nil || info.PodCIDRs != nil || info.ServiceCIDRs != nil {
+		t.Errorf("Expected optional details to be empty, got %+v", info)
+	}
+}
+
+func TestGetClusterInfoCachesDetails(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: types.UID("cluster-a")},
+	})
+	now := time.Now()
+	service := NewService(client, "test-cluster", WithClusterInfoRefresh(time.Minute))
+	service.details.now = func() time.Time { return now }
+
+	detailReads := func() int {
+		reads := 0
+		for _, action := range client.Actions() {
+			if action.GetResource().Resource != "version" {
+				reads++
+			}
+		}
+		return reads
+	}
+
+	ctx := context.Background()
+	service.getClusterInfo(ctx)
+	first := detailReads()
+	if first == 0 {
+		t.Fatal("Expected the cluster details to be read")
+	}
+
+	// Within the refresh period only the server version is requested
+	now = now.Add(30 * time.Second)
+	if info := service.getClusterInfo(ctx); info.ID != "cluster-a" {
+		t.Errorf("Expected cached cluster ID, got %q", info.ID)
+	}
+	if reads := detailReads(); reads != first {
+		t.Errorf("Expected no API calls for cached details, got %d more", reads-first)
+	}
+
+	now = now.Add(time.Minute)
+	service.getClusterInfo(ctx)
+	if reads := detailReads(); reads != 2*first {
+		t.Errorf("Expected the details to be read again after the refresh period, got %d reads", reads)
+	}
+}
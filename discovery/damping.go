@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"sync"
+	"time"
+)
+
+// Damping is the hysteresis applied to node status changes. A new status is
+// reported once it has been observed for the given time or for the given
+// number of consecutive discoveries, whichever comes first. Zero disables a
+// criterion; with both zero the change is reported immediately.
+type Damping struct {
+	// NotReadyAfter and NotReadyObservations delay changes away from Ready
+	NotReadyAfter        time.Duration
+	NotReadyObservations int
+	// ReadyAfter and ReadyObservations delay recoveries to Ready
+	ReadyAfter        time.Duration
+	ReadyObservations int
+}
+
+// dampState tracks the status reported for one node and a change waiting to
+// be reported
+type dampState struct {
+	reported     string
+	pending      string
+	pendingSince time.Time
+	pendingCount int
+}
+
+// damper keeps a flapping kubelet from turning every Ready flip into a
+// config push to every Envoy
+type damper struct {
+	damping Damping
+	now     func() time.Time
+
+	mu    sync.Mutex
+	nodes map[string]*dampState
+}
+
+// WithStatusDamping delays reporting node status changes. NodeInfo.Status
+// carries the damped status and NodeInfo.RawStatus the observed one.
+func WithStatusDamping(damping Damping) Option {
+	return func(s *Service) {
+		s.damper = &damper{damping: damping, now: time.Now, nodes: make(map[string]*dampState)}
+	}
+}
+
+// apply replaces the status of every node with its damped status. Nodes seen
+// for the first time are reported as observed, and nodes that are gone are
+// forgotten.
+func (d *damper) apply(nodes []NodeInfo) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	seen := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		seen[node.Name] = true
+		node.RawStatus = node.Status
+
+		state := d.nodes[node.Name]
+		if state == nil {
+			d.nodes[node.Name] = &dampState{reported: node.Status}
+			continue
+		}
+
+		switch {
+		case node.Status == state.reported:
+			state.pending = ""
+		case node.Status != state.pending:
+			state.pending = node.Status
+			state.pendingSince = now
+			state.pendingCount = 1
+		default:
+			state.pendingCount++
+		}
+
+		if state.pending != "" && d.settled(state, now) {
+			state.reported = state.pending
+			state.pending = ""
+		}
+		node.Status = state.reported
+	}
+
+	for name := range d.nodes {
+		if !seen[name] {
+			delete(d.nodes, name)
+		}
+	}
+}
+
+// settled reports whether the pending status has lasted long enough
+func (d *damper) settled(state *dampState, now time.Time) bool {
+	after, observations := d.damping.NotReadyAfter, d.damping.NotReadyObservations
+	if state.pending == "Ready" {
+		after, observations = d.damping.ReadyAfter, d.damping.ReadyObservations
+	}
+
+	if after <= 0 && observations <= 0 {
+		return true
+	}
+	if after > 0 && now.Sub(state.pendingSince) >= after {
+		return true
+	}
+	return observations > 0 && state.pendingCount >= observations
+}
@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStatusDamping(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	node := func(ready v1.ConditionStatus) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "flaky"},
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: ready},
+			}},
+		}
+	}
+	client := fake.NewSimpleClientset(node(v1.ConditionTrue))
+	service := NewService(client, "test-cluster", WithStatusDamping(Damping{
+		NotReadyObservations: 3,
+		ReadyAfter:           time.Minute,
+	}))
+	service.damper.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	observe := func(ready v1.ConditionStatus, after time.Duration) NodeInfo {
+		t.Helper()
+		now = now.Add(after)
+		if _, err := client.CoreV1().Nodes().Update(ctx, node(ready), metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result, err := service.DiscoverNodes(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return result.Nodes[0]
+	}
+
+	steps := []struct {
+		ready     v1.ConditionStatus
+		after     time.Duration
+		status    string
+		rawStatus string
+	}{
+		// First sighting is reported as observed
+		{v1.ConditionTrue, 0, "Ready", "Ready"},
+		// A single flip is damped
+		{v1.ConditionFalse, 5 * time.Second, "Ready", "NotReady"},
+		{v1.ConditionTrue, 5 * time.Second, "Ready", "Ready"},
+		// Three consecutive NotReady observations are reported
+		{v1.ConditionFalse, 5 * time.Second, "Ready", "NotReady"},
+		{v1.ConditionFalse, 5 * time.Second, "Ready", "NotReady"},
+		{v1.ConditionFalse, 5 * time.Second, "NotReady", "NotReady"},
+		// Recovery needs a minute, whatever the number of observations
+		{v1.ConditionTrue, 5 * time.Second, "NotReady", "Ready"},
+		{v1.ConditionTrue, 30 * time.Second, "NotReady", "Ready"},
+		{v1.ConditionTrue, 30 * time.Second, "Ready", "Ready"},
+	}
+	for i, step := range steps {
+		got := observe(step.ready, step.after)
+		if got.Status != step.status || got.RawStatus != step.rawStatus {
+			t.Errorf("Step %d: expected status %s (raw %s), got %s (raw %s)", i, step.status, step.rawStatus, got.Status, got.RawStatus)
+		}
+	}
+}
+
+func TestStatusDamping_ForgetsRemovedNodes(t *testing.T) {
+	d := &damper{damping: Damping{NotReadyObservations: 2}, now: time.Now, nodes: make(map[string]*dampState)}
+
+	d.apply([]NodeInfo{{Name: "a", Status: "Ready"}, {Name: "b", Status: "Ready"}})
+	d.apply([]NodeInfo{{Name: "a", Status: "Ready"}})
+	if _, ok := d.nodes["b"]; ok {
+		t.Error("Expected removed node to be forgotten")
+	}
+
+	// A node coming back is reported as observed
+	nodes := []NodeInfo{{Name: "a", Status: "Ready"}, {Name: "b", Status: "NotReady"}}
+	d.apply(nodes)
+	if nodes[1].Status != "NotReady" {
+		t.Errorf("Expected returning node reported as observed, got %s", nodes[1].Status)
+	}
+}
+
+func TestStatusDamping_Disabled(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}})
+	result, err := NewService(client, "test-cluster").DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Nodes[0].RawStatus != "" {
+		t.Errorf("Expected no raw status without damping, got %s", result.Nodes[0].RawStatus)
+	}
+}
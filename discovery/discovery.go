@@ -2,126 +2,247 @@ package discovery
 
 import (
 	"context"
+	"fmt"
 	"slices"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Reasons reported in NodeInfo.DrainReasons
+const (
+	DrainReasonCordoned           = "Cordoned"
+	DrainReasonDeleting           = "Deleting"
+	DrainReasonAutoscalerDeletion = "AutoscalerDeletion"
+)
+
+// drainTaints are taints placed by node autoscalers right before they remove a node
+var drainTaints = []string{
+	"ToBeDeletedByClusterAutoscaler",
+	"karpenter.sh/disrupted",
+	"karpenter.sh/disruption",
+}
+
+const (
+	// DefaultPageSize is the number of nodes fetched per list call
+	DefaultPageSize int64 = 500
+
+	// maxListRestarts bounds how often an expired continue token restarts the listing
+	maxListRestarts = 3
+)
+
 // KubernetesClient interface for easier testing
 type KubernetesClient interface {
 	kubernetes.Interface
 }
 
 type Service struct {
-	client      KubernetesClient
-	clusterName string
+	client            KubernetesClient
+	clusterName       string
+	pageSize          int64
+	consistentRead    bool
+	addressPreference []AddressPreference
+	legacyAddresses   bool
+	roleRules         []RoleRule
+	identity          *identityStore
+	leases            *leaseWatch
+	damper            *damper
+	details           detailsCache
+
+	// mu guards the settings that can change at runtime
+	mu           sync.RWMutex
+	nodeSelector string
+	sections     []string
 }
 
-func NewService(client KubernetesClient, clusterName string) *Service {
-	return &Service{
-		client:      client,
-		clusterName: clusterName,
+// Option configures optional Service behaviour
+type Option func(*Service)
+
+// WithPageSize sets the number of nodes requested per list call. A value of 0
+// disables pagination and lists all nodes in a single request. Without
+// WithConsistentRead, lists are served from the API server watch cache, which
+// has historically ignored the limit and returned every node at once;
+// pagination only bounds the response size for consistent reads.
+func WithPageSize(pageSize int64) Option {
+	return func(s *Service) {
+		if pageSize >= 0 {
+			s.pageSize = pageSize
+		}
+	}
+}
+
+// WithConsistentRead makes list calls read from etcd instead of the API
+// server watch cache. Consistent reads honour the page size but cost a quorum
+// read per page.
+func WithConsistentRead(consistent bool) Option {
+	return func(s *Service) {
+		s.consistentRead = consistent
+	}
+}
+
+// WithAddressPreference sets the order used to pick each node's primary address
+func WithAddressPreference(prefs []AddressPreference) Option {
+	return func(s *Service) {
+		if len(prefs) > 0 {
+			s.addressPreference = prefs
+		}
 	}
 }
 
+// WithLegacyAddresses toggles the type-keyed address map kept for older
+// Elchi versions
+func WithLegacyAddresses(enabled bool) Option {
+	return func(s *Service) {
+		s.legacyAddresses = enabled
+	}
+}
+
+// WithRoleRules adds label and taint based role mappings on top of the
+// node-role.kubernetes.io/<role> labels
+func WithRoleRules(rules []RoleRule) Option {
+	return func(s *Service) {
+		s.roleRules = rules
+	}
+}
+
+func NewService(client KubernetesClient, clusterName string, opts ...Option) *Service {
+	defaultPreference, _ := ParseAddressPreference(DefaultAddressPreference)
+
+	s := &Service{
+		client:            client,
+		clusterName:       clusterName,
+		pageSize:          DefaultPageSize,
+		addressPreference: defaultPreference,
+		legacyAddresses:   true,
+		details:           detailsCache{refresh: DefaultClusterInfoRefresh, now: time.Now},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
 func (s *Service) DiscoverNodes(ctx context.Context) (*DiscoveryResult, error) {
 	discoveryStart := time.Now()
 
 	// Get cluster info
-	clusterInfo := s.getClusterInfo()
+	clusterInfo := s.getClusterInfo(ctx)
+	if s.identity != nil {
+		if err := s.identity.check(&clusterInfo); err != nil {
+			return nil, err
+		}
+	}
 
 	// Get nodes
-	nodes, err := s.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	nodes, nodeLabels, err := s.listNodes(ctx, &clusterInfo)
 	if err != nil {
 		return nil, err
 	}
+	s.damper.apply(nodes)
+	s.stripClusterDetails(&clusterInfo)
 
 	// Build discovery result
 	result := &DiscoveryResult{
 		Timestamp:   time.Now(),
 		ClusterInfo: clusterInfo,
-		NodeCount:   len(nodes.Items),
-		Nodes:       make([]NodeInfo, 0, len(nodes.Items)),
+		NodeCount:   len(nodes),
+		Nodes:       nodes,
 		Duration:    time.Since(discoveryStart).String(),
+		nodeLabels:  nodeLabels,
 	}
 
-	for _, node := range nodes.Items {
-		nodeInfo := NodeInfo{
-			Name:      node.Name,
-			Roles:     getNodeRoles(&node),
-			Status:    getNodeStatus(&node),
-			Version:   node.Status.NodeInfo.KubeletVersion,
-			Addresses: make(map[string]string),
-		}
+	return result, nil
+}
 
-		for _, address := range node.Status.Addresses {
-			nodeInfo.Addresses[string(address.Type)] = address.Address
+// listNodes pages through the node list and converts every page as it
+// arrives, so only the compact NodeInfo form is retained between pages.
+// An expired continue token (410 Gone) restarts the listing from scratch.
+func (s *Service) listNodes(ctx context.Context, info *ClusterInfo) ([]NodeInfo, map[string]labels.Set, error) {
+	for restarts := 0; ; restarts++ {
+		nodes, nodeLabels, err := s.listNodePages(ctx, info)
+		if err == nil {
+			return nodes, nodeLabels, nil
+		}
+		if !apierrors.IsResourceExpired(err) && !apierrors.IsGone(err) {
+			return nil, nil, err
+		}
+		if restarts >= maxListRestarts {
+			return nil, nil, fmt.Errorf("node list continue token expired %d times: %w", restarts+1, err)
 		}
-
-		result.Nodes = append(result.Nodes, nodeInfo)
 	}
-
-	return result, nil
 }
 
-func (s *Service) getClusterInfo() ClusterInfo {
-	info := ClusterInfo{
-		Name:    s.clusterName, // Cluster name is required from config
-		Version: "unknown",
+func (s *Service) listNodePages(ctx context.Context, info *ClusterInfo) ([]NodeInfo, map[string]labels.Set, error) {
+	opts := metav1.ListOptions{
+		Limit:         s.pageSize,
+		LabelSelector: s.NodeSelector(),
 	}
-
-	// Get cluster version from server version
-	if version, err := s.client.Discovery().ServerVersion(); err == nil && version != nil {
-		info.Version = version.GitVersion
+	if !s.consistentRead {
+		// Serve from the API server watch cache instead of a quorum read
+		opts.ResourceVersion = "0"
 	}
 
-	return info
-}
+	var nodes []NodeInfo
+	nodeLabels := make(map[string]labels.Set)
+	for {
+		list, err := s.client.CoreV1().Nodes().List(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
 
-func getNodeRoles(node *v1.Node) []string {
-	roles := []string{}
+		if nodes == nil {
+			nodes = make([]NodeInfo, 0, len(list.Items))
+		}
+		for i := range list.Items {
+			info.applyProviderID(list.Items[i].Spec.ProviderID)
+			if isExcluded(&list.Items[i]) {
+				continue
+			}
+			nodes = append(nodes, s.buildNodeInfo(&list.Items[i]))
+			// Kept for scoping results to projects, see Service.ApplyScope
+			nodeLabels[list.Items[i].Name] = list.Items[i].Labels
+		}
 
-	// Check for control-plane/master labels
-	if _, ok := node.Labels["node-role.kubernetes.io/control-plane"]; ok {
-		roles = append(roles, "control-plane")
-	}
-	if _, ok := node.Labels["node-role.kubernetes.io/master"]; ok {
-		// Only add master if control-plane is not already present
-		if !slices.Contains(roles, "control-plane") {
-			roles = append(roles, "master")
+		if list.Continue == "" {
+			return nodes, nodeLabels, nil
 		}
-	}
 
-	// Check for worker label
-	if _, ok := node.Labels["node-role.kubernetes.io/worker"]; ok {
-		roles = append(roles, "worker")
+		// Continue tokens carry their own resource version
+		opts.Continue = list.Continue
+		opts.ResourceVersion = ""
 	}
+}
 
-	// Check for etcd label
-	if _, ok := node.Labels["node-role.kubernetes.io/etcd"]; ok {
-		roles = append(roles, "etcd")
+func (s *Service) buildNodeInfo(node *v1.Node) NodeInfo {
+	nodeInfo := NodeInfo{
+		Name:        node.Name,
+		UID:         string(node.UID),
+		Roles:       getNodeRoles(node, s.roleRules),
+		Status:      getNodeStatus(node),
+		Version:     node.Status.NodeInfo.KubeletVersion,
+		AddressList: getNodeAddresses(node),
+		Conditions:  getNodeConditions(node),
 	}
 
-	// If no role labels found, check taints for control-plane
-	if len(roles) == 0 {
-		for _, taint := range node.Spec.Taints {
-			if taint.Key == "node-role.kubernetes.io/control-plane" ||
-				taint.Key == "node-role.kubernetes.io/master" {
-				roles = append(roles, "control-plane")
-				break
-			}
-		}
+	nodeInfo.PrimaryAddress = primaryAddress(nodeInfo.AddressList, s.addressPreference)
+	if s.legacyAddresses {
+		nodeInfo.Addresses = legacyAddressMap(nodeInfo.AddressList)
 	}
+	applyAnnotations(node, &nodeInfo)
+	s.leases.applyLease(&nodeInfo)
 
-	// Default to worker if no specific role found
-	if len(roles) == 0 {
-		roles = append(roles, "worker")
-	}
+	schedulable, draining, drainReasons := getSchedulingState(node)
+	nodeInfo.Schedulable, nodeInfo.Draining, nodeInfo.DrainReasons = &schedulable, &draining, drainReasons
+	s.stripSections(&nodeInfo)
 
-	return roles
+	return nodeInfo
 }
 
 func getNodeStatus(node *v1.Node) string {
@@ -135,3 +256,42 @@ func getNodeStatus(node *v1.Node) string {
 	}
 	return "Unknown"
 }
+
+// getNodeConditions copies every node condition, including custom ones such
+// as those set by node-problem-detector
+func getNodeConditions(node *v1.Node) []NodeCondition {
+	conditions := make([]NodeCondition, 0, len(node.Status.Conditions))
+	for _, condition := range node.Status.Conditions {
+		conditions = append(conditions, NodeCondition{
+			Type:               string(condition.Type),
+			Status:             string(condition.Status),
+			Reason:             condition.Reason,
+			LastTransitionTime: condition.LastTransitionTime.Time,
+		})
+	}
+	return conditions
+}
+
+// getSchedulingState derives whether new traffic should be placed on the node.
+// A node is draining when it is being deleted or an autoscaler has marked it
+// for removal; cordoned nodes are unschedulable but not necessarily draining.
+func getSchedulingState(node *v1.Node) (schedulable bool, draining bool, reasons []string) {
+	if node.Spec.Unschedulable {
+		reasons = append(reasons, DrainReasonCordoned)
+	}
+
+	if node.DeletionTimestamp != nil {
+		draining = true
+		reasons = append(reasons, DrainReasonDeleting)
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if slices.Contains(drainTaints, taint.Key) {
+			draining = true
+			reasons = append(reasons, DrainReasonAutoscalerDeletion)
+			break
+		}
+	}
+
+	return len(reasons) == 0, draining, reasons
+}
@@ -2,14 +2,18 @@ package discovery
 
 import (
 	"context"
+	"slices"
 	"testing"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/version"
 	discoveryFake "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestNewService(t *testing.T) {
@@ -181,6 +185,111 @@ func TestDiscoverNodes(t *testing.T) {
 	}
 }
 
+func TestDiscoverNodesPagination(t *testing.T) {
+	page := func(cont string, names ...string) *v1.NodeList {
+		list := &v1.NodeList{ListMeta: metav1.ListMeta{Continue: cont}}
+		for _, name := range names {
+			list.Items = append(list.Items, v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+		return list
+	}
+	expired := apierrors.NewResourceExpired("continue token expired")
+	gone := apierrors.NewGone("continue token expired")
+
+	tests := []struct {
+		name      string
+		responses []runtime.Object
+		errors    []error
+		expected  []string
+		expectErr bool
+	}{
+		{
+			name:      "multiple pages",
+			responses: []runtime.Object{page("t1", "node1", "node2"), page("", "node3")},
+			errors:    []error{nil, nil},
+			expected:  []string{"node1", "node2", "node3"},
+		},
+		{
+			name:      "expired continue token restarts listing",
+			responses: []runtime.Object{page("t1", "node1"), nil, page("t2", "node1"), page("", "node2")},
+			errors:    []error{nil, expired, nil, nil},
+			expected:  []string{"node1", "node2"},
+		},
+		{
+			// Nodes of the abandoned listing are not reported twice
+			name:      "410 Gone restarts listing",
+			responses: []runtime.Object{page("t1", "node1"), page("t2", "node2"), nil, page("t1", "node1"), page("", "node2")},
+			errors:    []error{nil, nil, gone, nil, nil},
+			expected:  []string{"node1", "node2"},
+		},
+		{
+			name:      "persistently expired token gives up",
+			responses: []runtime.Object{nil, nil, nil, nil},
+			errors:    []error{expired, expired, expired, expired},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			calls := 0
+			client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if calls >= len(tt.responses) {
+					t.Fatalf("Unexpected list call %d", calls+1)
+				}
+				obj, err := tt.responses[calls], tt.errors[calls]
+				calls++
+				return true, obj, err
+			})
+
+			service := NewService(client, "test-cluster", WithPageSize(2))
+			result, err := service.DiscoverNodes(context.Background())
+
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DiscoverNodes() error = %v", err)
+			}
+			if calls != len(tt.responses) {
+				t.Errorf("Expected %d list calls, got %d", len(tt.responses), calls)
+			}
+			if result.NodeCount != len(tt.expected) {
+				t.Fatalf("Expected NodeCount = %d, got %d", len(tt.expected), result.NodeCount)
+			}
+			for i, name := range tt.expected {
+				if result.Nodes[i].Name != name {
+					t.Errorf("Expected node %d to be %s, got %s", i, name, result.Nodes[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestNewServiceOptions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	service := NewService(client, "test-cluster")
+	if service.pageSize != DefaultPageSize {
+		t.Errorf("Expected default pageSize %d, got %d", DefaultPageSize, service.pageSize)
+	}
+	if service.consistentRead {
+		t.Error("Expected consistentRead to default to false")
+	}
+
+	service = NewService(client, "test-cluster", WithPageSize(0), WithConsistentRead(true))
+	if service.pageSize != 0 {
+		t.Errorf("Expected pageSize 0, got %d", service.pageSize)
+	}
+	if !service.consistentRead {
+		t.Error("Expected consistentRead to be true")
+	}
+}
+
 func TestGetNodeStatus(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -292,7 +401,7 @@ func TestGetClusterInfo(t *testing.T) {
 			}
 
 			service := NewService(client, tt.clusterName)
-			result := service.getClusterInfo()
+			result := service.getClusterInfo(context.Background())
 
 			if result.Name != tt.expectedName {
 				t.Errorf("Expected cluster name %s, got %s", tt.expectedName, result.Name)
@@ -377,3 +486,118 @@ func TestDiscoverNodesPerformance(t *testing.T) {
 
 	t.Logf("Discovery of 100 nodes completed in: %v", duration)
 }
+
+func TestGetNodeConditions(t *testing.T) {
+	transition := metav1.NewTime(time.Date(2025, 8, 7, 12, 0, 0, 0, time.UTC))
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:               v1.NodeReady,
+					Status:             v1.ConditionTrue,
+					Reason:             "KubeletReady",
+					LastTransitionTime: transition,
+				},
+				{
+					Type:   v1.NodeMemoryPressure,
+					Status: v1.ConditionTrue,
+					Reason: "KubeletHasInsufficientMemory",
+				},
+				{
+					Type:   "KernelDeadlock",
+					Status: v1.ConditionFalse,
+					Reason: "KernelHasNoDeadlock",
+				},
+			},
+		},
+	}
+
+	conditions := getNodeConditions(node)
+	if len(conditions) != 3 {
+		t.Fatalf("Expected 3 conditions, got %d", len(conditions))
+	}
+	if conditions[0].Type != "Ready" || conditions[0].Status != "True" || conditions[0].Reason != "KubeletReady" {
+		t.Errorf("Unexpected Ready condition: %+v", conditions[0])
+	}
+	if !conditions[0].LastTransitionTime.Equal(transition.Time) {
+		t.Errorf("Expected last transition time %v, got %v", transition.Time, conditions[0].LastTransitionTime)
+	}
+	if conditions[1].Type != "MemoryPressure" || conditions[1].Status != "True" {
+		t.Errorf("Unexpected MemoryPressure condition: %+v", conditions[1])
+	}
+	if conditions[2].Type != "KernelDeadlock" {
+		t.Errorf("Expected custom condition to be preserved, got %+v", conditions[2])
+	}
+}
+
+func TestGetSchedulingState(t *testing.T) {
+	deletion := metav1.Now()
+
+	tests := []struct {
+		name                string
+		node                v1.Node
+		expectedSchedulable bool
+		expectedDraining    bool
+		expectedReasons     []string
+	}{
+		{
+			name:                "schedulable node",
+			node:                v1.Node{},
+			expectedSchedulable: true,
+		},
+		{
+			name: "cordoned node",
+			node: v1.Node{
+				Spec: v1.NodeSpec{Unschedulable: true},
+			},
+			expectedReasons: []string{DrainReasonCordoned},
+		},
+		{
+			name: "node being deleted",
+			node: v1.Node{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &deletion},
+			},
+			expectedDraining: true,
+			expectedReasons:  []string{DrainReasonDeleting},
+		},
+		{
+			name: "cordoned node marked by cluster autoscaler",
+			node: v1.Node{
+				Spec: v1.NodeSpec{
+					Unschedulable: true,
+					Taints: []v1.Taint{
+						{Key: "ToBeDeletedByClusterAutoscaler", Effect: v1.TaintEffectNoSchedule},
+					},
+				},
+			},
+			expectedDraining: true,
+			expectedReasons:  []string{DrainReasonCordoned, DrainReasonAutoscalerDeletion},
+		},
+		{
+			name: "unrelated taint",
+			node: v1.Node{
+				Spec: v1.NodeSpec{
+					Taints: []v1.Taint{
+						{Key: "dedicated", Value: "ingress", Effect: v1.TaintEffectNoSchedule},
+					},
+				},
+			},
+			expectedSchedulable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedulable, draining, reasons := getSchedulingState(&tt.node)
+			if schedulable != tt.expectedSchedulable {
+				t.Errorf("Expected schedulable = %t, got %t", tt.expectedSchedulable, schedulable)
+			}
+			if draining != tt.expectedDraining {
+				t.Errorf("Expected draining = %t, got %t", tt.expectedDraining, draining)
+			}
+			if !slices.Equal(reasons, tt.expectedReasons) {
+				t.Errorf("Expected reasons %v, got %v", tt.expectedReasons, reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Policies applied when the cluster ID reported under a cluster name changes
+const (
+	IdentityPolicyWarn = "warn"
+	IdentityPolicyFail = "fail"
+)
+
+// ErrClusterIDChanged is returned by DiscoverNodes under IdentityPolicyFail
+// when the configured cluster name now points at a different cluster
+var ErrClusterIDChanged = errors.New("cluster ID changed for cluster name")
+
+// identityStore pins the cluster ID seen for each cluster name, in memory and
+// optionally in a JSON file so the check also holds across restarts
+type identityStore struct {
+	mu     sync.Mutex
+	path   string
+	policy string
+	ids    map[string]string
+}
+
+// WithClusterIdentity pins the cluster ID per cluster name. With a non-empty
+// path the pinned IDs are persisted; policy is IdentityPolicyWarn or
+// IdentityPolicyFail.
+func WithClusterIdentity(path, policy string) Option {
+	return func(s *Service) {
+		s.identity = &identityStore{path: path, policy: policy}
+	}
+}
+
+// check compares the discovered cluster ID with the pinned one. Under the warn
+// policy the new ID replaces the old one and PreviousID is set on info.
+func (st *identityStore) check(info *ClusterInfo) error {
+	if info.ID == "" {
+		return nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.ids == nil {
+		ids, err := st.load()
+		if err != nil {
+			return err
+		}
+		st.ids = ids
+	}
+
+	previous, ok := st.ids[info.Name]
+	if ok && previous == info.ID {
+		return nil
+	}
+
+	if ok {
+		if st.policy == IdentityPolicyFail {
+			return fmt.Errorf("%w %q: pinned %s, discovered %s", ErrClusterIDChanged, info.Name, previous, info.ID)
+		}
+		info.PreviousID = previous
+	}
+
+	st.ids[info.Name] = info.ID
+	return st.save()
+}
+
+func (st *identityStore) load() (map[string]string, error) {
+	ids := make(map[string]string)
+	if st.path == "" {
+		return ids, nil
+	}
+
+	data, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster identity file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster identity file: %w", err)
+	}
+	return ids, nil
+}
+
+func (st *identityStore) save() error {
+	if st.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(st.ids, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cluster identity: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated file
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), ".cluster-identity-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cluster identity file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cluster identity file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cluster identity file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), st.path); err != nil {
+		return fmt.Errorf("failed to write cluster identity file: %w", err)
+	}
+	return nil
+}
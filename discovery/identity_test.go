@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIdentityStoreCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         string
+		expectErr      bool
+		expectedPrevID string
+	}{
+		{
+			name:           "warn policy reports previous ID",
+			policy:         IdentityPolicyWarn,
+			expectedPrevID: "cluster-a",
+		},
+		{
+			name:      "fail policy rejects changed ID",
+			policy:    IdentityPolicyFail,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cluster-id.json")
+
+			first := &identityStore{path: path, policy: tt.policy}
+			if err := first.check(&ClusterInfo{Name: "prod", ID: "cluster-a"}); err != nil {
+				t.Fatalf("check() error = %v", err)
+			}
+
+			// A new store simulates a restart reading the persisted ID
+			second := &identityStore{path: path, policy: tt.policy}
+			info := &ClusterInfo{Name: "prod", ID: "cluster-b"}
+			err := second.check(info)
+
+			if tt.expectErr {
+				if !errors.Is(err, ErrClusterIDChanged) {
+					t.Errorf("Expected ErrClusterIDChanged, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("check() error = %v", err)
+			}
+			if info.PreviousID != tt.expectedPrevID {
+				t.Errorf("Expected PreviousID %s, got %s", tt.expectedPrevID, info.PreviousID)
+			}
+
+			// The warning is reported once, then the new ID is pinned
+			info = &ClusterInfo{Name: "prod", ID: "cluster-b"}
+			if err := second.check(info); err != nil || info.PreviousID != "" {
+				t.Errorf("Expected new ID to be pinned, got PreviousID %q, err %v", info.PreviousID, err)
+			}
+		})
+	}
+}
+
+func TestIdentityStoreSkipsUnknownID(t *testing.T) {
+	store := &identityStore{policy: IdentityPolicyFail}
+	if err := store.check(&ClusterInfo{Name: "prod"}); err != nil {
+		t.Errorf("Expected no error without a cluster ID, got %v", err)
+	}
+}
+
+func TestDiscoverNodesClusterIDChanged(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: types.UID("cluster-a")},
+	})
+
+	now := time.Now()
+	service := NewService(client, "prod", WithClusterIdentity("", IdentityPolicyFail))
+	service.details.now = func() time.Time { return now }
+	if _, err := service.DiscoverNodes(context.Background()); err != nil {
+		t.Fatalf("DiscoverNodes() error = %v", err)
+	}
+
+	if err := client.CoreV1().Namespaces().Delete(context.Background(), "kube-system", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete namespace: %v", err)
+	}
+	_, err := client.CoreV1().Namespaces().Create(context.Background(), &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: types.UID("cluster-b")},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+
+	// The new ID is read once the cached cluster details expire
+	now = now.Add(DefaultClusterInfoRefresh)
+	if _, err := service.DiscoverNodes(context.Background()); !errors.Is(err, ErrClusterIDChanged) {
+		t.Errorf("Expected ErrClusterIDChanged, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultLeaseStaleAfter matches the kubelet's default lease duration; a
+	// healthy kubelet renews its lease every quarter of it
+	DefaultLeaseStaleAfter = 40 * time.Second
+
+	// leaseSyncTimeout bounds the wait for the initial lease list
+	leaseSyncTimeout = 30 * time.Second
+)
+
+// leaseWatch follows the node heartbeats in kube-node-lease. The kubelet
+// renews its lease every few seconds, long before the node lifecycle
+// controller marks a silent node NotReady.
+type leaseWatch struct {
+	staleAfter time.Duration
+	now        func() time.Time
+	lister     coordinationlisters.LeaseNamespaceLister
+}
+
+// WithNodeLeases reports the node heartbeats from kube-node-lease, marking a
+// node's lease stale when it was not renewed for staleAfter. The watch starts
+// with StartLeaseWatch.
+func WithNodeLeases(staleAfter time.Duration) Option {
+	return func(s *Service) {
+		if staleAfter <= 0 {
+			staleAfter = DefaultLeaseStaleAfter
+		}
+		s.leases = &leaseWatch{staleAfter: staleAfter, now: time.Now}
+	}
+}
+
+// StartLeaseWatch starts watching node leases until ctx is cancelled and waits
+// for the initial list. It does nothing unless WithNodeLeases is set.
+func (s *Service) StartLeaseWatch(ctx context.Context) error {
+	if s.leases == nil {
+		return nil
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(v1.NamespaceNodeLease))
+	informer := factory.Coordination().V1().Leases()
+	s.leases.lister = informer.Lister().Leases(v1.NamespaceNodeLease)
+	factory.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, leaseSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), informer.Informer().HasSynced) {
+		return errors.New("timed out waiting for node leases, check list and watch access to leases in " + v1.NamespaceNodeLease)
+	}
+	return nil
+}
+
+// applyLease sets the heartbeat of a node from its lease. Nodes without a
+// lease, or before the watch has started, keep their heartbeat unset.
+func (lw *leaseWatch) applyLease(nodeInfo *NodeInfo) {
+	if lw == nil || lw.lister == nil {
+		return
+	}
+
+	lease, err := lw.lister.Get(nodeInfo.Name)
+	if err != nil || lease.Spec.RenewTime == nil {
+		return
+	}
+
+	renewed := lease.Spec.RenewTime.Time
+	age := lw.now().Sub(renewed)
+	if age < 0 {
+		// Clock skew between the agent and the kubelet
+		age = 0
+	}
+
+	nodeInfo.LastHeartbeat = &renewed
+	nodeInfo.HeartbeatAgeSeconds = age.Seconds()
+	nodeInfo.LeaseStale = age > lw.staleAfter
+}
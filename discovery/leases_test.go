@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeLeases(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	lease := func(name string, renewed time.Time) *coordinationv1.Lease {
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: v1.NamespaceNodeLease},
+			Spec:       coordinationv1.LeaseSpec{RenewTime: &renewTime},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "fresh"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "silent"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "no-lease"}},
+		lease("fresh", now.Add(-5*time.Second)),
+		lease("silent", now.Add(-90*time.Second)),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service := NewService(client, "test-cluster", WithNodeLeases(40*time.Second))
+	service.leases.now = func() time.Time { return now }
+	if err := service.StartLeaseWatch(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result, err := service.DiscoverNodes(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	nodes := map[string]NodeInfo{}
+	for _, node := range result.Nodes {
+		nodes[node.Name] = node
+	}
+
+	fresh := nodes["fresh"]
+	if fresh.LeaseStale || fresh.LastHeartbeat == nil || fresh.HeartbeatAgeSeconds != 5 {
+		t.Errorf("Expected fresh heartbeat 5s old, got stale=%v age=%v", fresh.LeaseStale, fresh.HeartbeatAgeSeconds)
+	}
+	silent := nodes["silent"]
+	if !silent.LeaseStale || silent.HeartbeatAgeSeconds != 90 || !silent.LastHeartbeat.Equal(now.Add(-90*time.Second)) {
+		t.Errorf("Expected stale lease 90s old, got stale=%v age=%v", silent.LeaseStale, silent.HeartbeatAgeSeconds)
+	}
+	if missing := nodes["no-lease"]; missing.LeaseStale || missing.LastHeartbeat != nil {
+		t.Errorf("Expected no heartbeat for a node without lease, got %+v", missing)
+	}
+}
+
+func TestNodeLeases_Disabled(t *testing.T) {
+	service := NewService(fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}), "test-cluster")
+	if err := service.StartLeaseWatch(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result, err := service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Nodes[0].LastHeartbeat != nil {
+		t.Error("Expected no heartbeat without lease watching")
+	}
+}
@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// nodeRoleLabelPrefix is the well-known prefix of node-role.kubernetes.io/<role> labels
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// roleOrder keeps the built-in roles first, in the order they were always reported
+var roleOrder = []string{"control-plane", "master", "worker", "etcd"}
+
+// RoleRule maps a node label or taint to a role name
+type RoleRule struct {
+	Role string
+	// Label matches nodes carrying this label key
+	Label string
+	// Value optionally restricts Label or Taint matches to this value
+	Value string
+	// Taint matches nodes carrying a taint with this key
+	Taint string
+}
+
+// Validate reports whether the rule names a role and exactly one selector
+func (r RoleRule) Validate() error {
+	if r.Role == "" {
+		return errors.New("role rule must set a role name")
+	}
+	if (r.Label == "") == (r.Taint == "") {
+		return fmt.Errorf("role rule %q must set exactly one of label or taint", r.Role)
+	}
+	return nil
+}
+
+func (r RoleRule) matches(node *v1.Node) bool {
+	if r.Label != "" {
+		value, ok := node.Labels[r.Label]
+		return ok && (r.Value == "" || value == r.Value)
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == r.Taint && (r.Value == "" || taint.Value == r.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// getNodeRoles derives roles from every node-role.kubernetes.io/<role> label
+// and the configured rules, falling back to taints and finally "worker"
+func getNodeRoles(node *v1.Node, rules []RoleRule) []string {
+	roles := []string{}
+
+	for key := range node.Labels {
+		role, ok := strings.CutPrefix(key, nodeRoleLabelPrefix)
+		if ok && role != "" && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.matches(node) && !slices.Contains(roles, rule.Role) {
+			roles = append(roles, rule.Role)
+		}
+	}
+
+	// Only report master if control-plane is not already present
+	if slices.Contains(roles, "control-plane") {
+		roles = slices.DeleteFunc(roles, func(role string) bool { return role == "master" })
+	}
+
+	sortRoles(roles)
+
+	// If no role labels found, check taints for control-plane
+	if len(roles) == 0 {
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == "node-role.kubernetes.io/control-plane" ||
+				taint.Key == "node-role.kubernetes.io/master" {
+				roles = append(roles, "control-plane")
+				break
+			}
+		}
+	}
+
+	// Default to worker if no specific role found
+	if len(roles) == 0 {
+		roles = append(roles, "worker")
+	}
+
+	return roles
+}
+
+// sortRoles orders built-in roles first and everything else alphabetically,
+// so the output does not depend on map iteration order
+func sortRoles(roles []string) {
+	slices.SortFunc(roles, func(a, b string) int {
+		ai, bi := slices.Index(roleOrder, a), slices.Index(roleOrder, b)
+		switch {
+		case ai >= 0 && bi >= 0:
+			return ai - bi
+		case ai >= 0:
+			return -1
+		case bi >= 0:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+}
@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"slices"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetNodeRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		taints   []v1.Taint
+		rules    []RoleRule
+		expected []string
+	}{
+		{
+			name:     "no labels defaults to worker",
+			expected: []string{"worker"},
+		},
+		{
+			name: "control-plane hides master",
+			labels: map[string]string{
+				"node-role.kubernetes.io/master":        "",
+				"node-role.kubernetes.io/control-plane": "",
+				"node-role.kubernetes.io/etcd":          "",
+			},
+			expected: []string{"control-plane", "etcd"},
+		},
+		{
+			name: "master alone",
+			labels: map[string]string{
+				"node-role.kubernetes.io/master": "",
+			},
+			expected: []string{"master"},
+		},
+		{
+			name: "arbitrary role labels",
+			labels: map[string]string{
+				"node-role.kubernetes.io/ingress": "",
+				"node-role.kubernetes.io/edge":    "true",
+				"node-role.kubernetes.io/worker":  "",
+			},
+			expected: []string{"worker", "edge", "ingress"},
+		},
+		{
+			name:     "legacy role label needs a rule",
+			labels:   map[string]string{"kubernetes.io/role": "master"},
+			expected: []string{"worker"},
+		},
+		{
+			name:     "legacy role label rule",
+			labels:   map[string]string{"kubernetes.io/role": "master"},
+			rules:    []RoleRule{{Role: "master", Label: "kubernetes.io/role", Value: "master"}},
+			expected: []string{"master"},
+		},
+		{
+			name:     "control-plane taint fallback",
+			taints:   []v1.Taint{{Key: "node-role.kubernetes.io/control-plane", Effect: v1.TaintEffectNoSchedule}},
+			expected: []string{"control-plane"},
+		},
+		{
+			name:   "label rule with value",
+			labels: map[string]string{"example.com/pool": "edge"},
+			rules: []RoleRule{
+				{Role: "edge", Label: "example.com/pool", Value: "edge"},
+				{Role: "batch", Label: "example.com/pool", Value: "batch"},
+			},
+			expected: []string{"edge"},
+		},
+		{
+			name:     "taint rule",
+			taints:   []v1.Taint{{Key: "nvidia.com/gpu", Value: "present", Effect: v1.TaintEffectNoSchedule}},
+			rules:    []RoleRule{{Role: "gpu", Taint: "nvidia.com/gpu"}},
+			expected: []string{"gpu"},
+		},
+		{
+			name:     "rule does not duplicate label role",
+			labels:   map[string]string{"node-role.kubernetes.io/ingress": "", "example.com/ingress": "true"},
+			rules:    []RoleRule{{Role: "ingress", Label: "example.com/ingress"}},
+			expected: []string{"ingress"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Labels: tt.labels},
+				Spec:       v1.NodeSpec{Taints: tt.taints},
+			}
+			roles := getNodeRoles(node, tt.rules)
+			if !slices.Equal(roles, tt.expected) {
+				t.Errorf("getNodeRoles() = %v, expected %v", roles, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRoleRuleValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      RoleRule
+		expectErr bool
+	}{
+		{name: "label rule", rule: RoleRule{Role: "edge", Label: "pool"}},
+		{name: "taint rule", rule: RoleRule{Role: "gpu", Taint: "nvidia.com/gpu"}},
+		{name: "missing role", rule: RoleRule{Label: "pool"}, expectErr: true},
+		{name: "missing selector", rule: RoleRule{Role: "edge"}, expectErr: true},
+		{name: "both selectors", rule: RoleRule{Role: "edge", Label: "pool", Taint: "pool"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Validate() error = %v, expectErr %t", err, tt.expectErr)
+			}
+		})
+	}
+}
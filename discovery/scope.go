@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Scope selects the part of the cluster reported to one Elchi project. A
+// node is in scope when it matches the node selector and, if a namespace or
+// service selector is set, runs an endpoint of a selected Service.
+type Scope struct {
+	nodeSelector      labels.Selector
+	namespaceSelector labels.Selector
+	serviceSelector   labels.Selector
+	// workloads is set when nodes are selected by the services they serve
+	workloads bool
+}
+
+// NewScope parses the label selectors of a scope. Empty selectors select
+// everything; with both namespace and service selector empty, nodes are
+// selected by their own labels only.
+func NewScope(nodeSelector, namespaceSelector, serviceSelector string) (*Scope, error) {
+	scope := &Scope{workloads: namespaceSelector != "" || serviceSelector != ""}
+
+	var err error
+	if scope.nodeSelector, err = labels.Parse(nodeSelector); err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %w", nodeSelector, err)
+	}
+	if scope.namespaceSelector, err = labels.Parse(namespaceSelector); err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %q: %w", namespaceSelector, err)
+	}
+	if scope.serviceSelector, err = labels.Parse(serviceSelector); err != nil {
+		return nil, fmt.Errorf("invalid service selector %q: %w", serviceSelector, err)
+	}
+	return scope, nil
+}
+
+// ApplyScope returns a copy of result holding only the nodes in scope. The
+// result must come from DiscoverNodes, which keeps the node labels. Selecting
+// by namespace or service lists Services and EndpointSlices, which needs list
+// permission on namespaces, services and endpointslices.
+func (s *Service) ApplyScope(ctx context.Context, result *DiscoveryResult, scope *Scope) (*DiscoveryResult, error) {
+	if scope == nil {
+		return result, nil
+	}
+
+	var serving map[string]bool
+	if scope.workloads {
+		var err error
+		if serving, err = s.servingNodes(ctx, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	scoped := *result
+	scoped.Nodes = make([]NodeInfo, 0, len(result.Nodes))
+	for _, node := range result.Nodes {
+		if !scope.nodeSelector.Matches(result.nodeLabels[node.Name]) {
+			continue
+		}
+		if scope.workloads && !serving[node.Name] {
+			continue
+		}
+		scoped.Nodes = append(scoped.Nodes, node)
+	}
+	scoped.NodeCount = len(scoped.Nodes)
+
+	return &scoped, nil
+}
+
+// servingNodes returns the names of nodes running an endpoint of a Service
+// selected by scope
+func (s *Service) servingNodes(ctx context.Context, scope *Scope) (map[string]bool, error) {
+	namespaces, err := s.scopeNamespaces(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]bool)
+	for _, namespace := range namespaces {
+		services, err := s.client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: scope.serviceSelector.String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services: %w", err)
+		}
+		if len(services.Items) == 0 {
+			continue
+		}
+
+		// One list per namespace, indexed by Service, instead of one per Service
+		endpointSlices, err := s.client.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: discoveryv1.LabelServiceName,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list endpoint slices: %w", err)
+		}
+		slicesByService := make(map[types.NamespacedName][]*discoveryv1.EndpointSlice)
+		for i := range endpointSlices.Items {
+			slice := &endpointSlices.Items[i]
+			key := types.NamespacedName{Namespace: slice.Namespace, Name: slice.Labels[discoveryv1.LabelServiceName]}
+			slicesByService[key] = append(slicesByService[key], slice)
+		}
+
+		for _, service := range services.Items {
+			for _, slice := range slicesByService[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}] {
+				for _, endpoint := range slice.Endpoints {
+					if endpoint.NodeName != nil {
+						nodes[*endpoint.NodeName] = true
+					}
+				}
+			}
+		}
+	}
+
+	return nodes, nil
+}
+
+// scopeNamespaces returns the namespaces selected by scope; all namespaces
+// are listed in one call when no namespace selector is set
+func (s *Service) scopeNamespaces(ctx context.Context, scope *Scope) ([]string, error) {
+	if scope.namespaceSelector.Empty() {
+		return []string{metav1.NamespaceAll}, nil
+	}
+
+	list, err := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: scope.namespaceSelector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	namespaces := make([]string, 0, len(list.Items))
+	for _, namespace := range list.Items {
+		namespaces = append(namespaces, namespace.Name)
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func scopeTestClient() *fake.Clientset {
+	node := func(name, team string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"team": team}}}
+	}
+	nodeName := func(name string) *string { return &name }
+
+	return fake.NewSimpleClientset(
+		node("node-a1", "a"),
+		node("node-a2", "a"),
+		node("node-b1", "b"),
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "b"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "a"}}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "shop", Labels: map[string]string{"app": "checkout"}}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "invoices", Namespace: "billing", Labels: map[string]string{"app": "invoices"}}},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "checkout-abcde",
+				Namespace: "shop",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "checkout"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{NodeName: nodeName("node-a2")}, {NodeName: nodeName("node-b1")}},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "invoices-fghij",
+				Namespace: "billing",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "invoices"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{NodeName: nodeName("node-a1")}},
+		},
+	)
+}
+
+func TestApplyScope(t *testing.T) {
+	tests := []struct {
+		name              string
+		nodeSelector      string
+		namespaceSelector string
+		serviceSelector   string
+		expected          []string
+	}{
+		{"everything", "", "", "", []string{"node-a1", "node-a2", "node-b1"}},
+		{"node selector", "team=a", "", "", []string{"node-a1", "node-a2"}},
+		{"namespace selector", "", "team=b", "", []string{"node-a2", "node-b1"}},
+		{"service selector", "", "", "app=invoices", []string{"node-a1"}},
+		{"node and namespace selector", "team=b", "team=b", "", []string{"node-b1"}},
+		{"no match", "", "team=c", "", []string{}},
+	}
+
+	service := NewService(scopeTestClient(), "test-cluster")
+	result, err := service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := NewScope(tt.nodeSelector, tt.namespaceSelector, tt.serviceSelector)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			scoped, err := service.ApplyScope(context.Background(), result, scope)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			names := make([]string, 0, len(scoped.Nodes))
+			for _, node := range scoped.Nodes {
+				names = append(names, node.Name)
+			}
+			if len(names) != len(tt.expected) || scoped.NodeCount != len(tt.expected) {
+				t.Fatalf("Expected nodes %v, got %v (count %d)", tt.expected, names, scoped.NodeCount)
+			}
+			for i := range names {
+				if names[i] != tt.expected[i] {
+					t.Errorf("Expected nodes %v, got %v", tt.expected, names)
+					break
+				}
+			}
+		})
+	}
+
+	if result.NodeCount != 3 {
+		t.Errorf("Expected the original result to be unchanged, got %d nodes", result.NodeCount)
+	}
+}
+
+func TestNewScope_InvalidSelector(t *testing.T) {
+	if _, err := NewScope("team in (a", "", ""); err == nil {
+		t.Error("Expected error for invalid node selector")
+	}
+	if _, err := NewScope("", "", "app in (x"); err == nil {
+		t.Error("Expected error for invalid service selector")
+	}
+}
+
+func TestApplyScope_ListsEndpointSlicesPerNamespace(t *testing.T) {
+	client := scopeTestClient()
+	client.Tracker().Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "cart", Namespace: "shop"}})
+	client.Tracker().Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "search", Namespace: "shop"}})
+
+	service := NewService(client, "test-cluster")
+	result, err := service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	scope, err := NewScope("", "team in (a,b)", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	client.ClearActions()
+	if _, err := service.ApplyScope(context.Background(), result, scope); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lists := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "endpointslices" {
+			lists++
+		}
+	}
+	if lists != 2 {
+		t.Errorf("Expected one endpoint slice list per namespace, got %d", lists)
+	}
+}
@@ -0,0 +1,99 @@
+package discovery
+
+import (
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Optional payload sections. Node names, status and version are always sent.
+const (
+	SectionRoles          = "roles"
+	SectionAddresses      = "addresses"
+	SectionConditions     = "conditions"
+	SectionScheduling     = "scheduling"
+	SectionClusterDetails = "cluster_details"
+)
+
+// Sections lists every optional payload section
+var Sections = []string{
+	SectionRoles,
+	SectionAddresses,
+	SectionConditions,
+	SectionScheduling,
+	SectionClusterDetails,
+}
+
+// SetSections limits the optional sections included in results. A nil slice
+// includes every section.
+func (s *Service) SetSections(sections []string) error {
+	for _, section := range sections {
+		if !slices.Contains(Sections, section) {
+			return fmt.Errorf("unknown section %q", section)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sections = slices.Clone(sections)
+	return nil
+}
+
+// SetNodeSelector restricts discovery to nodes matching a label selector. An
+// empty selector selects every node.
+func (s *Service) SetNodeSelector(selector string) error {
+	if _, err := labels.Parse(selector); err != nil {
+		return fmt.Errorf("invalid node selector %q: %w", selector, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeSelector = selector
+	return nil
+}
+
+// NodeSelector returns the label selector currently applied to node listing
+func (s *Service) NodeSelector() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nodeSelector
+}
+
+// includes reports whether a section is part of the result
+func (s *Service) includes(section string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sections == nil || slices.Contains(s.sections, section)
+}
+
+// stripSections removes the data of excluded sections from a node
+func (s *Service) stripSections(node *NodeInfo) {
+	if !s.includes(SectionRoles) {
+		node.Roles = nil
+	}
+	if !s.includes(SectionAddresses) {
+		node.Addresses = nil
+		node.AddressList = nil
+	}
+	if !s.includes(SectionConditions) {
+		node.Conditions = nil
+	}
+	if !s.includes(SectionScheduling) {
+		node.Schedulable = nil
+		node.Draining = nil
+		node.DrainReasons = nil
+	}
+}
+
+// stripClusterDetails removes the cluster details from info when that
+// section is excluded
+func (s *Service) stripClusterDetails(info *ClusterInfo) {
+	if !s.includes(SectionClusterDetails) {
+		info.Platform = ""
+		info.Distribution = ""
+		info.APIServerEndpoints = nil
+		info.PodCIDRs = nil
+		info.ServiceCIDRs = nil
+	}
+}
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetSections(t *testing.T) {
+	node := dualStackNode()
+	node.Spec.ProviderID = "aws:///eu-west-1a/i-0123456789"
+	client := fake.NewSimpleClientset(node)
+	service := NewService(client, "test-cluster")
+
+	if err := service.SetSections([]string{"labels"}); err == nil {
+		t.Error("Expected error for unknown section")
+	}
+
+	if err := service.SetSections([]string{SectionRoles}); err != nil {
+		t.Fatalf("SetSections() error = %v", err)
+	}
+
+	result, err := service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverNodes() error = %v", err)
+	}
+
+	info := result.Nodes[0]
+	if len(info.Roles) == 0 {
+		t.Error("Expected roles to be included")
+	}
+	if info.AddressList != nil || info.Addresses != nil || info.Conditions != nil || info.Schedulable != nil || info.Draining != nil {
+		t.Errorf("Expected excluded sections to be empty, got %+v", info)
+	}
+	if info.PrimaryAddress == "" {
+		t.Error("Expected primary address to always be included")
+	}
+	if result.ClusterInfo.Distribution != "" || result.ClusterInfo.Platform != "" {
+		t.Errorf("Expected cluster details to be excluded, got %+v", result.ClusterInfo)
+	}
+
+	// nil restores every section
+	if err := service.SetSections(nil); err != nil {
+		t.Fatalf("SetSections() error = %v", err)
+	}
+	result, err = service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverNodes() error = %v", err)
+	}
+	if len(result.Nodes[0].AddressList) == 0 || result.Nodes[0].Schedulable == nil || !*result.Nodes[0].Schedulable {
+		t.Errorf("Expected addresses and scheduling to be included again, got %+v", result.Nodes[0])
+	}
+	if result.ClusterInfo.Platform != "aws" {
+		t.Errorf("Expected platform aws, got %q", result.ClusterInfo.Platform)
+	}
+}
+
+func TestSetNodeSelector(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge1", Labels: map[string]string{"pool": "edge"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "core1", Labels: map[string]string{"pool": "core"}}},
+	)
+	service := NewService(client, "test-cluster")
+
+	if err := service.SetNodeSelector("pool in (edge"); err == nil {
+		t.Error("Expected error for invalid selector")
+	}
+
+	if err := service.SetNodeSelector("pool=edge"); err != nil {
+		t.Fatalf("SetNodeSelector() error = %v", err)
+	}
+	if service.NodeSelector() != "pool=edge" {
+		t.Errorf("Expected selector pool=edge, got %s", service.NodeSelector())
+	}
+
+	result, err := service.DiscoverNodes(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverNodes() error = %v", err)
+	}
+	if result.NodeCount != 1 || result.Nodes[0].Name != "edge1" {
+		t.Errorf("Expected only edge1 to be discovered, got %+v", result.Nodes)
+	}
+}
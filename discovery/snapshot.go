@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+)
+
+// Fingerprint returns a stable hash of the cluster and node data. The
+// timestamp and discovery duration are left out, so two snapshots of an
+// unchanged cluster share the same fingerprint. Of the probe results only
+// the health of each check counts, and of the node lease only whether it is
+// stale; latencies and heartbeats change every cycle.
+func (r *DiscoveryResult) Fingerprint() string {
+	hash := sha256.New()
+	// Encoding plain structs and maps is deterministic and cannot fail here
+	_ = json.NewEncoder(hash).Encode(struct {
+		ClusterInfo ClusterInfo `json:"cluster_info"`
+		Nodes       []NodeInfo  `json:"nodes"`
+	}{r.ClusterInfo, stableNodes(r.Nodes)})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// stableNodes returns nodes without the fields that change on every cycle
+// while the node itself does not. With status damping the observed status
+// and the Ready condition are left out too, so a flap that the damped status
+// does not follow leaves the fingerprint unchanged.
+func stableNodes(nodes []NodeInfo) []NodeInfo {
+	volatile := slices.ContainsFunc(nodes, func(node NodeInfo) bool {
+		return len(node.Probes) > 0 || node.LastHeartbeat != nil || node.RawStatus != ""
+	})
+	if !volatile {
+		return nodes
+	}
+
+	stable := slices.Clone(nodes)
+	for i := range stable {
+		stable[i].LastHeartbeat = nil
+		stable[i].HeartbeatAgeSeconds = 0
+		if stable[i].RawStatus != "" {
+			stable[i].RawStatus = ""
+			stable[i].Conditions = slices.DeleteFunc(slices.Clone(stable[i].Conditions), func(condition NodeCondition) bool {
+				return condition.Type == "Ready"
+			})
+		}
+		if len(stable[i].Probes) == 0 {
+			continue
+		}
+		probes := make([]ProbeResult, len(stable[i].Probes))
+		for j, probe := range stable[i].Probes {
+			probes[j] = ProbeResult{Name: probe.Name, Type: probe.Type, Port: probe.Port, Healthy: probe.Healthy}
+		}
+		stable[i].Probes = probes
+	}
+	return stable
+}
+
+// ReadyCount returns the number of nodes reported as Ready
+func (r *DiscoveryResult) ReadyCount() int {
+	count := 0
+	for _, node := range r.Nodes {
+		if node.Status == "Ready" {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := func() *DiscoveryResult {
+		return &DiscoveryResult{
+			Timestamp:   time.Now(),
+			ClusterInfo: ClusterInfo{Name: "test-cluster", Version: "v1.28.2"},
+			NodeCount:   2,
+			Nodes: []NodeInfo{
+				{Name: "node1", Status: "Ready"},
+				{Name: "node2", Status: "Ready"},
+			},
+			Duration: "10ms",
+		}
+	}
+
+	first := base()
+	second := base()
+	second.Timestamp = first.Timestamp.Add(time.Minute)
+	second.Duration = "25ms"
+
+	if first.Fingerprint() != second.Fingerprint() {
+		t.Error("Expected fingerprint to ignore timestamp and duration")
+	}
+	if len(first.Fingerprint()) != 64 {
+		t.Errorf("Expected hex encoded sha256 fingerprint, got %q", first.Fingerprint())
+	}
+
+	changed := base()
+	changed.Nodes[1].Status = "NotReady"
+	if first.Fingerprint() == changed.Fingerprint() {
+		t.Error("Expected fingerprint to change with node status")
+	}
+}
+
+func TestFingerprint_VolatileFields(t *testing.T) {
+	probed := func(healthy bool, latency float64, streak int) *DiscoveryResult {
+		return &DiscoveryResult{
+			Nodes: []NodeInfo{{
+				Name:   "node1",
+				Status: "Ready",
+				Probes: []ProbeResult{{Name: "ingress", Type: "tcp", Port: 80, Healthy: healthy, LatencyMs: latency, FailureStreak: streak}},
+			}},
+		}
+	}
+
+	if probed(true, 1.5, 0).Fingerprint() != probed(true, 3.2, 0).Fingerprint() {
+		t.Error("Expected fingerprint to ignore probe latency")
+	}
+	if probed(false, 1, 1).Fingerprint() != probed(false, 1, 2).Fingerprint() {
+		t.Error("Expected fingerprint to ignore the failure streak")
+	}
+	if probed(true, 1, 0).Fingerprint() == probed(false, 1, 1).Fingerprint() {
+		t.Error("Expected fingerprint to change with probe health")
+	}
+
+	heartbeat := func(age float64, stale bool) *DiscoveryResult {
+		renewed := time.Now().Add(-time.Duration(age) * time.Second)
+		return &DiscoveryResult{Nodes: []NodeInfo{{Name: "node1", LastHeartbeat: &renewed, HeartbeatAgeSeconds: age, LeaseStale: stale}}}
+	}
+	if heartbeat(3, false).Fingerprint() != heartbeat(8, false).Fingerprint() {
+		t.Error("Expected fingerprint to ignore lease renewals")
+	}
+	if heartbeat(3, false).Fingerprint() == heartbeat(60, true).Fingerprint() {
+		t.Error("Expected fingerprint to change with a stale lease")
+	}
+
+	damped := func(rawStatus, ready string) *DiscoveryResult {
+		return &DiscoveryResult{Nodes: []NodeInfo{{
+			Name:       "node1",
+			Status:     "Ready",
+			RawStatus:  rawStatus,
+			Conditions: []NodeCondition{{Type: "Ready", Status: ready}, {Type: "DiskPressure", Status: "False"}},
+		}}}
+	}
+	if damped("Ready", "True").Fingerprint() != damped("NotReady", "False").Fingerprint() {
+		t.Error("Expected fingerprint to ignore a flap the damped status does not follow")
+	}
+	flapped := damped("NotReady", "False")
+	flapped.Nodes[0].Status = "NotReady"
+	if damped("Ready", "True").Fingerprint() == flapped.Fingerprint() {
+		t.Error("Expected fingerprint to change with the damped status")
+	}
+
+	result := probed(true, 1.5, 0)
+	result.Fingerprint()
+	if result.Nodes[0].Probes[0].LatencyMs != 1.5 {
+		t.Error("Expected fingerprint to leave the result unchanged")
+	}
+}
+
+func TestReadyCount(t *testing.T) {
+	result := &DiscoveryResult{
+		Nodes: []NodeInfo{
+			{Name: "node1", Status: "Ready"},
+			{Name: "node2", Status: "NotReady"},
+			{Name: "node3", Status: "Unknown"},
+			{Name: "node4", Status: "Ready"},
+		},
+	}
+
+	if count := result.ReadyCount(); count != 2 {
+		t.Errorf("Expected 2 ready nodes, got %d", count)
+	}
+}
@@ -1,18 +1,115 @@
 package discovery
 
-import "time"
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
 
 type ClusterInfo struct {
 	Name    string `json:"cluster_name"`
 	Version string `json:"cluster_version"`
+	// ID is the kube-system namespace UID, stable for the lifetime of the cluster
+	ID string `json:"cluster_id,omitempty"`
+	// PreviousID is set when the ID pinned for this cluster name has changed
+	PreviousID         string   `json:"previous_cluster_id,omitempty"`
+	Platform           string   `json:"platform,omitempty"`
+	Distribution       string   `json:"distribution,omitempty"`
+	APIServerEndpoints []string `json:"api_server_endpoints,omitempty"`
+	PodCIDRs           []string `json:"pod_cidrs,omitempty"`
+	ServiceCIDRs       []string `json:"service_cidrs,omitempty"`
+}
+
+// Hold describes a snapshot refused by the agent's safety guard after a
+// sudden drop in nodes
+type Hold struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason"`
+	// NodeCount and ReadyCount are those of the refused snapshot
+	NodeCount  int `json:"node_count"`
+	ReadyCount int `json:"ready_count"`
+}
+
+// Staleness describes a snapshot resent while the Kubernetes API is unreachable
+type Staleness struct {
+	// Since is when discovery started failing
+	Since time.Time `json:"since"`
+	// AgeSeconds is how old the snapshot is
+	AgeSeconds float64 `json:"age_seconds"`
+	// Error is the latest discovery error
+	Error string `json:"error"`
+}
+
+// NodeCondition mirrors a single entry of the node's status conditions
+type NodeCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	LastTransitionTime time.Time `json:"last_transition_time"`
+}
+
+// NodeAddress is a single node address with its type and IP family
+type NodeAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	// Family is IPv4 or IPv6, empty for host and DNS names
+	Family string `json:"family,omitempty"`
 }
 
 type NodeInfo struct {
-	Name      string            `json:"name"`
-	Roles     []string          `json:"roles"`
-	Status    string            `json:"status"`
-	Version   string            `json:"version"`
-	Addresses map[string]string `json:"addresses"`
+	Name  string   `json:"name"`
+	UID   string   `json:"uid,omitempty"`
+	Roles []string `json:"roles"`
+	// Status is Ready, NotReady or Unknown; with status damping it only
+	// changes once the new status has lasted
+	Status string `json:"status"`
+	// RawStatus is the status observed in this discovery, set with status damping
+	RawStatus string `json:"raw_status,omitempty"`
+	Version   string `json:"version"`
+	// Addresses is the legacy type-keyed address map, only set in compatibility mode
+	Addresses      map[string]string `json:"addresses,omitempty"`
+	AddressList    []NodeAddress     `json:"address_list"`
+	PrimaryAddress string            `json:"primary_address,omitempty"`
+	Conditions     []NodeCondition   `json:"conditions"`
+	// Schedulable and Draining are nil when the scheduling section is excluded
+	Schedulable *bool `json:"schedulable,omitempty"`
+	Draining    *bool `json:"draining,omitempty"`
+	// DrainReasons lists why the node is unschedulable or draining
+	DrainReasons []string `json:"drain_reasons,omitempty"`
+	// Weight is set by the elchi.io/weight annotation
+	Weight *int `json:"weight,omitempty"`
+	// AddressOverridden is set when PrimaryAddress comes from the
+	// elchi.io/address annotation
+	AddressOverridden bool `json:"address_overridden,omitempty"`
+	// Metadata holds the metadata.elchi.io/<key> annotations
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// AnnotationErrors lists Elchi annotations ignored for invalid values
+	AnnotationErrors []string `json:"annotation_errors,omitempty"`
+	// LastHeartbeat is the renew time of the node's lease in kube-node-lease,
+	// set when node leases are watched
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	// HeartbeatAgeSeconds is how old LastHeartbeat was at discovery time
+	HeartbeatAgeSeconds float64 `json:"heartbeat_age_seconds,omitempty"`
+	// LeaseStale is set when the kubelet stopped renewing its lease, which
+	// happens well before the node is marked NotReady
+	LeaseStale bool `json:"lease_stale,omitempty"`
+	// Probes holds the active health checks of the node, when probing is enabled
+	Probes []ProbeResult `json:"probes,omitempty"`
+}
+
+// ProbeResult is the outcome of the latest health check of one node port
+type ProbeResult struct {
+	Name string `json:"name"`
+	// Type is tcp, http or grpc
+	Type    string `json:"type"`
+	Port    int    `json:"port"`
+	Healthy bool   `json:"healthy"`
+	// LatencyMs is how long the latest probe took, in milliseconds
+	LatencyMs   float64    `json:"latency_ms"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// FailureStreak counts consecutive failed probes, zero when healthy
+	FailureStreak int    `json:"failure_streak"`
+	Error         string `json:"error,omitempty"`
 }
 
 type DiscoveryResult struct {
@@ -21,4 +118,13 @@ type DiscoveryResult struct {
 	NodeCount   int         `json:"node_count"`
 	Nodes       []NodeInfo  `json:"nodes"`
 	Duration    string      `json:"discovery_duration"`
+	// Hold is set when the agent resends this snapshot in place of a newer
+	// one it refused
+	Hold *Hold `json:"hold,omitempty"`
+	// Stale is set when the agent resends this snapshot because discovery
+	// is failing
+	Stale *Staleness `json:"stale,omitempty"`
+
+	// nodeLabels holds the labels of every node, they are not sent
+	nodeLabels map[string]labels.Set
 }
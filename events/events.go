@@ -0,0 +1,254 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Component is the event source reported for every event
+const Component = "elchi-discovery"
+
+// Event reasons recorded by the agent
+const (
+	ReasonDeliveryFailing    = "DeliveryFailing"
+	ReasonDeliveryRecovered  = "DeliveryRecovered"
+	ReasonTokenRejected      = "TokenRejected"
+	ReasonConfigReloadFailed = "ConfigReloadFailed"
+	ReasonReportedReady      = "ReportedReady"
+	ReasonReportedNotReady   = "ReportedNotReady"
+	ReasonSnapshotHeld       = "SnapshotHeld"
+	ReasonSnapshotReleased   = "SnapshotReleased"
+)
+
+const (
+	// DefaultFailureThreshold is the number of consecutive failed deliveries
+	// before a DeliveryFailing event is recorded
+	DefaultFailureThreshold = 3
+
+	// DefaultNodeEventInterval is the minimum time between two transition
+	// events for the same node
+	DefaultNodeEventInterval = 5 * time.Minute
+)
+
+// Recorder turns agent observations into Kubernetes Events. Delivery and
+// config events are recorded against the agent Pod, readiness transitions
+// against the Node.
+type Recorder struct {
+	recorder          record.EventRecorder
+	pod               *v1.ObjectReference
+	failureThreshold  int
+	nodeEventInterval time.Duration
+	now               func() time.Time
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	failing             bool
+	tokenRejected       bool
+	// nodeReady is the readiness last announced, or seeded, per node
+	nodeReady     map[string]bool
+	lastNodeEvent map[string]time.Time
+}
+
+// Option configures optional Recorder behaviour
+type Option func(*Recorder)
+
+// WithFailureThreshold sets how many consecutive failed deliveries count as sustained
+func WithFailureThreshold(threshold int) Option {
+	return func(r *Recorder) {
+		if threshold > 0 {
+			r.failureThreshold = threshold
+		}
+	}
+}
+
+// WithNodeEventInterval rate limits transition events per node
+func WithNodeEventInterval(interval time.Duration) Option {
+	return func(r *Recorder) {
+		if interval >= 0 {
+			r.nodeEventInterval = interval
+		}
+	}
+}
+
+// NewRecorder wraps an event recorder. pod may be nil when the agent does not
+// know its own Pod, in which case agent level events are dropped.
+func NewRecorder(recorder record.EventRecorder, pod *v1.ObjectReference, opts ...Option) *Recorder {
+	r := &Recorder{
+		recorder:          recorder,
+		pod:               pod,
+		failureThreshold:  DefaultFailureThreshold,
+		nodeEventInterval: DefaultNodeEventInterval,
+		now:               time.Now,
+		lastNodeEvent:     make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// NewBroadcastRecorder creates an event recorder that writes to the API server.
+// The broadcaster applies client-go's own per-object spam filter on top of
+// the Recorder's rate limiting.
+func NewBroadcastRecorder(client kubernetes.Interface) (record.EventRecorder, func()) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: Component})
+	return recorder, broadcaster.Shutdown
+}
+
+// PodReference resolves the agent's own Pod. The UID is filled in when the Pod
+// can be read, so events show up in kubectl describe pod.
+func PodReference(ctx context.Context, client kubernetes.Interface, namespace, name string) *v1.ObjectReference {
+	if namespace == "" || name == "" {
+		return nil
+	}
+
+	ref := &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       name,
+	}
+	if pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		ref.UID = pod.UID
+	}
+	return ref
+}
+
+// DeliveryFailed records a failed delivery to the Elchi API. Token rejections
+// are reported once per rejection streak; other failures once they persist
+// for the failure threshold.
+func (r *Recorder) DeliveryFailed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.consecutiveFailures++
+
+	if errors.Is(err, api.ErrUnauthorized) {
+		if !r.tokenRejected {
+			r.tokenRejected = true
+			r.podEvent(v1.EventTypeWarning, ReasonTokenRejected, "Elchi API rejected the agent token: %v", err)
+		}
+		return
+	}
+
+	if !r.failing && r.consecutiveFailures >= r.failureThreshold {
+		r.failing = true
+		r.podEvent(v1.EventTypeWarning, ReasonDeliveryFailing,
+			"%d consecutive deliveries to the Elchi API failed: %v", r.consecutiveFailures, err)
+	}
+}
+
+// DeliverySucceeded resets the failure streak, recording a recovery event if
+// a DeliveryFailing event had been recorded
+func (r *Recorder) DeliverySucceeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failing {
+		r.podEvent(v1.EventTypeNormal, ReasonDeliveryRecovered,
+			"Delivery to the Elchi API recovered after %d failed attempts", r.consecutiveFailures)
+	}
+
+	r.consecutiveFailures = 0
+	r.failing = false
+	r.tokenRejected = false
+}
+
+// ConfigReloadFailed records a rejected configuration change
+func (r *Recorder) ConfigReloadFailed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.podEvent(v1.EventTypeWarning, ReasonConfigReloadFailed, "Configuration change rejected: %v", err)
+}
+
+// SnapshotHeld records that a snapshot was refused after a sudden node drop
+func (r *Recorder) SnapshotHeld(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.podEvent(v1.EventTypeWarning, ReasonSnapshotHeld, "Holding the last good snapshot: %s", reason)
+}
+
+// SnapshotReleased records the end of a hold
+func (r *Recorder) SnapshotReleased(message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.podEvent(v1.EventTypeNormal, ReasonSnapshotReleased, "%s", message)
+}
+
+// ObserveNodes records an event for every node whose readiness differs from
+// the last one announced. The first observation only seeds the state. A node
+// that transitions again within the node event interval is not re-announced
+// until the interval has passed, so the last state reached is always announced.
+func (r *Recorder) ObserveNodes(nodes []discovery.NodeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seed := r.nodeReady == nil
+	current := make(map[string]bool, len(nodes))
+
+	for _, node := range nodes {
+		ready := node.Status == "Ready"
+		current[node.Name] = ready
+
+		previous, known := r.nodeReady[node.Name]
+		if seed || !known || previous == ready {
+			continue
+		}
+
+		now := r.now()
+		if last, ok := r.lastNodeEvent[node.Name]; ok && now.Sub(last) < r.nodeEventInterval {
+			// Keep the announced state so the transition is announced later
+			current[node.Name] = previous
+			continue
+		}
+		r.lastNodeEvent[node.Name] = now
+
+		ref := &v1.ObjectReference{
+			Kind:       "Node",
+			APIVersion: "v1",
+			Name:       node.Name,
+			UID:        types.UID(node.UID),
+		}
+		if ready {
+			r.recorder.Event(ref, v1.EventTypeNormal, ReasonReportedReady, "Node reported to Elchi as Ready")
+		} else {
+			r.recorder.Event(ref, v1.EventTypeWarning, ReasonReportedNotReady,
+				fmt.Sprintf("Node reported to Elchi as %s", node.Status))
+		}
+	}
+
+	// Forget rate limit state of nodes that left the cluster
+	for name := range r.lastNodeEvent {
+		if _, ok := current[name]; !ok {
+			delete(r.lastNodeEvent, name)
+		}
+	}
+	r.nodeReady = current
+}
+
+func (r *Recorder) podEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	if r.pod == nil {
+		return
+	}
+	r.recorder.Eventf(r.pod, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,174 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func podRef() *v1.ObjectReference {
+	return &v1.ObjectReference{Kind: "Pod", Namespace: "elchi", Name: "elchi-discovery-0"}
+}
+
+// drain returns every event recorded so far
+func drain(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestDeliveryEvents(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := NewRecorder(fakeRecorder, podRef(), WithFailureThreshold(3))
+
+	sendErr := errors.New("API returned non-success status: 503")
+	recorder.DeliveryFailed(sendErr)
+	recorder.DeliveryFailed(sendErr)
+	if events := drain(fakeRecorder); len(events) != 0 {
+		t.Fatalf("Expected no events below threshold, got %v", events)
+	}
+
+	recorder.DeliveryFailed(sendErr)
+	recorder.DeliveryFailed(sendErr)
+	events := drain(fakeRecorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning DeliveryFailing 3 consecutive") {
+		t.Fatalf("Expected a single DeliveryFailing event, got %v", events)
+	}
+
+	recorder.DeliverySucceeded()
+	events = drain(fakeRecorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Normal DeliveryRecovered") {
+		t.Fatalf("Expected DeliveryRecovered event, got %v", events)
+	}
+
+	recorder.DeliverySucceeded()
+	if events := drain(fakeRecorder); len(events) != 0 {
+		t.Errorf("Expected no event for a healthy delivery, got %v", events)
+	}
+}
+
+func TestTokenRejectedEvent(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := NewRecorder(fakeRecorder, podRef())
+
+	rejected := fmt.Errorf("%w (HTTP 401): invalid token", api.ErrUnauthorized)
+	recorder.DeliveryFailed(rejected)
+	recorder.DeliveryFailed(rejected)
+
+	events := drain(fakeRecorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning TokenRejected") {
+		t.Errorf("Expected one TokenRejected event, got %v", events)
+	}
+}
+
+func TestPodEventsWithoutPod(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := NewRecorder(fakeRecorder, nil, WithFailureThreshold(1))
+
+	recorder.DeliveryFailed(errors.New("boom"))
+	recorder.ConfigReloadFailed(errors.New("invalid interval"))
+
+	if events := drain(fakeRecorder); len(events) != 0 {
+		t.Errorf("Expected pod events to be dropped without a pod reference, got %v", events)
+	}
+}
+
+func TestObserveNodes(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := NewRecorder(fakeRecorder, podRef(), WithNodeEventInterval(time.Minute))
+
+	now := time.Date(2025, 8, 7, 12, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+
+	nodes := func(status string) []discovery.NodeInfo {
+		return []discovery.NodeInfo{
+			{Name: "node1", Status: status},
+			{Name: "node2", Status: "Ready"},
+		}
+	}
+
+	// Initial observation only seeds the state
+	recorder.ObserveNodes(nodes("Ready"))
+	if events := drain(fakeRecorder); len(events) != 0 {
+		t.Fatalf("Expected no events on first observation, got %v", events)
+	}
+
+	recorder.ObserveNodes(nodes("NotReady"))
+	events := drain(fakeRecorder)
+	if len(events) != 1 || events[0] != "Warning ReportedNotReady Node reported to Elchi as NotReady" {
+		t.Fatalf("Expected ReportedNotReady event, got %v", events)
+	}
+
+	// Flapping back within the interval is suppressed
+	now = now.Add(10 * time.Second)
+	recorder.ObserveNodes(nodes("Ready"))
+	if events := drain(fakeRecorder); len(events) != 0 {
+		t.Fatalf("Expected flapping transition to be rate limited, got %v", events)
+	}
+
+	now = now.Add(time.Minute)
+	recorder.ObserveNodes(nodes("Ready"))
+	events = drain(fakeRecorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Normal ReportedReady") {
+		t.Fatalf("Expected the suppressed transition after interval elapsed, got %v", events)
+	}
+
+	now = now.Add(time.Minute)
+	recorder.ObserveNodes(nodes("NotReady"))
+	events = drain(fakeRecorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning ReportedNotReady") {
+		t.Fatalf("Expected event after interval elapsed, got %v", events)
+	}
+
+	// Unknown is not Ready, so staying Unknown after NotReady is not a transition
+	now = now.Add(time.Hour)
+	recorder.ObserveNodes(nodes("Unknown"))
+	if events := drain(fakeRecorder); len(events) != 0 {
+		t.Errorf("Expected no event for NotReady to Unknown, got %v", events)
+	}
+}
+
+func TestObserveNodes_FlapAndRecover(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := NewRecorder(fakeRecorder, podRef(), WithNodeEventInterval(time.Minute))
+
+	now := time.Date(2025, 8, 7, 12, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+	observe := func(status string, after time.Duration) []string {
+		now = now.Add(after)
+		recorder.ObserveNodes([]discovery.NodeInfo{{Name: "node1", Status: status}})
+		return drain(fakeRecorder)
+	}
+
+	observe("Ready", 0)
+	if events := observe("NotReady", 0); len(events) != 1 {
+		t.Fatalf("Expected ReportedNotReady event, got %v", events)
+	}
+	// Recovery within the interval is held back, not dropped
+	if events := observe("Ready", 10*time.Second); len(events) != 0 {
+		t.Fatalf("Expected recovery within the interval to be held back, got %v", events)
+	}
+	if events := observe("Ready", 10*time.Second); len(events) != 0 {
+		t.Fatalf("Expected recovery within the interval to be held back, got %v", events)
+	}
+	events := observe("Ready", time.Minute)
+	if len(events) != 1 || events[0] != "Normal ReportedReady Node reported to Elchi as Ready" {
+		t.Fatalf("Expected the recovery to be announced once the interval passed, got %v", events)
+	}
+	if events := observe("Ready", time.Hour); len(events) != 0 {
+		t.Errorf("Expected the recovery to be announced once, got %v", events)
+	}
+}
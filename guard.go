@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AnnotationAcceptSnapshot on the agent's own Pod lets a held snapshot
+// through without the API, e.g.
+//
+//	kubectl annotate pod <agent> elchi.io/accept-snapshot="$(date +%s)" --overwrite
+//
+// Every new value accepts the snapshot held at that time.
+const AnnotationAcceptSnapshot = "elchi.io/accept-snapshot"
+
+// Sources of a guard override
+const (
+	overrideDirective  = "API override"
+	overrideAnnotation = "Pod annotation " + AnnotationAcceptSnapshot
+)
+
+// snapshotGuard refuses snapshots whose node or Ready count dropped sharply
+// since the last accepted one. A partial node list or a wrong node selector
+// would otherwise tell Elchi the cluster is gone and blackhole its traffic.
+type snapshotGuard struct {
+	// maxNodeDrop and maxReadyDrop are percentages, zero disables the check
+	maxNodeDrop  int
+	maxReadyDrop int
+	// holdFor is how long a drop must persist before it is accepted, zero
+	// holds until overridden
+	holdFor time.Duration
+	now     func() time.Time
+
+	// lastGood is the last snapshot accepted by the API
+	lastGood *discovery.DiscoveryResult
+	// heldSince is set while snapshots are refused
+	heldSince time.Time
+	// override names what lets the next refused snapshot through, empty
+	// while nothing does
+	override string
+
+	// podAnnotation reads AnnotationAcceptSnapshot from the agent's Pod, nil
+	// when the Pod is unknown
+	podAnnotation func(ctx context.Context) (string, error)
+	// acceptedValue is the last annotation value seen, a different one
+	// overrides the hold
+	acceptedValue string
+}
+
+func newSnapshotGuard(maxNodeDrop, maxReadyDrop int, holdFor time.Duration) *snapshotGuard {
+	return &snapshotGuard{
+		maxNodeDrop:  maxNodeDrop,
+		maxReadyDrop: maxReadyDrop,
+		holdFor:      holdFor,
+		now:          time.Now,
+	}
+}
+
+// violation describes how result dropped beyond the thresholds, or returns
+// "" when it may be sent
+func (g *snapshotGuard) violation(result *discovery.DiscoveryResult) string {
+	if g.lastGood == nil {
+		return ""
+	}
+
+	var reasons []string
+	if drop := dropPercent(g.lastGood.NodeCount, result.NodeCount); g.maxNodeDrop > 0 && drop > g.maxNodeDrop {
+		reasons = append(reasons, fmt.Sprintf("node count dropped %d%% from %d to %d", drop, g.lastGood.NodeCount, result.NodeCount))
+	}
+	if drop := dropPercent(g.lastGood.ReadyCount(), result.ReadyCount()); g.maxReadyDrop > 0 && drop > g.maxReadyDrop {
+		reasons = append(reasons, fmt.Sprintf("Ready count dropped %d%% from %d to %d", drop, g.lastGood.ReadyCount(), result.ReadyCount()))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// holding reports whether snapshots are currently refused
+func (g *snapshotGuard) holding() bool {
+	return g != nil && !g.heldSince.IsZero()
+}
+
+// accept records result as the last snapshot accepted by the API
+func (g *snapshotGuard) accept(result *discovery.DiscoveryResult) {
+	if g != nil && result.Hold == nil && result.Stale == nil {
+		g.lastGood = result
+	}
+}
+
+// release ends a hold. A drop let through becomes the new baseline even if
+// its delivery fails, so it is not held a second time.
+func (g *snapshotGuard) release(result *discovery.DiscoveryResult) {
+	g.heldSince = time.Time{}
+	g.override = ""
+	g.lastGood = result
+}
+
+// held returns a copy of the last good snapshot marked as held in place of result
+func (g *snapshotGuard) held(result *discovery.DiscoveryResult, reason string) *discovery.DiscoveryResult {
+	held := *g.lastGood
+	held.Hold = &discovery.Hold{
+		Since:      g.heldSince,
+		Reason:     reason,
+		NodeCount:  result.NodeCount,
+		ReadyCount: result.ReadyCount(),
+	}
+	return &held
+}
+
+// watchPodAnnotation lets AnnotationAcceptSnapshot on the agent's Pod
+// override holds. The current value is taken as seen so an annotation left
+// from an earlier hold does not release the next one.
+func (g *snapshotGuard) watchPodAnnotation(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	g.podAnnotation = func(ctx context.Context) (string, error) {
+		pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return pod.Annotations[AnnotationAcceptSnapshot], nil
+	}
+
+	value, err := g.podAnnotation(ctx)
+	g.acceptedValue = value
+	return err
+}
+
+// annotationOverride reports whether the Pod annotation changed since it was
+// last seen. The Pod is only read while holding.
+func (g *snapshotGuard) annotationOverride(ctx context.Context) (bool, error) {
+	if g.podAnnotation == nil {
+		return false, nil
+	}
+	value, err := g.podAnnotation(ctx)
+	if err != nil || value == "" || value == g.acceptedValue {
+		return false, err
+	}
+	g.acceptedValue = value
+	return true, nil
+}
+
+// dropPercent is the percentage lost going from previous to current, rounded down
+func dropPercent(previous, current int) int {
+	if previous <= 0 || current >= previous {
+		return 0
+	}
+	return (previous - current) * 100 / previous
+}
+
+// guardSnapshot returns the snapshot to send for result: result itself, or
+// the last good snapshot marked as held while a sudden drop is refused. The
+// error is set while holding so the cycle reports degraded health.
+func (a *agent) guardSnapshot(ctx context.Context, result *discovery.DiscoveryResult) (*discovery.DiscoveryResult, error) {
+	g := a.guard
+	if g == nil {
+		return result, nil
+	}
+
+	reason := g.violation(result)
+	if reason == "" {
+		if g.holding() {
+			a.log.WithField("held_for", g.now().Sub(g.heldSince).Round(time.Second).String()).Info("Node count recovered, releasing held snapshot")
+			if a.events != nil {
+				a.events.SnapshotReleased("Node count recovered, sending current snapshots again")
+			}
+			g.release(result)
+		}
+		return result, nil
+	}
+
+	fields := map[string]interface{}{
+		"reason":           reason,
+		"node_count":       result.NodeCount,
+		"ready_count":      result.ReadyCount(),
+		"last_node_count":  g.lastGood.NodeCount,
+		"last_ready_count": g.lastGood.ReadyCount(),
+	}
+
+	now := g.now()
+	if !g.holding() {
+		g.heldSince = now
+		if a.events != nil {
+			a.events.SnapshotHeld(reason)
+		}
+	}
+	heldFor := now.Sub(g.heldSince)
+	fields["held_for"] = heldFor.Round(time.Second).String()
+
+	if g.override == "" {
+		accepted, err := g.annotationOverride(ctx)
+		if err != nil {
+			a.log.WithError(err).Warn("Failed to read the agent Pod for " + AnnotationAcceptSnapshot)
+		} else if accepted {
+			g.override = overrideAnnotation
+		}
+	}
+
+	switch {
+	case g.override != "":
+		fields["accepted_by"] = g.override
+		a.log.WithFields(fields).Warn("Sending snapshot after node drop, accepted by override")
+		if a.events != nil {
+			a.events.SnapshotReleased(fmt.Sprintf("Node drop accepted by %s: %s", g.override, reason))
+		}
+		g.release(result)
+		return result, nil
+
+	case g.holdFor > 0 && heldFor >= g.holdFor:
+		a.log.WithFields(fields).Warn("Node drop persisted for the hold duration, sending current snapshot")
+		if a.events != nil {
+			a.events.SnapshotReleased(fmt.Sprintf("Node drop persisted for %s, accepted: %s", g.holdFor, reason))
+		}
+		g.release(result)
+		return result, nil
+	}
+
+	a.log.WithFields(fields).Error("Refusing snapshot after sudden node drop, resending last good snapshot")
+	return g.held(result, reason), fmt.Errorf("snapshot held: %s", reason)
+}
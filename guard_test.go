@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/events"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDropPercent(t *testing.T) {
+	tests := []struct {
+		previous, current, expected int
+	}{
+		{10, 10, 0},
+		{10, 12, 0},
+		{10, 5, 50},
+		{3, 2, 33},
+		{10, 0, 100},
+		{0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if drop := dropPercent(tt.previous, tt.current); drop != tt.expected {
+			t.Errorf("Expected drop from %d to %d of %d%%, got %d%%", tt.previous, tt.current, tt.expected, drop)
+		}
+	}
+}
+
+// newGuardAgent returns an agent with a guard over a cluster of four Ready
+// nodes, sending to mock
+func newGuardAgent(t *testing.T, mock *mockapi.Server, holdFor time.Duration) (*agent, *fake.Clientset) {
+	t.Helper()
+
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	client := fake.NewSimpleClientset()
+	for i := 1; i <= 4; i++ {
+		client.Tracker().Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)},
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			}},
+		})
+	}
+
+	cfg := &config.Config{
+		ClusterName: "test-cluster",
+		Elchi: config.ElchiConfig{
+			APIEndpoint: server.URL,
+			Token:       "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa",
+		},
+	}
+	log := logger.New(&logger.Config{Level: "fatal"})
+
+	a := newAgent(log, discovery.NewService(client, cfg.ClusterName), newAPIClient(cfg, log))
+	a.guard = newSnapshotGuard(50, 50, holdFor)
+	a.remote = &api.DirectiveBounds{MinInterval: time.Second, MaxInterval: time.Hour, MaxPause: time.Hour}
+	return a, client
+}
+
+// lastPayload decodes the most recent request received by mock
+func lastPayload(t *testing.T, mock *mockapi.Server) api.DiscoveryPayload {
+	t.Helper()
+
+	requests := mock.Requests()
+	var payload api.DiscoveryPayload
+	if err := requests[len(requests)-1].Decode(&payload); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return payload
+}
+
+func deleteNodes(t *testing.T, client *fake.Clientset, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := client.CoreV1().Nodes().Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestGuardHoldsSnapshotUntilOverride(t *testing.T) {
+	mock := mockapi.New()
+	a, client := newGuardAgent(t, mock, 0)
+	fakeRecorder := record.NewFakeRecorder(10)
+	a.events = events.NewRecorder(fakeRecorder, &v1.ObjectReference{Kind: "Pod", Namespace: "elchi", Name: "agent"})
+
+	a.runDiscovery(context.Background())
+	deleteNodes(t, client, "node-2", "node-3", "node-4")
+
+	// Held snapshots keep being sent until the API overrides the guard
+	for i := 0; i < 2; i++ {
+		a.runDiscovery(context.Background())
+
+		payload := lastPayload(t, mock)
+		if payload.Data.NodeCount != 4 || payload.Data.Hold == nil {
+			t.Fatalf("Expected the held 4 node snapshot, got %d nodes (hold %+v)", payload.Data.NodeCount, payload.Data.Hold)
+		}
+		if payload.Data.Hold.NodeCount != 1 || !strings.Contains(payload.Data.Hold.Reason, "node count dropped 75%") {
+			t.Errorf("Expected hold describing the refused snapshot, got %+v", payload.Data.Hold)
+		}
+		if a.lastError == nil || !strings.Contains(a.lastError.Error(), "snapshot held") {
+			t.Errorf("Expected the hold to be reported as error, got %v", a.lastError)
+		}
+	}
+
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.HasPrefix(event, "Warning "+events.ReasonSnapshotHeld) {
+			t.Errorf("Expected SnapshotHeld event, got %s", event)
+		}
+	default:
+		t.Error("Expected SnapshotHeld event")
+	}
+
+	mock.SetResult(json.RawMessage(`{"directives": {"id": "d-1", "accept_snapshot": true}}`))
+	a.runDiscovery(context.Background())
+	a.runDiscovery(context.Background())
+
+	payload := lastPayload(t, mock)
+	if payload.Data.NodeCount != 1 || payload.Data.Hold != nil {
+		t.Errorf("Expected the overridden 1 node snapshot, got %d nodes (hold %+v)", payload.Data.NodeCount, payload.Data.Hold)
+	}
+	if a.guard.holding() {
+		t.Error("Expected the hold to end after the override")
+	}
+}
+
+func TestGuardStatusReportsHeldSnapshot(t *testing.T) {
+	mock := mockapi.New()
+	a, client := newGuardAgent(t, mock, 0)
+
+	a.runDiscovery(context.Background())
+	deleteNodes(t, client, "node-2", "node-3", "node-4")
+	a.runDiscovery(context.Background())
+
+	// The status describes the held snapshot Elchi has, not the refused one
+	report := a.statusReport()
+	if report.NodeCount != 4 || report.ReadyNodeCount != 4 {
+		t.Errorf("Expected the held 4 node snapshot in the status, got %d nodes (%d ready)", report.NodeCount, report.ReadyNodeCount)
+	}
+	if report.Fingerprint == "" || report.Fingerprint != a.lastSentFingerprint || report.Fingerprint == a.lastResult.Fingerprint() {
+		t.Errorf("Expected the fingerprint of the held snapshot, got %q", report.Fingerprint)
+	}
+}
+
+func TestGuardAcceptsPodAnnotation(t *testing.T) {
+	mock := mockapi.New()
+	a, client := newGuardAgent(t, mock, 0)
+
+	// A value left from an earlier hold does not release the next one
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "agent",
+		Namespace:   "elchi",
+		Annotations: map[string]string{AnnotationAcceptSnapshot: "earlier"},
+	}}
+	client.Tracker().Add(pod)
+	if err := a.guard.watchPodAnnotation(context.Background(), client, "elchi", "agent"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a.runDiscovery(context.Background())
+	deleteNodes(t, client, "node-2", "node-3", "node-4")
+	a.runDiscovery(context.Background())
+	if payload := lastPayload(t, mock); payload.Data.Hold == nil {
+		t.Fatal("Expected the snapshot to be held")
+	}
+
+	pod.Annotations[AnnotationAcceptSnapshot] = "now"
+	if _, err := client.CoreV1().Pods("elchi").Update(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	a.runDiscovery(context.Background())
+	if payload := lastPayload(t, mock); payload.Data.Hold != nil || payload.Data.NodeCount != 1 {
+		t.Errorf("Expected the annotated 1 node snapshot, got %d nodes (hold %+v)", payload.Data.NodeCount, payload.Data.Hold)
+	}
+	if a.guard.holding() {
+		t.Error("Expected the hold to end after the annotation")
+	}
+}
+
+func TestGuardReleasesPersistentDrop(t *testing.T) {
+	mock := mockapi.New()
+	a, client := newGuardAgent(t, mock, 10*time.Minute)
+
+	now := time.Now()
+	a.guard.now = func() time.Time { return now }
+
+	a.runDiscovery(context.Background())
+	deleteNodes(t, client, "node-3", "node-4", "node-2")
+
+	a.runDiscovery(context.Background())
+	if payload := lastPayload(t, mock); payload.Data.Hold == nil {
+		t.Fatal("Expected the snapshot to be held")
+	}
+
+	now = now.Add(10 * time.Minute)
+	a.runDiscovery(context.Background())
+	if payload := lastPayload(t, mock); payload.Data.Hold != nil || payload.Data.NodeCount != 1 {
+		t.Errorf("Expected the persistent drop to be sent, got %d nodes (hold %+v)", payload.Data.NodeCount, payload.Data.Hold)
+	}
+
+	// The accepted drop is the new baseline
+	a.runDiscovery(context.Background())
+	if a.guard.holding() || lastPayload(t, mock).Data.Hold != nil {
+		t.Error("Expected no new hold after the drop was accepted")
+	}
+}
+
+func TestGuardReleasesOnRecovery(t *testing.T) {
+	mock := mockapi.New()
+	a, client := newGuardAgent(t, mock, 0)
+
+	a.runDiscovery(context.Background())
+	node, _ := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	deleteNodes(t, client, "node-1", "node-2", "node-3")
+
+	a.runDiscovery(context.Background())
+	if !a.guard.holding() {
+		t.Fatal("Expected the snapshot to be held")
+	}
+
+	// Losing a quarter of the nodes is within the threshold
+	client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+	deleteNodes(t, client, "node-4")
+	client.Tracker().Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-5"}, Status: v1.NodeStatus{
+		Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+	}})
+	client.Tracker().Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-6"}, Status: v1.NodeStatus{
+		Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+	}})
+
+	a.runDiscovery(context.Background())
+	if a.guard.holding() {
+		t.Error("Expected the hold to end once the node count recovered")
+	}
+	if payload := lastPayload(t, mock); payload.Data.Hold != nil || payload.Data.NodeCount != 3 {
+		t.Errorf("Expected the current 3 node snapshot, got %d nodes (hold %+v)", payload.Data.NodeCount, payload.Data.Hold)
+	}
+}
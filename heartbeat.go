@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// Version is set at build time
+var Version = "dev"
+
+// newAgentIdentity describes this agent process in heartbeats
+func newAgentIdentity() api.AgentIdentity {
+	hostname, _ := os.Hostname()
+	return api.AgentIdentity{
+		InstanceID: string(uuid.NewUUID()),
+		Version:    Version,
+		PodName:    os.Getenv("POD_NAME"),
+		Namespace:  os.Getenv("POD_NAMESPACE"),
+		Hostname:   hostname,
+	}
+}
+
+// updateHeartbeat stores the state reported by the next heartbeats. It is
+// called at the end of every discovery cycle.
+func (a *agent) updateHeartbeat(cycleErr error) {
+	heartbeat := &api.Heartbeat{
+		Agent:          a.identity,
+		Fingerprint:    a.lastSentFingerprint,
+		Health:         api.HealthHealthy,
+		ActiveEndpoint: a.client.ActiveEndpoint(),
+	}
+	if result := a.lastResult; result != nil {
+		heartbeat.ClusterName = result.ClusterInfo.Name
+		heartbeat.ClusterID = result.ClusterInfo.ID
+	}
+	if !a.lastSend.IsZero() {
+		lastSend := a.lastSend
+		heartbeat.LastSnapshotTime = &lastSend
+	}
+	if cycleErr != nil {
+		heartbeat.Health = api.HealthDegraded
+		heartbeat.LastError = cycleErr.Error()
+	}
+	a.heartbeat.Store(heartbeat)
+}
+
+// runHeartbeat sends a heartbeat on every tick until ctx is cancelled. Nothing
+// is sent before the first discovery cycle has completed.
+func (a *agent) runHeartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.sendHeartbeat(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *agent) sendHeartbeat(ctx context.Context) {
+	current := a.heartbeat.Load()
+	if current == nil {
+		return
+	}
+
+	heartbeat := *current
+	heartbeat.Timestamp = time.Now()
+	if err := a.client.SendHeartbeat(ctx, &heartbeat); err != nil {
+		a.log.WithError(err).Warn("Failed to send heartbeat to API")
+	}
+}
+
+// snapshotUnchanged reports whether sending result can be skipped because
+// heartbeats already tell the API the cluster is unchanged. The snapshot is
+// still sent until the handshake completes, while a directive ack is pending
+// and at least once per full snapshot interval.
+func (a *agent) snapshotUnchanged(fingerprint string) bool {
+	return a.unchangedFor(a.client, fingerprint, a.lastSentFingerprint, a.lastSend)
+}
+
+// unchangedFor applies the snapshotUnchanged rules to client, which last
+// accepted a snapshot with lastFingerprint at lastSend
+func (a *agent) unchangedFor(client *api.Client, fingerprint, lastFingerprint string, lastSend time.Time) bool {
+	if a.fullSnapshotInterval <= 0 {
+		return false
+	}
+	if !client.HandshakeCompleted() || client.HasPendingAck() {
+		return false
+	}
+	return fingerprint == lastFingerprint && time.Since(lastSend) < a.fullSnapshotInterval
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+)
+
+func TestAgentSkipsUnchangedSnapshots(t *testing.T) {
+	server, received := directiveServer(t, nil)
+
+	agent := newDirectiveAgent(server.URL)
+	agent.fullSnapshotInterval = time.Hour
+
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+	if len(*received) != 1 {
+		t.Fatalf("Expected unchanged snapshot to be skipped, got %d requests", len(*received))
+	}
+
+	// The snapshot is resent once the full snapshot interval has elapsed
+	agent.lastSend = time.Now().Add(-2 * time.Hour)
+	agent.runDiscovery(context.Background())
+	if len(*received) != 2 {
+		t.Fatalf("Expected snapshot after full snapshot interval, got %d requests", len(*received))
+	}
+
+	// And whenever the cluster changed
+	agent.lastSentFingerprint = "stale"
+	agent.runDiscovery(context.Background())
+	if len(*received) != 3 {
+		t.Fatalf("Expected snapshot after change, got %d requests", len(*received))
+	}
+}
+
+func TestAgentSendsEverySnapshotWithoutHeartbeat(t *testing.T) {
+	server, received := directiveServer(t, nil)
+
+	agent := newDirectiveAgent(server.URL)
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+
+	if len(*received) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(*received))
+	}
+}
+
+func TestAgentSendHeartbeat(t *testing.T) {
+	var heartbeats []api.Heartbeat
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/heartbeat" {
+			var heartbeat api.Heartbeat
+			json.NewDecoder(r.Body).Decode(&heartbeat)
+			heartbeats = append(heartbeats, heartbeat)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	}))
+	defer server.Close()
+
+	agent := newDirectiveAgent(server.URL)
+	agent.client = api.NewClient(
+		api.WithEndpoint(server.URL+"/discovery"),
+		api.WithToken("96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"),
+		api.WithHeartbeatPath("/heartbeat"),
+		api.WithLogger(agent.log),
+	)
+
+	// Nothing is reported before the first cycle
+	agent.sendHeartbeat(context.Background())
+	if len(heartbeats) != 0 {
+		t.Fatalf("Expected no heartbeat before first cycle, got %d", len(heartbeats))
+	}
+
+	agent.runDiscovery(context.Background())
+	agent.sendHeartbeat(context.Background())
+	if len(heartbeats) != 1 {
+		t.Fatalf("Expected 1 heartbeat, got %d", len(heartbeats))
+	}
+
+	heartbeat := heartbeats[0]
+	if heartbeat.Health != api.HealthHealthy {
+		t.Errorf("Expected health %q, got %q", api.HealthHealthy, heartbeat.Health)
+	}
+	if heartbeat.Fingerprint == "" || heartbeat.Fingerprint != agent.lastSentFingerprint {
+		t.Errorf("Expected fingerprint %q, got %q", agent.lastSentFingerprint, heartbeat.Fingerprint)
+	}
+	if heartbeat.LastSnapshotTime == nil {
+		t.Error("Expected last snapshot time to be set")
+	}
+	if heartbeat.Agent.InstanceID == "" || heartbeat.Agent.Version != Version {
+		t.Errorf("Unexpected agent identity: %+v", heartbeat.Agent)
+	}
+	if heartbeat.ClusterName != "test-cluster" {
+		t.Errorf("Expected cluster name test-cluster, got %q", heartbeat.ClusterName)
+	}
+}
+
+func TestAgentHeartbeatReportsDegraded(t *testing.T) {
+	agent := newDirectiveAgent("http://127.0.0.1:0")
+	agent.updateHeartbeat(errors.New("boom"))
+
+	heartbeat := agent.heartbeat.Load()
+	if heartbeat.Health != api.HealthDegraded || heartbeat.LastError != "boom" {
+		t.Errorf("Expected degraded heartbeat with error, got %+v", heartbeat)
+	}
+	if heartbeat.Fingerprint != "" || heartbeat.LastSnapshotTime != nil {
+		t.Errorf("Expected no snapshot state, got %+v", heartbeat)
+	}
+}
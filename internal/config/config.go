@@ -4,14 +4,101 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// HeartbeatConfig controls the liveness requests sent between snapshots
+type HeartbeatConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is resolved against api_endpoint; an absolute path replaces its path
+	Path string `yaml:"path"`
+	// Interval between heartbeats in seconds
+	Interval int `yaml:"interval"`
+}
+
+// CompressionConfig controls request body compression
+type CompressionConfig struct {
+	// Algorithm is none, gzip or zstd
+	Algorithm string `yaml:"algorithm"`
+	// Threshold is the body size in bytes below which nothing is compressed
+	Threshold int `yaml:"threshold"`
+}
+
+// SigningConfig holds the shared secret used to sign requests
+type SigningConfig struct {
+	Secret string `yaml:"secret"`
+	// SecretFile is read at startup and takes precedence over Secret
+	SecretFile string `yaml:"secret_file"`
+}
+
+// ProxyConfig selects the outbound proxy for API requests. Empty fields fall
+// back to HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+type ProxyConfig struct {
+	URL      string `yaml:"url"`
+	NoProxy  string `yaml:"no_proxy"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// TransportConfig tunes API connections; durations are in seconds and zero
+// means no limit
+type TransportConfig struct {
+	// Timeout bounds a whole request including the response body
+	Timeout               int `yaml:"timeout"`
+	DialTimeout           int `yaml:"dial_timeout"`
+	KeepAlive             int `yaml:"keep_alive"`
+	TLSHandshakeTimeout   int `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int `yaml:"response_header_timeout"`
+	IdleConnTimeout       int `yaml:"idle_conn_timeout"`
+	MaxIdleConns          int `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int `yaml:"max_conns_per_host"`
+}
+
+// FailoverConfig picks between several API endpoints
+type FailoverConfig struct {
+	// Policy is failover or round_robin
+	Policy string `yaml:"policy"`
+	// Backoff in seconds a failed endpoint is skipped, doubling with every
+	// consecutive failure up to MaxBackoff
+	Backoff    int `yaml:"backoff"`
+	MaxBackoff int `yaml:"max_backoff"`
+}
+
+// ProjectConfig maps an Elchi project token to the part of the cluster the
+// project owns. Empty selectors select everything.
+type ProjectConfig struct {
+	Token             string `yaml:"token"`
+	NodeSelector      string `yaml:"node_selector"`
+	NamespaceSelector string `yaml:"namespace_selector"`
+	ServiceSelector   string `yaml:"service_selector"`
+}
+
 type ElchiConfig struct {
-	Token              string `yaml:"token"`
+	Token string `yaml:"token"`
+	// TokenExpiryWarning is how many seconds before a JWT token expires the
+	// agent starts warning, zero disables the warning
+	TokenExpiryWarning int    `yaml:"token_expiry_warning"`
 	APIEndpoint        string `yaml:"api_endpoint"`
-	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// APIEndpoints replaces APIEndpoint when set, the first one is the primary
+	APIEndpoints       []string        `yaml:"api_endpoints"`
+	Failover           FailoverConfig  `yaml:"failover"`
+	InsecureSkipVerify bool            `yaml:"insecure_skip_verify"`
+	Heartbeat          HeartbeatConfig `yaml:"heartbeat"`
+	// Projects replaces Token when set; each project gets its own client
+	Projects []ProjectConfig `yaml:"projects"`
+	// FullSnapshotInterval is how often, in seconds, an unchanged snapshot is
+	// resent while heartbeats are enabled
+	FullSnapshotInterval int               `yaml:"full_snapshot_interval"`
+	Compression          CompressionConfig `yaml:"compression"`
+	// MaxPayloadSize in bytes after compression; larger payloads are sent in
+	// ordered parts. Zero disables splitting.
+	MaxPayloadSize int             `yaml:"max_payload_size"`
+	Signing        SigningConfig   `yaml:"signing"`
+	Proxy          ProxyConfig     `yaml:"proxy"`
+	Transport      TransportConfig `yaml:"transport"`
 }
 
 type LogConfig struct {
@@ -20,11 +107,162 @@ type LogConfig struct {
 	Output string `yaml:"output"`
 }
 
+// RoleRuleConfig maps a node label or taint to a role name
+type RoleRuleConfig struct {
+	Role  string `yaml:"role"`
+	Label string `yaml:"label"`
+	Value string `yaml:"value"`
+	Taint string `yaml:"taint"`
+}
+
+// StatusDampingConfig delays reporting node status changes. A change is
+// reported once it lasted the given seconds or observations, whichever comes
+// first; zero disables a criterion.
+type StatusDampingConfig struct {
+	Enabled              bool `yaml:"enabled"`
+	NotReadyAfter        int  `yaml:"not_ready_after"`
+	NotReadyObservations int  `yaml:"not_ready_observations"`
+	ReadyAfter           int  `yaml:"ready_after"`
+	ReadyObservations    int  `yaml:"ready_observations"`
+}
+
+type DiscoveryConfig struct {
+	// PageSize is the number of nodes requested per list call (0 disables pagination)
+	PageSize int64 `yaml:"page_size"`
+	// ConsistentRead forces list calls to hit etcd instead of the watch cache
+	ConsistentRead bool `yaml:"consistent_read"`
+	// AddressPreference orders "Type[/Family]" entries used to pick the primary address
+	AddressPreference []string `yaml:"address_preference"`
+	// LegacyAddressMap keeps the type-keyed "addresses" map in the payload
+	LegacyAddressMap bool `yaml:"legacy_address_map"`
+	// RoleRules add roles on top of the node-role.kubernetes.io/<role> labels
+	RoleRules []RoleRuleConfig `yaml:"role_rules"`
+	// ClusterIDFile persists the cluster ID pinned for the cluster name across restarts
+	ClusterIDFile string `yaml:"cluster_id_file"`
+	// ClusterIDPolicy is "warn" or "fail" when the cluster ID for the name changes
+	ClusterIDPolicy string `yaml:"cluster_id_policy"`
+	// ClusterInfoRefresh is how many seconds the cluster ID, API server
+	// endpoints and CIDRs are reused before they are read again (0: every cycle)
+	ClusterInfoRefresh int `yaml:"cluster_info_refresh"`
+	// NodeSelector is a label selector restricting the discovered nodes
+	NodeSelector string `yaml:"node_selector"`
+	// NodeLeases watches kube-node-lease to report node heartbeats
+	NodeLeases bool `yaml:"node_leases"`
+	// LeaseStaleAfter is how many seconds without a lease renewal mark a
+	// node's lease stale
+	LeaseStaleAfter int `yaml:"lease_stale_after"`
+	// ServeStale resends the last snapshot marked as stale when discovery fails
+	ServeStale bool `yaml:"serve_stale"`
+	// StatusDamping keeps flapping nodes from changing the reported status
+	StatusDamping StatusDampingConfig `yaml:"status_damping"`
+}
+
+// StatusConfig controls publishing of the agent status into the cluster
+type StatusConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Kind is "configmap" or "custom-resource"
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+	// Namespace defaults to the agent's own namespace (POD_NAMESPACE)
+	Namespace string `yaml:"namespace"`
+}
+
+// EventsConfig controls Kubernetes Events recorded by the agent
+type EventsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// FailureThreshold is the number of consecutive failed deliveries reported as an event
+	FailureThreshold int `yaml:"failure_threshold"`
+	// NodeEventInterval is the minimum number of seconds between events for the same node
+	NodeEventInterval int `yaml:"node_event_interval"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address is the listen address of the /metrics endpoint
+	Address string `yaml:"address"`
+}
+
+// RemoteConfig bounds the settings the Elchi API may change through directives
+type RemoteConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinInterval and MaxInterval clamp the discovery interval, in seconds
+	MinInterval int `yaml:"min_interval"`
+	MaxInterval int `yaml:"max_interval"`
+	// MaxPause is the longest the API may pause sending, in seconds
+	MaxPause int `yaml:"max_pause"`
+}
+
+// RecordConfig appends every payload to a JSONL file for later replay
+type RecordConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// StateConfig persists the API handshake state across restarts
+type StateConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Kind is "file" or "configmap"
+	Kind string `yaml:"kind"`
+	// Path of the state file
+	Path string `yaml:"path"`
+	// Name of the state ConfigMap
+	Name string `yaml:"name"`
+	// Namespace defaults to the agent's own namespace (POD_NAMESPACE)
+	Namespace string `yaml:"namespace"`
+}
+
+// ProbeCheckConfig is a health check run against one port of every node
+type ProbeCheckConfig struct {
+	Name string `yaml:"name"`
+	// Type is tcp, http or grpc
+	Type string `yaml:"type"`
+	Port int    `yaml:"port"`
+	// Path is requested by http checks
+	Path string `yaml:"path"`
+	// Service is the gRPC health service name
+	Service string `yaml:"service"`
+}
+
+// ProbesConfig controls active health checks of the discovered nodes
+type ProbesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Concurrency bounds the number of probes running at once
+	Concurrency int `yaml:"concurrency"`
+	// Timeout of a single probe in seconds
+	Timeout int `yaml:"timeout"`
+	// Interval is the number of seconds between probe rounds, which also
+	// bounds every round
+	Interval int                `yaml:"interval"`
+	Checks   []ProbeCheckConfig `yaml:"checks"`
+}
+
+// GuardConfig refuses snapshots after a sudden drop in nodes
+type GuardConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxNodeDropPercent and MaxReadyDropPercent are the largest drops from
+	// the last accepted snapshot that are sent; zero disables a check
+	MaxNodeDropPercent  int `yaml:"max_node_drop_percent"`
+	MaxReadyDropPercent int `yaml:"max_ready_drop_percent"`
+	// HoldDuration is how many seconds a drop must persist before it is sent
+	// anyway; zero holds until the API sends accept_snapshot
+	HoldDuration int `yaml:"hold_duration"`
+}
+
 type Config struct {
-	Elchi             ElchiConfig `yaml:"elchi"`
-	Log               LogConfig   `yaml:"log"`
-	DiscoveryInterval int         `yaml:"discovery_interval"`
-	ClusterName       string      `yaml:"cluster_name"`
+	Elchi             ElchiConfig     `yaml:"elchi"`
+	Log               LogConfig       `yaml:"log"`
+	Discovery         DiscoveryConfig `yaml:"discovery"`
+	Status            StatusConfig    `yaml:"status"`
+	Events            EventsConfig    `yaml:"events"`
+	Metrics           MetricsConfig   `yaml:"metrics"`
+	RemoteConfig      RemoteConfig    `yaml:"remote_config"`
+	Record            RecordConfig    `yaml:"record"`
+	State             StateConfig     `yaml:"state"`
+	Probes            ProbesConfig    `yaml:"probes"`
+	Guard             GuardConfig     `yaml:"guard"`
+	DiscoveryInterval int             `yaml:"discovery_interval"`
+	ClusterName       string          `yaml:"cluster_name"`
 }
 
 func Load() (*Config, error) {
@@ -39,8 +277,99 @@ func Load() (*Config, error) {
 		},
 		Elchi: ElchiConfig{
 			Token:              "",
+			TokenExpiryWarning: 86400,
 			APIEndpoint:        "",
+			Failover: FailoverConfig{
+				Policy:     "failover",
+				Backoff:    5,
+				MaxBackoff: 300,
+			},
 			InsecureSkipVerify: false,
+			Heartbeat: HeartbeatConfig{
+				Enabled:  false,
+				Path:     "/heartbeat",
+				Interval: 10,
+			},
+			FullSnapshotInterval: 600,
+			Compression: CompressionConfig{
+				Algorithm: "none",
+				Threshold: 16384,
+			},
+			MaxPayloadSize: 0,
+			Transport: TransportConfig{
+				Timeout:               15,
+				DialTimeout:           10,
+				KeepAlive:             30,
+				TLSHandshakeTimeout:   10,
+				ResponseHeaderTimeout: 15,
+				IdleConnTimeout:       90,
+				MaxIdleConns:          10,
+				MaxIdleConnsPerHost:   2,
+				MaxConnsPerHost:       0,
+			},
+		},
+		Discovery: DiscoveryConfig{
+			PageSize:           500,
+			ConsistentRead:     false,
+			AddressPreference:  []string{"InternalIP/IPv4", "InternalIP/IPv6", "ExternalIP/IPv4", "ExternalIP/IPv6"},
+			LegacyAddressMap:   true,
+			ClusterIDFile:      "",
+			ClusterIDPolicy:    "warn",
+			ClusterInfoRefresh: 600,
+			NodeLeases:         false,
+			LeaseStaleAfter:    40,
+			ServeStale:         true,
+			StatusDamping: StatusDampingConfig{
+				Enabled:              false,
+				NotReadyAfter:        30,
+				NotReadyObservations: 3,
+				ReadyAfter:           60,
+				ReadyObservations:    3,
+			},
+		},
+		Status: StatusConfig{
+			Enabled:   false,
+			Kind:      "configmap",
+			Name:      "elchi-discovery-status",
+			Namespace: "",
+		},
+		Events: EventsConfig{
+			Enabled:           false,
+			FailureThreshold:  3,
+			NodeEventInterval: 300,
+		},
+		Metrics: MetricsConfig{
+			Enabled: false,
+			Address: ":9090",
+		},
+		RemoteConfig: RemoteConfig{
+			Enabled:     false,
+			MinInterval: 10,
+			MaxInterval: 3600,
+			MaxPause:    3600,
+		},
+		Record: RecordConfig{
+			Enabled: false,
+			Path:    "elchi-discovery-record.jsonl",
+		},
+		State: StateConfig{
+			Enabled:   false,
+			Kind:      "file",
+			Path:      "elchi-discovery-state.json",
+			Name:      "elchi-discovery-state",
+			Namespace: "",
+		},
+		Probes: ProbesConfig{
+			Enabled:     false,
+			Concurrency: 10,
+			Timeout:     2,
+			Interval:    30,
+		},
+		Guard: GuardConfig{
+			Enabled:             false,
+			MaxNodeDropPercent:  50,
+			MaxReadyDropPercent: 50,
+			HoldDuration:        900,
 		},
 	}
 
@@ -89,11 +418,93 @@ func applyEnvironmentVariables(config *Config) {
 		config.Elchi.APIEndpoint = val
 	}
 
+	config.Elchi.TokenExpiryWarning = getEnvOrDefaultInt("ELCHI_TOKEN_EXPIRY_WARNING", config.Elchi.TokenExpiryWarning)
+	config.Elchi.APIEndpoints = getEnvOrDefaultList("ELCHI_API_ENDPOINTS", config.Elchi.APIEndpoints)
+	config.Elchi.Failover.Policy = getEnvOrDefault("ELCHI_ENDPOINT_POLICY", config.Elchi.Failover.Policy)
+	config.Elchi.Failover.Backoff = getEnvOrDefaultInt("ELCHI_ENDPOINT_BACKOFF", config.Elchi.Failover.Backoff)
+	config.Elchi.Failover.MaxBackoff = getEnvOrDefaultInt("ELCHI_ENDPOINT_MAX_BACKOFF", config.Elchi.Failover.MaxBackoff)
+
 	if val := os.Getenv("ELCHI_INSECURE_SKIP_VERIFY"); val != "" {
 		if boolVal, err := strconv.ParseBool(val); err == nil {
 			config.Elchi.InsecureSkipVerify = boolVal
 		}
 	}
+
+	config.Elchi.Heartbeat.Enabled = getEnvOrDefaultBool("ELCHI_HEARTBEAT_ENABLED", config.Elchi.Heartbeat.Enabled)
+	config.Elchi.Heartbeat.Path = getEnvOrDefault("ELCHI_HEARTBEAT_PATH", config.Elchi.Heartbeat.Path)
+	config.Elchi.Heartbeat.Interval = getEnvOrDefaultInt("ELCHI_HEARTBEAT_INTERVAL", config.Elchi.Heartbeat.Interval)
+	config.Elchi.FullSnapshotInterval = getEnvOrDefaultInt("ELCHI_FULL_SNAPSHOT_INTERVAL", config.Elchi.FullSnapshotInterval)
+	config.Elchi.Compression.Algorithm = getEnvOrDefault("ELCHI_COMPRESSION", config.Elchi.Compression.Algorithm)
+	config.Elchi.Compression.Threshold = getEnvOrDefaultInt("ELCHI_COMPRESSION_THRESHOLD", config.Elchi.Compression.Threshold)
+	config.Elchi.MaxPayloadSize = getEnvOrDefaultInt("ELCHI_MAX_PAYLOAD_SIZE", config.Elchi.MaxPayloadSize)
+	config.Elchi.Signing.Secret = getEnvOrDefault("ELCHI_SIGNING_SECRET", config.Elchi.Signing.Secret)
+	config.Elchi.Signing.SecretFile = getEnvOrDefault("ELCHI_SIGNING_SECRET_FILE", config.Elchi.Signing.SecretFile)
+	config.Elchi.Proxy.URL = getEnvOrDefault("ELCHI_PROXY_URL", config.Elchi.Proxy.URL)
+	config.Elchi.Proxy.NoProxy = getEnvOrDefault("ELCHI_NO_PROXY", config.Elchi.Proxy.NoProxy)
+	config.Elchi.Proxy.Username = getEnvOrDefault("ELCHI_PROXY_USERNAME", config.Elchi.Proxy.Username)
+	config.Elchi.Proxy.Password = getEnvOrDefault("ELCHI_PROXY_PASSWORD", config.Elchi.Proxy.Password)
+	config.Elchi.Transport.Timeout = getEnvOrDefaultInt("ELCHI_TIMEOUT", config.Elchi.Transport.Timeout)
+	config.Elchi.Transport.DialTimeout = getEnvOrDefaultInt("ELCHI_DIAL_TIMEOUT", config.Elchi.Transport.DialTimeout)
+	config.Elchi.Transport.KeepAlive = getEnvOrDefaultInt("ELCHI_KEEP_ALIVE", config.Elchi.Transport.KeepAlive)
+	config.Elchi.Transport.TLSHandshakeTimeout = getEnvOrDefaultInt("ELCHI_TLS_HANDSHAKE_TIMEOUT", config.Elchi.Transport.TLSHandshakeTimeout)
+	config.Elchi.Transport.ResponseHeaderTimeout = getEnvOrDefaultInt("ELCHI_RESPONSE_HEADER_TIMEOUT", config.Elchi.Transport.ResponseHeaderTimeout)
+	config.Elchi.Transport.IdleConnTimeout = getEnvOrDefaultInt("ELCHI_IDLE_CONN_TIMEOUT", config.Elchi.Transport.IdleConnTimeout)
+	config.Elchi.Transport.MaxIdleConns = getEnvOrDefaultInt("ELCHI_MAX_IDLE_CONNS", config.Elchi.Transport.MaxIdleConns)
+	config.Elchi.Transport.MaxIdleConnsPerHost = getEnvOrDefaultInt("ELCHI_MAX_IDLE_CONNS_PER_HOST", config.Elchi.Transport.MaxIdleConnsPerHost)
+	config.Elchi.Transport.MaxConnsPerHost = getEnvOrDefaultInt("ELCHI_MAX_CONNS_PER_HOST", config.Elchi.Transport.MaxConnsPerHost)
+
+	config.Discovery.PageSize = int64(getEnvOrDefaultInt("DISCOVERY_PAGE_SIZE", int(config.Discovery.PageSize)))
+	config.Discovery.ConsistentRead = getEnvOrDefaultBool("DISCOVERY_CONSISTENT_READ", config.Discovery.ConsistentRead)
+	config.Discovery.AddressPreference = getEnvOrDefaultList("DISCOVERY_ADDRESS_PREFERENCE", config.Discovery.AddressPreference)
+	config.Discovery.LegacyAddressMap = getEnvOrDefaultBool("DISCOVERY_LEGACY_ADDRESS_MAP", config.Discovery.LegacyAddressMap)
+	config.Discovery.ClusterIDFile = getEnvOrDefault("DISCOVERY_CLUSTER_ID_FILE", config.Discovery.ClusterIDFile)
+	config.Discovery.ClusterIDPolicy = getEnvOrDefault("DISCOVERY_CLUSTER_ID_POLICY", config.Discovery.ClusterIDPolicy)
+	config.Discovery.ClusterInfoRefresh = getEnvOrDefaultInt("DISCOVERY_CLUSTER_INFO_REFRESH", config.Discovery.ClusterInfoRefresh)
+	config.Discovery.NodeSelector = getEnvOrDefault("DISCOVERY_NODE_SELECTOR", config.Discovery.NodeSelector)
+	config.Discovery.NodeLeases = getEnvOrDefaultBool("DISCOVERY_NODE_LEASES", config.Discovery.NodeLeases)
+	config.Discovery.LeaseStaleAfter = getEnvOrDefaultInt("DISCOVERY_LEASE_STALE_AFTER", config.Discovery.LeaseStaleAfter)
+	config.Discovery.ServeStale = getEnvOrDefaultBool("DISCOVERY_SERVE_STALE", config.Discovery.ServeStale)
+	config.Discovery.StatusDamping.Enabled = getEnvOrDefaultBool("DISCOVERY_DAMPING_ENABLED", config.Discovery.StatusDamping.Enabled)
+	config.Discovery.StatusDamping.NotReadyAfter = getEnvOrDefaultInt("DISCOVERY_DAMPING_NOT_READY_AFTER", config.Discovery.StatusDamping.NotReadyAfter)
+	config.Discovery.StatusDamping.NotReadyObservations = getEnvOrDefaultInt("DISCOVERY_DAMPING_NOT_READY_OBSERVATIONS", config.Discovery.StatusDamping.NotReadyObservations)
+	config.Discovery.StatusDamping.ReadyAfter = getEnvOrDefaultInt("DISCOVERY_DAMPING_READY_AFTER", config.Discovery.StatusDamping.ReadyAfter)
+	config.Discovery.StatusDamping.ReadyObservations = getEnvOrDefaultInt("DISCOVERY_DAMPING_READY_OBSERVATIONS", config.Discovery.StatusDamping.ReadyObservations)
+
+	config.Status.Enabled = getEnvOrDefaultBool("STATUS_ENABLED", config.Status.Enabled)
+	config.Status.Kind = getEnvOrDefault("STATUS_KIND", config.Status.Kind)
+	config.Status.Name = getEnvOrDefault("STATUS_NAME", config.Status.Name)
+	config.Status.Namespace = getEnvOrDefault("STATUS_NAMESPACE", config.Status.Namespace)
+
+	config.Events.Enabled = getEnvOrDefaultBool("EVENTS_ENABLED", config.Events.Enabled)
+	config.Events.FailureThreshold = getEnvOrDefaultInt("EVENTS_FAILURE_THRESHOLD", config.Events.FailureThreshold)
+	config.Events.NodeEventInterval = getEnvOrDefaultInt("EVENTS_NODE_EVENT_INTERVAL", config.Events.NodeEventInterval)
+
+	config.Metrics.Enabled = getEnvOrDefaultBool("METRICS_ENABLED", config.Metrics.Enabled)
+	config.Metrics.Address = getEnvOrDefault("METRICS_ADDRESS", config.Metrics.Address)
+
+	config.RemoteConfig.Enabled = getEnvOrDefaultBool("REMOTE_CONFIG_ENABLED", config.RemoteConfig.Enabled)
+	config.RemoteConfig.MinInterval = getEnvOrDefaultInt("REMOTE_CONFIG_MIN_INTERVAL", config.RemoteConfig.MinInterval)
+	config.RemoteConfig.MaxInterval = getEnvOrDefaultInt("REMOTE_CONFIG_MAX_INTERVAL", config.RemoteConfig.MaxInterval)
+	config.RemoteConfig.MaxPause = getEnvOrDefaultInt("REMOTE_CONFIG_MAX_PAUSE", config.RemoteConfig.MaxPause)
+
+	config.Record.Enabled = getEnvOrDefaultBool("RECORD_ENABLED", config.Record.Enabled)
+	config.Record.Path = getEnvOrDefault("RECORD_PATH", config.Record.Path)
+
+	config.State.Enabled = getEnvOrDefaultBool("STATE_ENABLED", config.State.Enabled)
+	config.State.Kind = getEnvOrDefault("STATE_KIND", config.State.Kind)
+	config.State.Path = getEnvOrDefault("STATE_PATH", config.State.Path)
+	config.State.Name = getEnvOrDefault("STATE_NAME", config.State.Name)
+	config.State.Namespace = getEnvOrDefault("STATE_NAMESPACE", config.State.Namespace)
+
+	config.Probes.Enabled = getEnvOrDefaultBool("PROBES_ENABLED", config.Probes.Enabled)
+	config.Probes.Concurrency = getEnvOrDefaultInt("PROBES_CONCURRENCY", config.Probes.Concurrency)
+	config.Probes.Timeout = getEnvOrDefaultInt("PROBES_TIMEOUT", config.Probes.Timeout)
+	config.Probes.Interval = getEnvOrDefaultInt("PROBES_INTERVAL", config.Probes.Interval)
+
+	config.Guard.Enabled = getEnvOrDefaultBool("GUARD_ENABLED", config.Guard.Enabled)
+	config.Guard.MaxNodeDropPercent = getEnvOrDefaultInt("GUARD_MAX_NODE_DROP_PERCENT", config.Guard.MaxNodeDropPercent)
+	config.Guard.MaxReadyDropPercent = getEnvOrDefaultInt("GUARD_MAX_READY_DROP_PERCENT", config.Guard.MaxReadyDropPercent)
+	config.Guard.HoldDuration = getEnvOrDefaultInt("GUARD_HOLD_DURATION", config.Guard.HoldDuration)
 }
 
 func getConfigPath() string {
@@ -148,3 +559,19 @@ func getEnvOrDefaultBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvOrDefaultList reads a comma separated list, ignoring empty entries
+func getEnvOrDefaultList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -37,9 +38,130 @@ func TestLoad_DefaultValues(t *testing.T) {
 	if cfg.Elchi.APIEndpoint != "" {
 		t.Errorf("Expected Elchi.APIEndpoint = '', got %s", cfg.Elchi.APIEndpoint)
 	}
+	if cfg.Elchi.TokenExpiryWarning != 86400 {
+		t.Errorf("Expected Elchi.TokenExpiryWarning = 86400, got %d", cfg.Elchi.TokenExpiryWarning)
+	}
+	if len(cfg.Elchi.APIEndpoints) != 0 {
+		t.Errorf("Expected Elchi.APIEndpoints to be empty, got %v", cfg.Elchi.APIEndpoints)
+	}
+	if len(cfg.Elchi.Projects) != 0 {
+		t.Errorf("Expected Elchi.Projects to be empty, got %v", cfg.Elchi.Projects)
+	}
+	if cfg.Elchi.Failover.Policy != "failover" {
+		t.Errorf("Expected Elchi.Failover.Policy = 'failover', got %s", cfg.Elchi.Failover.Policy)
+	}
+	if cfg.Elchi.Failover.Backoff != 5 || cfg.Elchi.Failover.MaxBackoff != 300 {
+		t.Errorf("Expected Elchi.Failover backoff 5/300, got %d/%d", cfg.Elchi.Failover.Backoff, cfg.Elchi.Failover.MaxBackoff)
+	}
 	if cfg.Elchi.InsecureSkipVerify {
 		t.Error("Expected Elchi.InsecureSkipVerify = false, got true")
 	}
+	if cfg.Discovery.PageSize != 500 {
+		t.Errorf("Expected Discovery.PageSize = 500, got %d", cfg.Discovery.PageSize)
+	}
+	if cfg.Discovery.ConsistentRead {
+		t.Error("Expected Discovery.ConsistentRead = false, got true")
+	}
+	if len(cfg.Discovery.AddressPreference) != 4 || cfg.Discovery.AddressPreference[0] != "InternalIP/IPv4" {
+		t.Errorf("Expected default Discovery.AddressPreference, got %v", cfg.Discovery.AddressPreference)
+	}
+	if !cfg.Discovery.LegacyAddressMap {
+		t.Error("Expected Discovery.LegacyAddressMap = true, got false")
+	}
+	if cfg.Discovery.ClusterIDPolicy != "warn" {
+		t.Errorf("Expected Discovery.ClusterIDPolicy = 'warn', got %s", cfg.Discovery.ClusterIDPolicy)
+	}
+	if cfg.Discovery.ClusterInfoRefresh != 600 {
+		t.Errorf("Expected Discovery.ClusterInfoRefresh = 600, got %d", cfg.Discovery.ClusterInfoRefresh)
+	}
+	if cfg.Discovery.NodeLeases || cfg.Discovery.LeaseStaleAfter != 40 {
+		t.Errorf("Expected node leases disabled with 40s stale threshold, got %v and %d", cfg.Discovery.NodeLeases, cfg.Discovery.LeaseStaleAfter)
+	}
+	if !cfg.Discovery.ServeStale {
+		t.Error("Expected Discovery.ServeStale = true, got false")
+	}
+	if damping := cfg.Discovery.StatusDamping; damping.Enabled || damping.NotReadyAfter != 30 || damping.NotReadyObservations != 3 ||
+		damping.ReadyAfter != 60 || damping.ReadyObservations != 3 {
+		t.Errorf("Expected status damping disabled with 30s/3 and 60s/3 thresholds, got %+v", damping)
+	}
+	if cfg.Status.Enabled {
+		t.Error("Expected Status.Enabled = false, got true")
+	}
+	if cfg.Status.Kind != "configmap" {
+		t.Errorf("Expected Status.Kind = 'configmap', got %s", cfg.Status.Kind)
+	}
+	if cfg.Status.Name != "elchi-discovery-status" {
+		t.Errorf("Expected Status.Name = 'elchi-discovery-status', got %s", cfg.Status.Name)
+	}
+	if cfg.Events.Enabled {
+		t.Error("Expected Events.Enabled = false, got true")
+	}
+	if cfg.Events.FailureThreshold != 3 {
+		t.Errorf("Expected Events.FailureThreshold = 3, got %d", cfg.Events.FailureThreshold)
+	}
+	if cfg.Events.NodeEventInterval != 300 {
+		t.Errorf("Expected Events.NodeEventInterval = 300, got %d", cfg.Events.NodeEventInterval)
+	}
+	if cfg.Metrics.Enabled {
+		t.Error("Expected Metrics.Enabled = false, got true")
+	}
+	if cfg.Metrics.Address != ":9090" {
+		t.Errorf("Expected Metrics.Address = ':9090', got %s", cfg.Metrics.Address)
+	}
+	if cfg.RemoteConfig.Enabled {
+		t.Error("Expected RemoteConfig.Enabled = false, got true")
+	}
+	if cfg.RemoteConfig.MinInterval != 10 || cfg.RemoteConfig.MaxInterval != 3600 || cfg.RemoteConfig.MaxPause != 3600 {
+		t.Errorf("Unexpected RemoteConfig bounds: %+v", cfg.RemoteConfig)
+	}
+	if cfg.Elchi.Heartbeat.Enabled {
+		t.Error("Expected Elchi.Heartbeat.Enabled = false, got true")
+	}
+	if cfg.Elchi.Heartbeat.Path != "/heartbeat" || cfg.Elchi.Heartbeat.Interval != 10 {
+		t.Errorf("Unexpected Elchi.Heartbeat defaults: %+v", cfg.Elchi.Heartbeat)
+	}
+	if cfg.Elchi.FullSnapshotInterval != 600 {
+		t.Errorf("Expected Elchi.FullSnapshotInterval = 600, got %d", cfg.Elchi.FullSnapshotInterval)
+	}
+	if cfg.Elchi.Compression.Algorithm != "none" || cfg.Elchi.Compression.Threshold != 16384 {
+		t.Errorf("Unexpected Elchi.Compression defaults: %+v", cfg.Elchi.Compression)
+	}
+	if cfg.Elchi.MaxPayloadSize != 0 {
+		t.Errorf("Expected Elchi.MaxPayloadSize = 0, got %d", cfg.Elchi.MaxPayloadSize)
+	}
+	if cfg.Elchi.Signing.Secret != "" || cfg.Elchi.Signing.SecretFile != "" {
+		t.Errorf("Expected signing to be disabled by default, got %+v", cfg.Elchi.Signing)
+	}
+	if cfg.Elchi.Proxy != (ProxyConfig{}) {
+		t.Errorf("Expected no proxy by default, got %+v", cfg.Elchi.Proxy)
+	}
+	expectedTransport := TransportConfig{
+		Timeout:               15,
+		DialTimeout:           10,
+		KeepAlive:             30,
+		TLSHandshakeTimeout:   10,
+		ResponseHeaderTimeout: 15,
+		IdleConnTimeout:       90,
+		MaxIdleConns:          10,
+		MaxIdleConnsPerHost:   2,
+	}
+	if cfg.Elchi.Transport != expectedTransport {
+		t.Errorf("Expected Elchi.Transport = %+v, got %+v", expectedTransport, cfg.Elchi.Transport)
+	}
+	if cfg.Record.Enabled || cfg.Record.Path != "elchi-discovery-record.jsonl" {
+		t.Errorf("Unexpected Record defaults: %+v", cfg.Record)
+	}
+	expectedState := StateConfig{Kind: "file", Path: "elchi-discovery-state.json", Name: "elchi-discovery-state"}
+	if cfg.State != expectedState {
+		t.Errorf("Expected State = %+v, got %+v", expectedState, cfg.State)
+	}
+	if cfg.Probes.Enabled || cfg.Probes.Concurrency != 10 || cfg.Probes.Timeout != 2 || cfg.Probes.Interval != 30 || len(cfg.Probes.Checks) != 0 {
+		t.Errorf("Unexpected Probes defaults: %+v", cfg.Probes)
+	}
+	expectedGuard := GuardConfig{MaxNodeDropPercent: 50, MaxReadyDropPercent: 50, HoldDuration: 900}
+	if cfg.Guard != expectedGuard {
+		t.Errorf("Expected Guard = %+v, got %+v", expectedGuard, cfg.Guard)
+	}
 }
 
 func TestLoad_EnvironmentVariables(t *testing.T) {
@@ -55,6 +177,8 @@ func TestLoad_EnvironmentVariables(t *testing.T) {
 	os.Setenv("ELCHI_TOKEN", "test-token")
 	os.Setenv("ELCHI_API_ENDPOINT", "https://api.example.com")
 	os.Setenv("ELCHI_INSECURE_SKIP_VERIFY", "true")
+	os.Setenv("DISCOVERY_PAGE_SIZE", "100")
+	os.Setenv("DISCOVERY_CONSISTENT_READ", "true")
 
 	defer clearEnvVars()
 
@@ -88,6 +212,12 @@ func TestLoad_EnvironmentVariables(t *testing.T) {
 	if !cfg.Elchi.InsecureSkipVerify {
 		t.Error("Expected Elchi.InsecureSkipVerify = true, got false")
 	}
+	if cfg.Discovery.PageSize != 100 {
+		t.Errorf("Expected Discovery.PageSize = 100, got %d", cfg.Discovery.PageSize)
+	}
+	if !cfg.Discovery.ConsistentRead {
+		t.Error("Expected Discovery.ConsistentRead = true, got false")
+	}
 }
 
 func TestLoad_ConfigFile(t *testing.T) {
@@ -109,6 +239,17 @@ elchi:
   token: file-token
   api_endpoint: https://file-api.example.com
   insecure_skip_verify: true
+discovery:
+  role_rules:
+    - role: ingress
+      label: example.com/ingress
+probes:
+  enabled: true
+  checks:
+    - name: ingress
+      type: http
+      port: 8080
+      path: /healthz
 `
 
 	err := os.WriteFile(configPath, []byte(configContent), 0644)
@@ -138,6 +279,16 @@ elchi:
 	if cfg.Elchi.Token != "file-token" {
 		t.Errorf("Expected Elchi.Token = 'file-token', got %s", cfg.Elchi.Token)
 	}
+	if len(cfg.Discovery.RoleRules) != 1 || cfg.Discovery.RoleRules[0].Role != "ingress" {
+		t.Errorf("Expected one ingress role rule, got %+v", cfg.Discovery.RoleRules)
+	}
+	if cfg.Discovery.PageSize != 500 {
+		t.Errorf("Expected Discovery.PageSize default to survive partial section, got %d", cfg.Discovery.PageSize)
+	}
+	expectedCheck := ProbeCheckConfig{Name: "ingress", Type: "http", Port: 8080, Path: "/healthz"}
+	if !cfg.Probes.Enabled || len(cfg.Probes.Checks) != 1 || cfg.Probes.Checks[0] != expectedCheck || cfg.Probes.Concurrency != 10 {
+		t.Errorf("Expected one ingress probe check with default concurrency, got %+v", cfg.Probes)
+	}
 }
 
 func TestLoad_EnvironmentOverridesFile(t *testing.T) {
@@ -395,6 +546,46 @@ func TestGetEnvOrDefaultBool(t *testing.T) {
 	}
 }
 
+func TestGetEnvOrDefaultList(t *testing.T) {
+	tests := []struct {
+		name         string
+		envValue     string
+		defaultValue []string
+		expected     []string
+	}{
+		{
+			name:         "env var not set",
+			envValue:     "",
+			defaultValue: []string{"default"},
+			expected:     []string{"default"},
+		},
+		{
+			name:         "comma separated values",
+			envValue:     "ExternalIP/IPv4, InternalIP",
+			defaultValue: []string{"default"},
+			expected:     []string{"ExternalIP/IPv4", "InternalIP"},
+		},
+		{
+			name:         "empty entries are skipped",
+			envValue:     "InternalIP,,",
+			defaultValue: nil,
+			expected:     []string{"InternalIP"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("TEST_LIST", tt.envValue)
+			defer os.Unsetenv("TEST_LIST")
+
+			result := getEnvOrDefaultList("TEST_LIST", tt.defaultValue)
+			if strings.Join(result, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("getEnvOrDefaultList() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
 // Helper function to clear all relevant environment variables
 func clearEnvVars() {
 	envVars := []string{
@@ -405,8 +596,79 @@ func clearEnvVars() {
 		"LOG_OUTPUT",
 		"ELCHI_TOKEN",
 		"ELCHI_API_ENDPOINT",
+		"ELCHI_API_ENDPOINTS",
+		"ELCHI_TOKEN_EXPIRY_WARNING",
+		"ELCHI_ENDPOINT_POLICY",
+		"ELCHI_ENDPOINT_BACKOFF",
+		"ELCHI_ENDPOINT_MAX_BACKOFF",
 		"ELCHI_INSECURE_SKIP_VERIFY",
 		"ELCHI_CONFIG",
+		"DISCOVERY_PAGE_SIZE",
+		"DISCOVERY_CONSISTENT_READ",
+		"DISCOVERY_ADDRESS_PREFERENCE",
+		"DISCOVERY_LEGACY_ADDRESS_MAP",
+		"DISCOVERY_CLUSTER_ID_FILE",
+		"DISCOVERY_CLUSTER_ID_POLICY",
+		"DISCOVERY_CLUSTER_INFO_REFRESH",
+		"STATUS_ENABLED",
+		"STATUS_KIND",
+		"STATUS_NAME",
+		"STATUS_NAMESPACE",
+		"EVENTS_ENABLED",
+		"EVENTS_FAILURE_THRESHOLD",
+		"EVENTS_NODE_EVENT_INTERVAL",
+		"METRICS_ENABLED",
+		"METRICS_ADDRESS",
+		"DISCOVERY_NODE_SELECTOR",
+		"DISCOVERY_NODE_LEASES",
+		"DISCOVERY_LEASE_STALE_AFTER",
+		"DISCOVERY_SERVE_STALE",
+		"DISCOVERY_DAMPING_ENABLED",
+		"DISCOVERY_DAMPING_NOT_READY_AFTER",
+		"DISCOVERY_DAMPING_NOT_READY_OBSERVATIONS",
+		"DISCOVERY_DAMPING_READY_AFTER",
+		"DISCOVERY_DAMPING_READY_OBSERVATIONS",
+		"REMOTE_CONFIG_ENABLED",
+		"REMOTE_CONFIG_MIN_INTERVAL",
+		"REMOTE_CONFIG_MAX_INTERVAL",
+		"REMOTE_CONFIG_MAX_PAUSE",
+		"ELCHI_HEARTBEAT_ENABLED",
+		"ELCHI_HEARTBEAT_PATH",
+		"ELCHI_HEARTBEAT_INTERVAL",
+		"ELCHI_FULL_SNAPSHOT_INTERVAL",
+		"ELCHI_COMPRESSION",
+		"ELCHI_COMPRESSION_THRESHOLD",
+		"ELCHI_MAX_PAYLOAD_SIZE",
+		"ELCHI_SIGNING_SECRET",
+		"ELCHI_SIGNING_SECRET_FILE",
+		"ELCHI_PROXY_URL",
+		"ELCHI_NO_PROXY",
+		"ELCHI_PROXY_USERNAME",
+		"ELCHI_PROXY_PASSWORD",
+		"ELCHI_TIMEOUT",
+		"ELCHI_DIAL_TIMEOUT",
+		"ELCHI_KEEP_ALIVE",
+		"ELCHI_TLS_HANDSHAKE_TIMEOUT",
+		"ELCHI_RESPONSE_HEADER_TIMEOUT",
+		"ELCHI_IDLE_CONN_TIMEOUT",
+		"ELCHI_MAX_IDLE_CONNS",
+		"ELCHI_MAX_IDLE_CONNS_PER_HOST",
+		"ELCHI_MAX_CONNS_PER_HOST",
+		"RECORD_ENABLED",
+		"RECORD_PATH",
+		"STATE_ENABLED",
+		"STATE_KIND",
+		"STATE_PATH",
+		"STATE_NAME",
+		"STATE_NAMESPACE",
+		"PROBES_ENABLED",
+		"PROBES_CONCURRENCY",
+		"PROBES_TIMEOUT",
+		"PROBES_INTERVAL",
+		"GUARD_ENABLED",
+		"GUARD_MAX_NODE_DROP_PERCENT",
+		"GUARD_MAX_READY_DROP_PERCENT",
+		"GUARD_HOLD_DURATION",
 	}
 
 	for _, envVar := range envVars {
@@ -0,0 +1,131 @@
+// Package signing implements the HMAC request signature shared by the API
+// client and the mock API server.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carrying the signature
+const (
+	HeaderAgentID       = "elchi-agent-id"
+	HeaderTimestamp     = "elchi-timestamp"
+	HeaderNonce         = "elchi-nonce"
+	HeaderSequence      = "elchi-sequence"
+	HeaderContentSHA256 = "elchi-content-sha256"
+	HeaderSignature     = "elchi-signature"
+)
+
+// signaturePrefix versions the signature scheme
+const signaturePrefix = "v1="
+
+// signedHeaders are the request headers covered by the signature besides the
+// signature headers themselves: the body encoding, the handshake state and the
+// position of a part in a chunked payload. A missing header is signed as empty.
+var signedHeaders = []string{
+	"Content-Encoding",
+	"initial",
+	"elchi-handshake-epoch",
+	"snapshot-id",
+	"part",
+	"parts",
+}
+
+// Errors returned by Verify
+var (
+	ErrMissingSignature = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrBodyMismatch     = errors.New("body digest does not match")
+	ErrExpired          = errors.New("request timestamp outside allowed skew")
+)
+
+// Sign adds the signature headers to req for body. The signature covers the
+// method, path, query string, agent ID, timestamp, nonce, sequence, body
+// digest and the signed protocol headers, which must be set before signing.
+func Sign(req *http.Request, body []byte, secret []byte, agentID string, sequence uint64, now time.Time) {
+	digest := sha256.Sum256(body)
+
+	req.Header.Set(HeaderAgentID, agentID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderNonce, newNonce())
+	req.Header.Set(HeaderSequence, strconv.FormatUint(sequence, 10))
+	req.Header.Set(HeaderContentSHA256, hex.EncodeToString(digest[:]))
+	req.Header.Set(HeaderSignature, signaturePrefix+hex.EncodeToString(mac(secret, canonical(req))))
+}
+
+// Verify checks the signature headers of req against body and returns the
+// signed sequence number. Nonce and sequence bookkeeping is left to the caller.
+func Verify(req *http.Request, body []byte, secret []byte, maxSkew time.Duration, now time.Time) (uint64, error) {
+	signature := req.Header.Get(HeaderSignature)
+	if signature == "" {
+		return 0, ErrMissingSignature
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) || !hmac.Equal(expected, mac(secret, canonical(req))) {
+		return 0, ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256(body)
+	if req.Header.Get(HeaderContentSHA256) != hex.EncodeToString(digest[:]) {
+		return 0, ErrBodyMismatch
+	}
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > maxSkew || skew < -maxSkew {
+		return 0, ErrExpired
+	}
+
+	sequence, err := strconv.ParseUint(req.Header.Get(HeaderSequence), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	return sequence, nil
+}
+
+// canonical is the string covered by the signature
+func canonical(req *http.Request) string {
+	// An endpoint without a path is requested as "/"
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	fields := []string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		req.Header.Get(HeaderAgentID),
+		req.Header.Get(HeaderTimestamp),
+		req.Header.Get(HeaderNonce),
+		req.Header.Get(HeaderSequence),
+		req.Header.Get(HeaderContentSHA256),
+	}
+	for _, header := range signedHeaders {
+		fields = append(fields, req.Header.Get(header))
+	}
+	return strings.Join(fields, "\n")
+}
+
+func mac(secret []byte, message string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,101 @@
+package signing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func signedRequest(t *testing.T, body string, now time.Time) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/api/discovery?project=p", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	req.Header.Set("initial", "false")
+	req.Header.Set("part", "1")
+	Sign(req, []byte(body), []byte("secret"), "agent-1", 42, now)
+	return req
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	req := signedRequest(t, `{"project":"p"}`, now)
+
+	for _, header := range []string{HeaderAgentID, HeaderTimestamp, HeaderNonce, HeaderSequence, HeaderContentSHA256, HeaderSignature} {
+		if req.Header.Get(header) == "" {
+			t.Errorf("Expected %s header to be set", header)
+		}
+	}
+
+	sequence, err := Verify(req, []byte(`{"project":"p"}`), []byte("secret"), time.Minute, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sequence != 42 {
+		t.Errorf("Expected sequence 42, got %d", sequence)
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"project":"p"}`)
+
+	tests := []struct {
+		name     string
+		modify   func(req *http.Request) ([]byte, []byte, time.Time)
+		expected error
+	}{
+		{"unsigned", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.Header.Del(HeaderSignature)
+			return body, []byte("secret"), now
+		}, ErrMissingSignature},
+		{"wrong secret", func(req *http.Request) ([]byte, []byte, time.Time) {
+			return body, []byte("other"), now
+		}, ErrInvalidSignature},
+		{"tampered sequence", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.Header.Set(HeaderSequence, "43")
+			return body, []byte("secret"), now
+		}, ErrInvalidSignature},
+		{"tampered path", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.URL.Path = "/other"
+			return body, []byte("secret"), now
+		}, ErrInvalidSignature},
+		{"tampered query", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.URL.RawQuery = "project=other"
+			return body, []byte("secret"), now
+		}, ErrInvalidSignature},
+		{"tampered initial", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.Header.Set("initial", "true")
+			return body, []byte("secret"), now
+		}, ErrInvalidSignature},
+		{"tampered part", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.Header.Set("part", "2")
+			return body, []byte("secret"), now
+		}, ErrInvalidSignature},
+		{"tampered encoding", func(req *http.Request) ([]byte, []byte, time.Time) {
+			req.Header.Set("Content-Encoding", "gzip")
+			return body, []byte("secret"), now
+		}, ErrInvalidSignature},
+		{"tampered body", func(req *http.Request) ([]byte, []byte, time.Time) {
+			return []byte(`{"project":"q"}`), []byte("secret"), now
+		}, ErrBodyMismatch},
+		{"expired", func(req *http.Request) ([]byte, []byte, time.Time) {
+			return body, []byte("secret"), now.Add(10 * time.Minute)
+		}, ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(t, string(body), now)
+			verifyBody, secret, at := tt.modify(req)
+
+			_, err := Verify(req, verifyBody, secret, 5*time.Minute, at)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
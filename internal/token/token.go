@@ -0,0 +1,93 @@
+// Package token reads the Elchi project, and the expiry of short-lived
+// tokens, from an API token. It is shared by the API client and the mock API
+// server.
+//
+// Two formats are accepted: the legacy "uuid--project" string and a JWT
+// whose claims carry the project in a "project" claim and the expiry in the
+// standard "exp" claim. The JWT signature is not checked here; the agent has
+// no key for it and the Elchi API verifies every token it receives.
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Formats of a token
+const (
+	FormatLegacy = "legacy"
+	FormatJWT    = "jwt"
+)
+
+// legacySeparator splits the legacy format into uuid and project
+const legacySeparator = "--"
+
+// ErrInvalidFormat is returned for tokens in neither format
+var ErrInvalidFormat = errors.New("invalid token format: expected 'uuid--project' or a JWT with a project claim")
+
+// Info is what the agent knows about its token without asking the API
+type Info struct {
+	Format  string
+	Project string
+	// ExpiresAt is zero when the token does not expire
+	ExpiresAt time.Time
+}
+
+// claims are the JWT claims read by Parse
+type claims struct {
+	Project string       `json:"project"`
+	Expiry  *json.Number `json:"exp"`
+}
+
+// Parse reads the project and expiry from token
+func Parse(token string) (Info, error) {
+	if info, ok, err := parseJWT(token); ok {
+		return info, err
+	}
+
+	parts := strings.SplitN(token, legacySeparator, 2) // Split only on first occurrence
+	if len(parts) == 2 && parts[1] != "" {
+		return Info{Format: FormatLegacy, Project: parts[1]}, nil
+	}
+	return Info{}, ErrInvalidFormat
+}
+
+// parseJWT reports ok when token is shaped like a JWT, with an error when
+// its claims are unusable
+func parseJWT(token string) (Info, bool, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return Info{}, false, nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[1], "="))
+	if err != nil {
+		return Info{}, false, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var c claims
+	if err := decoder.Decode(&c); err != nil {
+		return Info{}, false, nil
+	}
+
+	info := Info{Format: FormatJWT, Project: c.Project}
+	if info.Project == "" {
+		return info, true, fmt.Errorf("%w: JWT has no project claim", ErrInvalidFormat)
+	}
+	if c.Expiry != nil {
+		exp, err := c.Expiry.Float64()
+		if err != nil || exp <= 0 {
+			return info, true, fmt.Errorf("%w: invalid exp claim %q", ErrInvalidFormat, c.Expiry.String())
+		}
+		seconds, fraction := math.Modf(exp)
+		info.ExpiresAt = time.Unix(int64(seconds), int64(fraction*1e9))
+	}
+	return info, true, nil
+}
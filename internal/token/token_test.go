@@ -0,0 +1,58 @@
+package token
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+// jwt builds an unsigned-looking JWT around claims; Parse does not check
+// signatures
+func jwt(claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return header + "." + payload + ".c2lnbmF0dXJl"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		format    string
+		project   string
+		expiresAt time.Time
+		wantErr   bool
+	}{
+		{"legacy", "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa", FormatLegacy, "683b2148ff7e3ae67d825cfa", time.Time{}, false},
+		{"legacy multiple separators", "uuid--project--extra", FormatLegacy, "project--extra", time.Time{}, false},
+		{"jwt with expiry", jwt(`{"sub":"agent","project":"team-a","exp":1767225600}`), FormatJWT, "team-a", time.Unix(1767225600, 0), false},
+		{"jwt without expiry", jwt(`{"project":"team-b"}`), FormatJWT, "team-b", time.Time{}, false},
+		{"jwt fractional expiry", jwt(`{"project":"team-c","exp":1767225600.5}`), FormatJWT, "team-c", time.Unix(1767225600, 5e8), false},
+		{"jwt without project", jwt(`{"sub":"agent","exp":1767225600}`), "", "", time.Time{}, true},
+		{"jwt invalid expiry", jwt(`{"project":"team-a","exp":"tomorrow"}`), "", "", time.Time{}, true},
+		{"no separator", "96688e4c-6737-4230-9591-6a3332115871", "", "", time.Time{}, true},
+		{"empty", "", "", "", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Parse(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidFormat) {
+					t.Errorf("Expected ErrInvalidFormat, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if info.Format != tt.format || info.Project != tt.project {
+				t.Errorf("Expected %s token for %s, got %+v", tt.format, tt.project, info)
+			}
+			if !info.ExpiresAt.Equal(tt.expiresAt) {
+				t.Errorf("Expected expiry %v, got %v", tt.expiresAt, info.ExpiresAt)
+			}
+		})
+	}
+}
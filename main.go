@@ -1,16 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/CloudNativeWorks/elchi-discovery/api"
 	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/events"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
 	elchiContext "github.com/CloudNativeWorks/elchi-discovery/internal/context"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/metrics"
+	"github.com/CloudNativeWorks/elchi-discovery/prober"
+	"github.com/CloudNativeWorks/elchi-discovery/recording"
+	"github.com/CloudNativeWorks/elchi-discovery/state"
+	"github.com/CloudNativeWorks/elchi-discovery/status"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -48,9 +56,11 @@ func main() {
 	log.Info("Starting elchi-discovery service")
 	log.WithFields(map[string]interface{}{
 		"token_configured":   cfg.Elchi.Token != "",
-		"api_endpoint":       cfg.Elchi.APIEndpoint,
+		"api_endpoints":      apiEndpoints(cfg.Elchi),
+		"endpoint_policy":    cfg.Elchi.Failover.Policy,
 		"discovery_interval": interval.String(),
 		"insecure_tls":       cfg.Elchi.InsecureSkipVerify,
+		"proxy_configured":   cfg.Elchi.Proxy.URL != "",
 	}).Info("Configuration loaded")
 
 	// Create Kubernetes client
@@ -60,70 +70,376 @@ func main() {
 		return
 	}
 
-	// Create discovery service
-	discoveryService := discovery.NewService(clientset, cfg.ClusterName)
-
-	// Create API client
-	apiClient := api.NewClient(cfg, log)
+	addressPreference, err := discovery.ParseAddressPreference(cfg.Discovery.AddressPreference)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid discovery address preference")
+		return
+	}
 
-	// Continuous discovery loop
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	// Run discovery immediately on startup
-	runDiscovery(ctx, log, discoveryService, apiClient)
-
-	// Then run on schedule
-	for {
-		select {
-		case <-ticker.C:
-			runDiscovery(ctx, log, discoveryService, apiClient)
-		case <-ctx.Done():
-			log.Info("Shutdown signal received, stopping discovery")
+	roleRules := make([]discovery.RoleRule, 0, len(cfg.Discovery.RoleRules))
+	for _, rule := range cfg.Discovery.RoleRules {
+		roleRule := discovery.RoleRule{
+			Role:  rule.Role,
+			Label: rule.Label,
+			Value: rule.Value,
+			Taint: rule.Taint,
+		}
+		if err := roleRule.Validate(); err != nil {
+			log.WithError(err).Fatal("Invalid discovery role rule")
 			return
 		}
+		roleRules = append(roleRules, roleRule)
 	}
-}
 
-func runDiscovery(ctx context.Context, log *logger.Logger, discoveryService *discovery.Service, apiClient *api.Client) {
-	// Perform discovery
-	result, err := discoveryService.DiscoverNodes(ctx)
-	if err != nil {
-		log.WithError(err).Error("Failed to discover nodes")
+	switch cfg.Discovery.ClusterIDPolicy {
+	case discovery.IdentityPolicyWarn, discovery.IdentityPolicyFail:
+	default:
+		log.Fatalf("Invalid cluster_id_policy %q: expected %q or %q",
+			cfg.Discovery.ClusterIDPolicy, discovery.IdentityPolicyWarn, discovery.IdentityPolicyFail)
 		return
 	}
 
-	// Get the exact payload that will be sent to API
-	payload, err := apiClient.GetDiscoveryPayload(result)
-	if err != nil {
-		log.WithError(err).Error("Failed to create discovery payload")
+	// Create discovery service
+	discoveryOptions := []discovery.Option{
+		discovery.WithPageSize(cfg.Discovery.PageSize),
+		discovery.WithConsistentRead(cfg.Discovery.ConsistentRead),
+		discovery.WithAddressPreference(addressPreference),
+		discovery.WithLegacyAddresses(cfg.Discovery.LegacyAddressMap),
+		discovery.WithRoleRules(roleRules),
+		discovery.WithClusterIdentity(cfg.Discovery.ClusterIDFile, cfg.Discovery.ClusterIDPolicy),
+		discovery.WithClusterInfoRefresh(seconds(cfg.Discovery.ClusterInfoRefresh)),
+	}
+	if cfg.Discovery.NodeLeases {
+		discoveryOptions = append(discoveryOptions, discovery.WithNodeLeases(seconds(cfg.Discovery.LeaseStaleAfter)))
+	}
+	if damping := cfg.Discovery.StatusDamping; damping.Enabled {
+		discoveryOptions = append(discoveryOptions, discovery.WithStatusDamping(discovery.Damping{
+			NotReadyAfter:        seconds(damping.NotReadyAfter),
+			NotReadyObservations: damping.NotReadyObservations,
+			ReadyAfter:           seconds(damping.ReadyAfter),
+			ReadyObservations:    damping.ReadyObservations,
+		}))
+		log.WithFields(map[string]interface{}{
+			"not_ready_after":        seconds(damping.NotReadyAfter).String(),
+			"not_ready_observations": damping.NotReadyObservations,
+			"ready_after":            seconds(damping.ReadyAfter).String(),
+			"ready_observations":     damping.ReadyObservations,
+		}).Info("Damping node status changes")
+	}
+	discoveryService := discovery.NewService(clientset, cfg.ClusterName, discoveryOptions...)
+
+	if err := discoveryService.SetNodeSelector(cfg.Discovery.NodeSelector); err != nil {
+		log.WithError(err).Fatal("Invalid discovery node selector")
+		return
+	}
+
+	if err := api.ValidateCompression(cfg.Elchi.Compression.Algorithm); err != nil {
+		log.WithError(err).Fatal("Invalid elchi compression")
 		return
 	}
 
-	// Print as pretty JSON to stdout (same as what gets sent to API)
-	jsonOutput, err := json.MarshalIndent(payload, "", "  ")
+	if err := api.ValidateEndpointPolicy(cfg.Elchi.Failover.Policy); err != nil {
+		log.WithError(err).Fatal("Invalid elchi failover policy")
+		return
+	}
+
+	// Create API client
+	if err := apiProxy(cfg.Elchi.Proxy).Validate(); err != nil {
+		log.WithError(err).Fatal("Invalid elchi proxy")
+		return
+	}
+
+	signingSecret, err := loadSigningSecret(cfg.Elchi.Signing)
 	if err != nil {
-		log.WithError(err).Error("Failed to marshal discovery payload to JSON")
+		log.WithError(err).Fatal("Failed to load signing secret")
 		return
 	}
 
-	fmt.Println(string(jsonOutput))
+	var stateStore *state.Store
+	if cfg.State.Enabled {
+		stateStore, err = newStateStore(cfg, clientset)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to create handshake state store")
+			return
+		}
+	}
+
+	// Signed requests are sequenced per agent instance
+	identity := newAgentIdentity()
 
-	// Send to API if configured
-	if err := apiClient.SendDiscoveryResult(result); err != nil {
-		log.WithError(err).Error("Failed to send discovery result to API")
-		// Don't return here - we still want to continue discovery even if API fails
+	// With elchi.projects the first project takes the place of elchi.token
+	var projects []*project
+	for i, projectCfg := range cfg.Elchi.Projects {
+		// Every client numbers its signed requests separately
+		agentID := identity.InstanceID
+		if i > 0 {
+			agentID = fmt.Sprintf("%s/%d", identity.InstanceID, i)
+		}
+		p, err := newProject(cfg, log, projectCfg,
+			api.WithSigningSecret(signingSecret),
+			api.WithAgentID(agentID),
+			api.WithStateStore(stateStore),
+		)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid elchi project")
+			return
+		}
+		projects = append(projects, p)
+	}
+	var apiClient *api.Client
+	if len(projects) > 0 {
+		apiClient = projects[0].client
+	} else {
+		apiClient = newAPIClient(cfg, log,
+			api.WithSigningSecret(signingSecret),
+			api.WithAgentID(identity.InstanceID),
+			api.WithStateStore(stateStore),
+		)
 	}
 
-	log.WithFields(map[string]interface{}{
-		"node_count":      result.NodeCount,
-		"duration":        result.Duration,
-		"cluster_name":    result.ClusterInfo.Name,
-		"cluster_version": result.ClusterInfo.Version,
-	}).Info("Discovery completed")
+	if err := discoveryService.StartLeaseWatch(ctx); err != nil {
+		log.WithError(err).Warn("Node lease watch not ready, heartbeats are reported once leases are listed")
+	} else if cfg.Discovery.NodeLeases {
+		log.WithField("lease_stale_after", seconds(cfg.Discovery.LeaseStaleAfter).String()).Info("Watching node leases")
+	}
+
+	agent := newAgent(log, discoveryService, apiClient)
+	agent.identity = identity
+	agent.serveStale = cfg.Discovery.ServeStale
+
+	if len(projects) > 0 {
+		agent.scope = projects[0].scope
+		agent.projects = projects[1:]
+
+		names := make([]string, 0, len(projects))
+		for _, p := range projects {
+			names = append(names, p.name)
+		}
+		log.WithField("projects", names).Info("Reporting to multiple Elchi projects")
+	}
+
+	if stateStore != nil {
+		clients := []*api.Client{apiClient}
+		for _, p := range agent.projects {
+			clients = append(clients, p.client)
+		}
+		for _, client := range clients {
+			if err := client.RestoreState(ctx); err != nil {
+				log.WithError(err).Warn("Failed to restore handshake state, starting with an initial payload")
+			}
+		}
+		// An unchanged cluster is not resent right after a restart
+		agent.lastSentFingerprint, agent.lastSend = apiClient.LastAcknowledged()
+		for _, p := range agent.projects {
+			p.lastSentFingerprint, p.lastSend = p.client.LastAcknowledged()
+		}
+	}
+
+	// Create status publisher
+	if cfg.Status.Enabled {
+		publisher, err := newStatusPublisher(cfg, clientset)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to create status publisher")
+			return
+		}
+		agent.status = publisher
+	}
+
+	// Create event recorder
+	if cfg.Events.Enabled {
+		eventRecorder, shutdown := events.NewBroadcastRecorder(clientset)
+		defer shutdown()
+
+		podRef := events.PodReference(ctx, clientset, os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME"))
+		if podRef == nil {
+			log.Warn("POD_NAME or POD_NAMESPACE not set, agent events will not be recorded")
+		}
+
+		agent.events = events.NewRecorder(eventRecorder, podRef,
+			events.WithFailureThreshold(cfg.Events.FailureThreshold),
+			events.WithNodeEventInterval(time.Duration(cfg.Events.NodeEventInterval)*time.Second),
+		)
+	}
+
+	if cfg.RemoteConfig.Enabled {
+		agent.remote = &api.DirectiveBounds{
+			MinInterval: time.Duration(cfg.RemoteConfig.MinInterval) * time.Second,
+			MaxInterval: time.Duration(cfg.RemoteConfig.MaxInterval) * time.Second,
+			MaxPause:    time.Duration(cfg.RemoteConfig.MaxPause) * time.Second,
+		}
+	}
+
+	if cfg.Record.Enabled {
+		recorder, err := recording.NewWriter(cfg.Record.Path)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to open recording")
+			return
+		}
+		defer recorder.Close()
+		agent.recorder = recorder
+		log.WithField("path", cfg.Record.Path).Info("Recording discovery payloads")
+	}
+
+	if cfg.Elchi.Heartbeat.Enabled && apiClient.Enabled() {
+		heartbeatInterval := time.Duration(cfg.Elchi.Heartbeat.Interval) * time.Second
+		if heartbeatInterval <= 0 {
+			heartbeatInterval = 10 * time.Second
+		}
+		agent.fullSnapshotInterval = time.Duration(cfg.Elchi.FullSnapshotInterval) * time.Second
+		go agent.runHeartbeat(ctx, heartbeatInterval)
+
+		log.WithFields(map[string]interface{}{
+			"heartbeat_interval":     heartbeatInterval.String(),
+			"full_snapshot_interval": agent.fullSnapshotInterval.String(),
+			"instance_id":            agent.identity.InstanceID,
+		}).Info("Heartbeats enabled")
+	}
+
+	if cfg.Guard.Enabled {
+		agent.guard = newSnapshotGuard(cfg.Guard.MaxNodeDropPercent, cfg.Guard.MaxReadyDropPercent, seconds(cfg.Guard.HoldDuration))
+		if podNamespace, podName := os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME"); podNamespace != "" && podName != "" {
+			if err := agent.guard.watchPodAnnotation(ctx, clientset, podNamespace, podName); err != nil {
+				log.WithError(err).Warn("Failed to read the agent Pod, held snapshots can still be accepted by annotating it once it is readable")
+			}
+		} else if !cfg.RemoteConfig.Enabled {
+			log.Warn("POD_NAME or POD_NAMESPACE not set and remote_config disabled, held snapshots are only released by recovery or after hold_duration")
+		}
+		log.WithFields(map[string]interface{}{
+			"max_node_drop_percent":  cfg.Guard.MaxNodeDropPercent,
+			"max_ready_drop_percent": cfg.Guard.MaxReadyDropPercent,
+			"hold_duration":          seconds(cfg.Guard.HoldDuration).String(),
+		}).Info("Snapshot guard enabled")
+	}
+
+	if cfg.Probes.Enabled {
+		checks := make([]prober.Check, 0, len(cfg.Probes.Checks))
+		for _, checkCfg := range cfg.Probes.Checks {
+			checks = append(checks, prober.Check{
+				Name:    checkCfg.Name,
+				Type:    checkCfg.Type,
+				Port:    checkCfg.Port,
+				Path:    checkCfg.Path,
+				Service: checkCfg.Service,
+			})
+		}
+		if err := prober.ValidateChecks(checks); err != nil {
+			log.WithError(err).Fatal("Invalid probe check")
+			return
+		}
+		if len(checks) == 0 {
+			log.Warn("Probes enabled without checks, nodes will not be probed")
+		}
+
+		agent.prober = prober.New(checks,
+			prober.WithConcurrency(cfg.Probes.Concurrency),
+			prober.WithTimeout(seconds(cfg.Probes.Timeout)),
+			prober.WithInterval(seconds(cfg.Probes.Interval)),
+			prober.WithLogger(log),
+		)
+		go agent.prober.Run(ctx)
+		log.WithFields(map[string]interface{}{
+			"checks":      len(checks),
+			"concurrency": cfg.Probes.Concurrency,
+			"timeout":     seconds(cfg.Probes.Timeout).String(),
+			"interval":    seconds(cfg.Probes.Interval).String(),
+		}).Info("Probing node endpoints")
+	}
+
+	if cfg.Metrics.Enabled {
+		clients := []*api.Client{apiClient}
+		for _, p := range agent.projects {
+			clients = append(clients, p.client)
+		}
+		registry := metrics.NewRegistry()
+		registerEndpointMetrics(registry, clients)
+		go serveMetrics(ctx, log, cfg.Metrics.Address, registry)
+		log.WithField("address", cfg.Metrics.Address).Info("Serving metrics")
+	}
+
+	// Continuous discovery loop
+	agent.interval = interval
+	agent.run(ctx)
+}
+
+// newAPIClient creates the Elchi API client from the elchi config section
+func newAPIClient(cfg *config.Config, log *logger.Logger, opts ...api.Option) *api.Client {
+	return api.NewClient(append([]api.Option{
+		api.WithEndpoints(apiEndpoints(cfg.Elchi)...),
+		api.WithEndpointPolicy(cfg.Elchi.Failover.Policy),
+		api.WithEndpointBackoff(seconds(cfg.Elchi.Failover.Backoff), seconds(cfg.Elchi.Failover.MaxBackoff)),
+		api.WithToken(cfg.Elchi.Token),
+		api.WithTokenExpiryWarning(seconds(cfg.Elchi.TokenExpiryWarning)),
+		api.WithHeartbeatPath(cfg.Elchi.Heartbeat.Path),
+		api.WithInsecureSkipVerify(cfg.Elchi.InsecureSkipVerify),
+		api.WithCompression(cfg.Elchi.Compression.Algorithm, cfg.Elchi.Compression.Threshold),
+		api.WithMaxPayloadSize(cfg.Elchi.MaxPayloadSize),
+		api.WithProxy(apiProxy(cfg.Elchi.Proxy)),
+		api.WithTimeout(seconds(cfg.Elchi.Transport.Timeout)),
+		api.WithTransport(api.TransportConfig{
+			DialTimeout:           seconds(cfg.Elchi.Transport.DialTimeout),
+			KeepAlive:             seconds(cfg.Elchi.Transport.KeepAlive),
+			TLSHandshakeTimeout:   seconds(cfg.Elchi.Transport.TLSHandshakeTimeout),
+			ResponseHeaderTimeout: seconds(cfg.Elchi.Transport.ResponseHeaderTimeout),
+			IdleConnTimeout:       seconds(cfg.Elchi.Transport.IdleConnTimeout),
+			MaxIdleConns:          cfg.Elchi.Transport.MaxIdleConns,
+			MaxIdleConnsPerHost:   cfg.Elchi.Transport.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       cfg.Elchi.Transport.MaxConnsPerHost,
+		}),
+		api.WithLogger(log),
+	}, opts...)...)
+}
+
+// apiEndpoints returns api_endpoints, or api_endpoint when that list is empty
+func apiEndpoints(cfg config.ElchiConfig) []string {
+	if len(cfg.APIEndpoints) > 0 {
+		return cfg.APIEndpoints
+	}
+	return []string{cfg.APIEndpoint}
+}
+
+func apiProxy(cfg config.ProxyConfig) api.ProxyConfig {
+	return api.ProxyConfig{
+		URL:      cfg.URL,
+		NoProxy:  cfg.NoProxy,
+		Username: cfg.Username,
+		Password: cfg.Password,
+	}
+}
+
+func seconds(s int) time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+// loadSigningSecret returns the configured signing secret, nil when signing
+// is disabled
+func loadSigningSecret(cfg config.SigningConfig) ([]byte, error) {
+	if cfg.SecretFile != "" {
+		data, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing secret file: %w", err)
+		}
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("signing secret file %s is empty", cfg.SecretFile)
+		}
+		return secret, nil
+	}
+	if cfg.Secret != "" {
+		return []byte(cfg.Secret), nil
+	}
+	return nil, nil
 }
 
 func getKubernetesClient() (*kubernetes.Clientset, error) {
+	config, err := getRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+func getRESTConfig() (*rest.Config, error) {
 	// This service ONLY runs inside Kubernetes
 	// It discovers nodes of the cluster it's running in
 	config, err := rest.InClusterConfig()
@@ -131,5 +447,50 @@ func getKubernetesClient() (*kubernetes.Clientset, error) {
 		return nil, fmt.Errorf("failed to get in-cluster config: %w. This service must run inside a Kubernetes cluster", err)
 	}
 
-	return kubernetes.NewForConfig(config)
+	return config, nil
+}
+
+func newStateStore(cfg *config.Config, clientset kubernetes.Interface) (*state.Store, error) {
+	switch cfg.State.Kind {
+	case state.KindFile:
+		return state.NewFileStore(cfg.State.Path), nil
+	case state.KindConfigMap:
+		namespace := cfg.State.Namespace
+		if namespace == "" {
+			namespace = os.Getenv("POD_NAMESPACE")
+		}
+		if namespace == "" {
+			return nil, fmt.Errorf("state namespace is required. Please set state.namespace in config or POD_NAMESPACE environment variable")
+		}
+		return state.NewConfigMapStore(clientset, namespace, cfg.State.Name), nil
+	default:
+		return nil, fmt.Errorf("invalid state kind %q: expected %q or %q", cfg.State.Kind, state.KindFile, state.KindConfigMap)
+	}
+}
+
+func newStatusPublisher(cfg *config.Config, clientset kubernetes.Interface) (*status.Publisher, error) {
+	namespace := cfg.Status.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("status namespace is required. Please set status.namespace in config or POD_NAMESPACE environment variable")
+	}
+
+	switch cfg.Status.Kind {
+	case status.KindConfigMap:
+		return status.NewConfigMapPublisher(clientset, namespace, cfg.Status.Name), nil
+	case status.KindCustomResource:
+		restConfig, err := getRESTConfig()
+		if err != nil {
+			return nil, err
+		}
+		dynamicClient, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+		}
+		return status.NewCustomResourcePublisher(dynamicClient, namespace, cfg.Status.Name), nil
+	default:
+		return nil, fmt.Errorf("invalid status kind %q: expected %q or %q", cfg.Status.Kind, status.KindConfigMap, status.KindCustomResource)
+	}
 }
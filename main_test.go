@@ -5,13 +5,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/CloudNativeWorks/elchi-discovery/api"
 	"github.com/CloudNativeWorks/elchi-discovery/discovery"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
 	elchiContext "github.com/CloudNativeWorks/elchi-discovery/internal/context"
 	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -19,11 +20,8 @@ import (
 
 func TestRunDiscovery(t *testing.T) {
 	// Create test server
-	var receivedRequests int
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		receivedRequests++
-		w.WriteHeader(http.StatusOK)
-	}))
+	mock := mockapi.New()
+	server := httptest.NewServer(mock)
 	defer server.Close()
 
 	// Create fake Kubernetes client with test data
@@ -83,15 +81,19 @@ func TestRunDiscovery(t *testing.T) {
 	discoveryService := discovery.NewService(client, cfg.ClusterName)
 
 	// Create API client
-	apiClient := api.NewClient(cfg, log)
+	apiClient := newAPIClient(cfg, log)
 
 	// Run discovery with config in context
 	ctx := elchiContext.WithConfig(context.Background(), cfg)
-	runDiscovery(ctx, log, discoveryService, apiClient)
+	newAgent(log, discoveryService, apiClient).runDiscovery(ctx)
 
 	// Verify that API was called
-	if receivedRequests != 1 {
-		t.Errorf("Expected 1 API request, got %d", receivedRequests)
+	requests := mock.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 API request, got %d", len(requests))
+	}
+	if requests[0].Status != http.StatusOK {
+		t.Errorf("Expected request to be accepted, got status %d", requests[0].Status)
 	}
 }
 
@@ -124,20 +126,18 @@ func TestRunDiscovery_NoAPIEndpoint(t *testing.T) {
 	discoveryService := discovery.NewService(client, cfg.ClusterName)
 
 	// Create API client
-	apiClient := api.NewClient(cfg, log)
+	apiClient := newAPIClient(cfg, log)
 
 	// Run discovery (should not fail even without API endpoint)
 	ctx := elchiContext.WithConfig(context.Background(), cfg)
-	runDiscovery(ctx, log, discoveryService, apiClient)
+	newAgent(log, discoveryService, apiClient).runDiscovery(ctx)
 
 	// Test passes if no panic or error occurs
 }
 
 func TestRunDiscovery_APIFailure(t *testing.T) {
 	// Create test server that returns error
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
+	server := httptest.NewServer(mockapi.New(mockapi.WithSchedule([]mockapi.Fault{{Status: http.StatusInternalServerError}}, true)))
 	defer server.Close()
 
 	// Create fake Kubernetes client
@@ -168,11 +168,11 @@ func TestRunDiscovery_APIFailure(t *testing.T) {
 	discoveryService := discovery.NewService(client, cfg.ClusterName)
 
 	// Create API client
-	apiClient := api.NewClient(cfg, log)
+	apiClient := newAPIClient(cfg, log)
 
 	// Run discovery (should not fail even with API error)
 	ctx := elchiContext.WithConfig(context.Background(), cfg)
-	runDiscovery(ctx, log, discoveryService, apiClient)
+	newAgent(log, discoveryService, apiClient).runDiscovery(ctx)
 
 	// Test passes if no panic occurs (API failure should be logged but not fatal)
 }
@@ -244,7 +244,7 @@ func TestMainIntegration(t *testing.T) {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(cfg, log)
+	apiClient := newAPIClient(cfg, log)
 	if apiClient == nil {
 		t.Fatal("Failed to create API client")
 	}
@@ -265,7 +265,7 @@ func TestMainIntegration(t *testing.T) {
 	}
 
 	// Test that we can send to API (will fail but shouldn't panic)
-	err = apiClient.SendDiscoveryResult(result)
+	err = apiClient.SendDiscoveryResult(ctx, result)
 	if err == nil {
 		t.Error("Expected error when sending to fake API endpoint")
 	}
@@ -390,12 +390,37 @@ func BenchmarkRunDiscovery(b *testing.B) {
 	}
 	log := logger.New(loggerCfg)
 	discoveryService := discovery.NewService(client, cfg.ClusterName)
-	apiClient := api.NewClient(cfg, log)
+	apiClient := newAPIClient(cfg, log)
+
+	agent := newAgent(log, discoveryService, apiClient)
 
 	ctx := context.Background()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		runDiscovery(ctx, log, discoveryService, apiClient)
+		agent.runDiscovery(ctx)
+	}
+}
+
+func TestLoadSigningSecret(t *testing.T) {
+	secret, err := loadSigningSecret(config.SigningConfig{})
+	if err != nil || secret != nil {
+		t.Errorf("Expected no secret when signing is disabled, got %q (%v)", secret, err)
+	}
+
+	secret, err = loadSigningSecret(config.SigningConfig{Secret: "inline"})
+	if err != nil || string(secret) != "inline" {
+		t.Errorf("Expected inline secret, got %q (%v)", secret, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(path, []byte("from-file\n"), 0o600)
+	secret, err = loadSigningSecret(config.SigningConfig{Secret: "inline", SecretFile: path})
+	if err != nil || string(secret) != "from-file" {
+		t.Errorf("Expected secret file to take precedence, got %q (%v)", secret, err)
+	}
+
+	if _, err := loadSigningSecret(config.SigningConfig{SecretFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("Expected error for a missing secret file")
 	}
 }
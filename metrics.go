@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/metrics"
+)
+
+// registerEndpointMetrics exposes the endpoint pool of every client,
+// labelled by project and endpoint URL
+func registerEndpointMetrics(registry *metrics.Registry, clients []*api.Client) {
+	endpointGauge := func(value func(api.EndpointStatus) bool) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, client := range clients {
+				for _, endpoint := range client.EndpointStatus() {
+					sample := metrics.Sample{Labels: []metrics.Label{
+						{Name: "project", Value: client.Project()},
+						{Name: "endpoint", Value: endpoint.URL},
+					}}
+					if value(endpoint) {
+						sample.Value = 1
+					}
+					samples = append(samples, sample)
+				}
+			}
+			return samples
+		}
+	}
+
+	registry.Register("elchi_discovery_endpoint_active",
+		"Whether the Elchi API endpoint is the one requests are sent to",
+		endpointGauge(func(endpoint api.EndpointStatus) bool { return endpoint.Active }))
+	registry.Register("elchi_discovery_endpoint_healthy",
+		"Whether the last request to the Elchi API endpoint succeeded",
+		endpointGauge(func(endpoint api.EndpointStatus) bool { return endpoint.Healthy }))
+}
+
+// serveMetrics serves registry on address until ctx is done
+func serveMetrics(ctx context.Context, log *logger.Logger, address string, registry *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithError(err).Error("Metrics server stopped")
+	}
+}
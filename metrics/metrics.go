@@ -0,0 +1,97 @@
+// Package metrics serves agent metrics in the Prometheus text exposition
+// format. Values are read from their source on every scrape, so the agent
+// keeps no metric state of its own.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Label is one label of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one labelled value of a gauge
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// gauge is a metric family read by collect on every scrape
+type gauge struct {
+	name    string
+	help    string
+	collect func() []Sample
+}
+
+// Registry holds the gauges served by its handler. It is safe for
+// concurrent use.
+type Registry struct {
+	mu     sync.Mutex
+	gauges []gauge
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a gauge whose samples are read from collect on every scrape
+func (r *Registry) Register(name, help string, collect func() []Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.gauges = append(r.gauges, gauge{name: name, help: help, collect: collect})
+	sort.Slice(r.gauges, func(i, j int) bool { return r.gauges[i].name < r.gauges[j].name })
+}
+
+// ServeHTTP writes every registered gauge
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Write writes every registered gauge in the text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	gauges := append([]gauge(nil), r.gauges...)
+	r.mu.Unlock()
+
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, escape(g.help, false))
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		for _, sample := range g.collect() {
+			fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(sample.Labels), strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		}
+	}
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label.Name, escape(label.Value, true)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes backslashes and line feeds, and double quotes in label values
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	active := "https://eu.example/discovery"
+	registry.Register("test_endpoint_active", "Whether the endpoint is active", func() []Sample {
+		var samples []Sample
+		for _, endpoint := range []string{"https://eu.example/discovery", "https://us.example/discovery"} {
+			value := 0.0
+			if endpoint == active {
+				value = 1
+			}
+			samples = append(samples, Sample{Labels: []Label{{Name: "endpoint", Value: endpoint}}, Value: value})
+		}
+		return samples
+	})
+	registry.Register("test_escaped", "Line one\nline two", func() []Sample {
+		return []Sample{{Labels: []Label{{Name: "value", Value: `a "quoted" \ value`}}, Value: 2.5}}
+	})
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+			t.Errorf("Unexpected content type %q", recorder.Header().Get("Content-Type"))
+		}
+		return recorder.Body.String()
+	}
+
+	expected := `# HELP test_endpoint_active Whether the endpoint is active
+# TYPE test_endpoint_active gauge
+test_endpoint_active{endpoint="https://eu.example/discovery"} 1
+test_endpoint_active{endpoint="https://us.example/discovery"} 0
+# HELP test_escaped Line one\nline two
+# TYPE test_escaped gauge
+test_escaped{value="a \"quoted\" \\ value"} 2.5
+`
+	if got := scrape(); got != expected {
+		t.Errorf("Unexpected exposition:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	// Values are read on every scrape
+	active = "https://us.example/discovery"
+	if got := scrape(); !strings.Contains(got, `test_endpoint_active{endpoint="https://us.example/discovery"} 1`) {
+		t.Errorf("Expected the new active endpoint, got:\n%s", got)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/metrics"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+)
+
+func TestRegisterEndpointMetrics(t *testing.T) {
+	failing, _ := mockapi.ParseSchedule("503")
+	primary := httptest.NewServer(mockapi.New(mockapi.WithSchedule(failing, true)))
+	defer primary.Close()
+	secondary := httptest.NewServer(mockapi.New())
+	defer secondary.Close()
+
+	client := api.NewClient(
+		api.WithEndpoints(primary.URL, secondary.URL),
+		api.WithEndpointBackoff(time.Hour, time.Hour),
+		api.WithToken("96688e4c-6737-4230-9591-6a3332115871--team-a"),
+		api.WithLogger(logger.NewDefault()),
+	)
+	registry := metrics.NewRegistry()
+	registerEndpointMetrics(registry, []*api.Client{client})
+
+	if err := client.SendDiscoveryResult(context.Background(), &discovery.DiscoveryResult{}); err != nil {
+		t.Fatalf("Expected failover to the secondary, got %v", err)
+	}
+
+	var out bytes.Buffer
+	registry.Write(&out)
+	for _, expected := range []string{
+		fmt.Sprintf(`elchi_discovery_endpoint_active{project="team-a",endpoint="%s"} 0`, primary.URL),
+		fmt.Sprintf(`elchi_discovery_endpoint_active{project="team-a",endpoint="%s"} 1`, secondary.URL),
+		fmt.Sprintf(`elchi_discovery_endpoint_healthy{project="team-a",endpoint="%s"} 0`, primary.URL),
+		fmt.Sprintf(`elchi_discovery_endpoint_healthy{project="team-a",endpoint="%s"} 1`, secondary.URL),
+	} {
+		if !strings.Contains(out.String(), expected+"\n") {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package mockapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Headers sent with each part of a chunked payload
+const (
+	HeaderSnapshotID = "snapshot-id"
+	HeaderPart       = "part"
+	HeaderParts      = "parts"
+)
+
+// decodeBody undoes the Content-Encoding of a request body
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case "zstd":
+		r, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+}
+
+// assemble stores a part of a chunked payload and reports whether all parts
+// have been received, in which case req.Body holds the reassembled document
+func (s *Server) assemble(req *Request, snapshotID string, header http.Header) (bool, error) {
+	part, err := strconv.Atoi(header.Get(HeaderPart))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s header", HeaderPart)
+	}
+	parts, err := strconv.Atoi(header.Get(HeaderParts))
+	if err != nil || parts < 1 || part < 1 || part > parts {
+		return false, fmt.Errorf("invalid %s header", HeaderParts)
+	}
+	req.SnapshotID = snapshotID
+	req.Part = part
+	req.Parts = parts
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	received := s.chunks[snapshotID]
+	if len(received) != part-1 {
+		delete(s.chunks, snapshotID)
+		return false, fmt.Errorf("part %d of snapshot %s received out of order", part, snapshotID)
+	}
+	received = append(received, req.Body)
+	if part < parts {
+		s.chunks[snapshotID] = received
+		return false, nil
+	}
+
+	delete(s.chunks, snapshotID)
+	req.Body = bytes.Join(received, nil)
+	return true, nil
+}
@@ -0,0 +1,67 @@
+package mockapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(testBody))
+	w.Close()
+
+	body, err := decodeBody("gzip", buf.Bytes())
+	if err != nil || string(body) != testBody {
+		t.Errorf("Expected decoded body, got %q (%v)", body, err)
+	}
+
+	if _, err := decodeBody("br", []byte("x")); err == nil {
+		t.Error("Expected error for unsupported encoding")
+	}
+}
+
+func TestServer_Chunks(t *testing.T) {
+	mock := New()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	send := func(part, parts string, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("from-elchi", "yes")
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		req.Header.Set(HeaderSnapshotID, "snap-1")
+		req.Header.Set(HeaderPart, part)
+		req.Header.Set(HeaderParts, parts)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	half := len(testBody) / 2
+	if status := send("1", "2", testBody[:half]); status != http.StatusOK {
+		t.Errorf("Expected first part to be accepted, got %d", status)
+	}
+	if status := send("2", "2", testBody[half:]); status != http.StatusOK {
+		t.Errorf("Expected last part to be accepted, got %d", status)
+	}
+
+	requests := mock.Requests()
+	if len(requests[0].Body) != 0 {
+		t.Errorf("Expected no body on the first part, got %s", requests[0].Body)
+	}
+	if string(requests[1].Body) != testBody || requests[1].Project != "683b2148ff7e3ae67d825cfa" {
+		t.Errorf("Expected reassembled body, got %+v", requests[1])
+	}
+
+	if status := send("2", "2", testBody[half:]); status != http.StatusBadRequest {
+		t.Errorf("Expected out of order part to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,444 @@
+// Package mockapi is a stand-in for the Elchi discovery API used for local
+// development and tests. It checks the headers the agent sends, answers with
+// the API response envelope, stores every request for inspection and can
+// inject latency, errors and rate limiting on a schedule.
+package mockapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/internal/signing"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/token"
+)
+
+// InspectPrefix is the path prefix of the inspection API
+const InspectPrefix = "/_mock/"
+
+// HeaderHandshakeEpoch identifies the handshake of a project. It is returned
+// for accepted initial requests and expected back on the following ones.
+const HeaderHandshakeEpoch = "elchi-handshake-epoch"
+
+// signatureMaxSkew is the accepted clock difference for signed requests
+const signatureMaxSkew = 5 * time.Minute
+
+// Response is the API response envelope
+type Response struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Request is a request received by the mock
+type Request struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Initial string    `json:"initial"`
+	Token   string    `json:"token,omitempty"`
+	// Project is decoded from the body
+	Project string `json:"project,omitempty"`
+	// Body is the decoded JSON body; for chunked payloads the reassembled
+	// document on the last part and empty on the others
+	Body json.RawMessage `json:"body,omitempty"`
+	// Encoding is the Content-Encoding of the request
+	Encoding   string `json:"encoding,omitempty"`
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	Part       int    `json:"part,omitempty"`
+	Parts      int    `json:"parts,omitempty"`
+	// AgentID and Sequence are set for verified signed requests
+	AgentID  string `json:"agent_id,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	// SequenceGap counts sequence numbers skipped since the previous request
+	SequenceGap uint64 `json:"sequence_gap,omitempty"`
+	// HandshakeEpoch is the epoch sent by the agent, or the one assigned to
+	// an accepted initial request
+	HandshakeEpoch string `json:"handshake_epoch,omitempty"`
+	// Status is the HTTP status the mock answered with
+	Status int `json:"status"`
+}
+
+// Decode unmarshals the request body into v
+func (r *Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Server implements the discovery endpoint contract
+type Server struct {
+	token          string
+	requireInitial bool
+	signingSecret  []byte
+
+	mu       sync.Mutex
+	requests []Request
+	schedule []Fault
+	loop     bool
+	next     int
+	result   json.RawMessage
+	// handshakes holds the epoch of every project that completed an initial request
+	handshakes map[string]string
+	// chunks holds the parts received so far per snapshot ID
+	chunks map[string][][]byte
+	// nonces and sequences detect replayed signed requests
+	nonces    map[string]bool
+	sequences map[string]uint64
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithToken makes the server reject requests without this bearer token.
+// Without it any token in the uuid--project format is accepted.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithRequireInitial rejects initial:false requests from projects that never
+// completed an initial request
+func WithRequireInitial(require bool) Option {
+	return func(s *Server) {
+		s.requireInitial = require
+	}
+}
+
+// WithSigningSecret requires requests to be signed with secret and rejects
+// replayed nonces and sequence numbers
+func WithSigningSecret(secret []byte) Option {
+	return func(s *Server) {
+		s.signingSecret = secret
+	}
+}
+
+// WithSchedule sets the faults applied to the following requests, see SetSchedule
+func WithSchedule(schedule []Fault, loop bool) Option {
+	return func(s *Server) {
+		s.schedule = schedule
+		s.loop = loop
+	}
+}
+
+// New creates a mock server
+func New(opts ...Option) *Server {
+	s := &Server{
+		handshakes: make(map[string]string),
+		chunks:     make(map[string][][]byte),
+		nonces:     make(map[string]bool),
+		sequences:  make(map[string]uint64),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetSchedule sets the faults applied to the following requests, one per
+// request in order. With loop the schedule restarts when exhausted, otherwise
+// requests after the last entry are answered normally.
+func (s *Server) SetSchedule(schedule []Fault, loop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.schedule = schedule
+	s.loop = loop
+	s.next = 0
+}
+
+// SetResult sets the result returned with successful responses, e.g.
+// directives for the agent
+func (s *Server) SetResult(result json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.result = result
+}
+
+// Requests returns a copy of the received requests
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset forgets received requests, completed handshakes and seen sequence
+// numbers
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = nil
+	s.handshakes = make(map[string]string)
+	s.chunks = make(map[string][][]byte)
+	s.nonces = make(map[string]bool)
+	s.sequences = make(map[string]uint64)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, InspectPrefix) {
+		s.serveInspect(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Response{Error: "failed to read body"})
+		return
+	}
+
+	req := Request{
+		Time:    time.Now(),
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Initial: r.Header.Get("initial"),
+
+		HandshakeEpoch: r.Header.Get(HeaderHandshakeEpoch),
+		Token:          strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		Body:           body,
+	}
+
+	fault := s.nextFault()
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status, response := s.handle(r, &req, fault)
+	req.Status = status
+	if !json.Valid(req.Body) {
+		req.Body = nil
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	if status == http.StatusOK && req.HandshakeEpoch != "" {
+		w.Header().Set(HeaderHandshakeEpoch, req.HandshakeEpoch)
+	}
+	if status == http.StatusTooManyRequests && fault.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter/time.Second)))
+	}
+	writeJSON(w, status, response)
+}
+
+// handle validates a request and returns the response to send
+func (s *Server) handle(r *http.Request, req *Request, fault Fault) (int, Response) {
+	if r.Method != http.MethodPost {
+		return http.StatusMethodNotAllowed, Response{Error: "method not allowed"}
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		return http.StatusUnsupportedMediaType, Response{Error: "expected application/json body"}
+	}
+	if r.Header.Get("from-elchi") != "yes" {
+		return http.StatusBadRequest, Response{Error: "missing from-elchi header"}
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || req.Token == "" {
+		return http.StatusUnauthorized, Response{Error: "missing bearer token"}
+	}
+	if s.token != "" && req.Token != s.token {
+		return http.StatusForbidden, Response{Error: "invalid token"}
+	}
+	tokenProject := projectFromToken(req.Token)
+	if tokenProject == "" {
+		return http.StatusUnauthorized, Response{Error: "invalid token format"}
+	}
+
+	if len(s.signingSecret) > 0 {
+		if status, response := s.verify(r, req); status != http.StatusOK {
+			return status, response
+		}
+	}
+
+	req.Encoding = r.Header.Get("Content-Encoding")
+	body, err := decodeBody(req.Encoding, req.Body)
+	if err != nil {
+		return http.StatusUnsupportedMediaType, Response{Error: err.Error()}
+	}
+	req.Body = body
+
+	if snapshotID := r.Header.Get(HeaderSnapshotID); snapshotID != "" {
+		complete, err := s.assemble(req, snapshotID, r.Header)
+		if err != nil {
+			return http.StatusBadRequest, Response{Error: err.Error()}
+		}
+		if !complete {
+			req.Body = nil
+			return http.StatusOK, Response{Success: true, Message: "Part received"}
+		}
+	}
+
+	var payload struct {
+		Project string `json:"project"`
+	}
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		return http.StatusBadRequest, Response{Error: "invalid JSON body"}
+	}
+	req.Project = payload.Project
+	if payload.Project != tokenProject {
+		return http.StatusBadRequest, Response{Error: "project does not match token"}
+	}
+
+	if fault.Status != 0 {
+		message := fault.Error
+		if message == "" {
+			message = http.StatusText(fault.Status)
+		}
+		return fault.Status, Response{Error: message}
+	}
+	if fault.Error != "" {
+		return http.StatusOK, Response{Error: fault.Error}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Initial {
+	case "true":
+		req.HandshakeEpoch = newEpoch()
+		s.handshakes[payload.Project] = req.HandshakeEpoch
+	case "false":
+		epoch, ok := s.handshakes[payload.Project]
+		if s.requireInitial && !ok {
+			return http.StatusConflict, Response{Error: "initial payload required"}
+		}
+		// An epoch from before Reset, or a restart of a real API, is unknown
+		if req.HandshakeEpoch != "" && req.HandshakeEpoch != epoch {
+			return http.StatusConflict, Response{Error: "unknown handshake epoch"}
+		}
+	}
+
+	return http.StatusOK, Response{
+		Success: true,
+		Result:  s.result,
+		Message: "Discovery processed",
+	}
+}
+
+// verify checks the request signature and rejects replays. The signature
+// covers the body as sent, before Content-Encoding is undone.
+func (s *Server) verify(r *http.Request, req *Request) (int, Response) {
+	sequence, err := signing.Verify(r, req.Body, s.signingSecret, signatureMaxSkew, time.Now())
+	if err != nil {
+		return http.StatusUnauthorized, Response{Error: err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nonce := r.Header.Get(signing.HeaderNonce)
+	agentID := r.Header.Get(signing.HeaderAgentID)
+	last := s.sequences[agentID]
+	if s.nonces[nonce] || sequence <= last {
+		// Not a conflict: 409 tells the agent its handshake is invalidated
+		return http.StatusBadRequest, Response{Error: "replayed request"}
+	}
+	s.nonces[nonce] = true
+	s.sequences[agentID] = sequence
+
+	req.AgentID = agentID
+	req.Sequence = sequence
+	if last > 0 {
+		req.SequenceGap = sequence - last - 1
+	}
+	return http.StatusOK, Response{}
+}
+
+func (s *Server) nextFault() Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.schedule) == 0 {
+		return Fault{}
+	}
+	if s.next >= len(s.schedule) {
+		if !s.loop {
+			return Fault{}
+		}
+		s.next = 0
+	}
+	fault := s.schedule[s.next]
+	s.next++
+	return fault
+}
+
+// serveInspect implements the inspection API:
+//
+//	GET    /_mock/requests       received requests
+//	GET    /_mock/requests/last  last received request
+//	DELETE /_mock/requests       forget requests, handshakes and sequence numbers
+//	PUT    /_mock/schedule       set the fault schedule, e.g. "ok,500,429+retry=5s"; ?loop=true repeats it
+//	PUT    /_mock/result         set the result returned on success
+func (s *Server) serveInspect(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, InspectPrefix)
+
+	switch {
+	case path == "requests" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Requests())
+	case path == "requests" && r.Method == http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	case path == "requests/last" && r.Method == http.MethodGet:
+		requests := s.Requests()
+		if len(requests) == 0 {
+			writeJSON(w, http.StatusNotFound, Response{Error: "no requests received"})
+			return
+		}
+		writeJSON(w, http.StatusOK, requests[len(requests)-1])
+	case path == "schedule" && r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		schedule, err := ParseSchedule(string(body))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, Response{Error: err.Error()})
+			return
+		}
+		s.SetSchedule(schedule, r.URL.Query().Get("loop") == "true")
+		w.WriteHeader(http.StatusNoContent)
+	case path == "result" && r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if len(body) > 0 && !json.Valid(body) {
+			writeJSON(w, http.StatusBadRequest, Response{Error: "invalid JSON body"})
+			return
+		}
+		s.SetResult(body)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusNotFound, Response{Error: "unknown inspection endpoint"})
+	}
+}
+
+// projectFromToken extracts the project from a "uuid--project" token or the
+// project claim of a JWT. Like the agent, the mock does not check JWT
+// signatures.
+func projectFromToken(value string) string {
+	info, err := token.Parse(value)
+	if err != nil {
+		return ""
+	}
+	return info.Project
+}
+
+// newEpoch identifies a handshake
+func newEpoch() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,217 @@
+package mockapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/internal/signing"
+)
+
+const testToken = "96688e4c-6737-4230-9591-6a3332115871--683b2148ff7e3ae67d825cfa"
+
+func post(t *testing.T, url, token, initial, body string) (*http.Response, Response) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("from-elchi", "yes")
+	if initial != "" {
+		req.Header.Set("initial", initial)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response Response
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp, response
+}
+
+const testBody = `{"project":"683b2148ff7e3ae67d825cfa","data":{}}`
+
+func TestServer_Contract(t *testing.T) {
+	mock := New(WithToken(testToken), WithRequireInitial(true))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		token   string
+		initial string
+		body    string
+		status  int
+	}{
+		{"missing token", "", "true", testBody, http.StatusUnauthorized},
+		{"wrong token", "other--683b2148ff7e3ae67d825cfa", "true", testBody, http.StatusForbidden},
+		{"invalid body", testToken, "true", "not json", http.StatusBadRequest},
+		{"project mismatch", testToken, "true", `{"project":"other"}`, http.StatusBadRequest},
+		{"non-initial before handshake", testToken, "false", testBody, http.StatusConflict},
+		{"initial", testToken, "true", testBody, http.StatusOK},
+		{"non-initial after handshake", testToken, "false", testBody, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, response := post(t, server.URL, tt.token, tt.initial, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d (%s)", tt.status, resp.StatusCode, response.Error)
+			}
+			if response.Success != (tt.status == http.StatusOK) {
+				t.Errorf("Unexpected success %v for status %d", response.Success, resp.StatusCode)
+			}
+		})
+	}
+
+	requests := mock.Requests()
+	if len(requests) != len(tests) {
+		t.Fatalf("Expected %d stored requests, got %d", len(tests), len(requests))
+	}
+	last := requests[len(requests)-1]
+	if last.Project != "683b2148ff7e3ae67d825cfa" || last.Initial != "false" || last.Status != http.StatusOK {
+		t.Errorf("Unexpected stored request: %+v", last)
+	}
+}
+
+func TestServer_Schedule(t *testing.T) {
+	schedule, err := ParseSchedule("500,429,fail,ok+delay=50ms")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(New(WithSchedule(schedule, false)))
+	defer server.Close()
+
+	resp, _ := post(t, server.URL, testToken, "true", testBody)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", resp.StatusCode)
+	}
+
+	resp, _ = post(t, server.URL, testToken, "true", testBody)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After 1, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	resp, response := post(t, server.URL, testToken, "true", testBody)
+	if resp.StatusCode != http.StatusOK || response.Success || response.Error == "" {
+		t.Errorf("Expected success:false with 200, got %d %+v", resp.StatusCode, response)
+	}
+
+	start := time.Now()
+	resp, response = post(t, server.URL, testToken, "true", testBody)
+	if !response.Success || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Expected delayed success, got %+v after %s", response, time.Since(start))
+	}
+
+	// Exhausted schedules answer normally
+	resp, _ = post(t, server.URL, testToken, "true", testBody)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 after schedule, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_Inspect(t *testing.T) {
+	mock := New()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/_mock/result", strings.NewReader(`{"directives":{"id":"d-1"}}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Failed to set result: %v %v", err, resp)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, server.URL+"/_mock/schedule?loop=true", strings.NewReader("503"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Failed to set schedule: %v %v", err, resp)
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp, _ := post(t, server.URL, testToken, "true", testBody); resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected looped 503, got %d", resp.StatusCode)
+		}
+	}
+	mock.SetSchedule(nil, false)
+	if _, response := post(t, server.URL, testToken, "true", testBody); string(response.Result) != `{"directives":{"id":"d-1"}}` {
+		t.Errorf("Expected configured result, got %s", response.Result)
+	}
+
+	resp, err = http.Get(server.URL + "/_mock/requests/last")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var last Request
+	json.NewDecoder(resp.Body).Decode(&last)
+	resp.Body.Close()
+	if last.Status != http.StatusOK || last.Project != "683b2148ff7e3ae67d825cfa" {
+		t.Errorf("Unexpected last request: %+v", last)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, server.URL+"/_mock/requests", nil)
+	http.DefaultClient.Do(req)
+
+	resp, err = http.Get(server.URL + "/_mock/requests")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var requests []Request
+	json.NewDecoder(resp.Body).Decode(&requests)
+	resp.Body.Close()
+	if len(requests) != 0 {
+		t.Errorf("Expected no requests after reset, got %d", len(requests))
+	}
+}
+
+func TestServer_SignedReplay(t *testing.T) {
+	secret := []byte("secret")
+	mock := New(WithSigningSecret(secret))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	send := func(sequence uint64, nonceFrom *http.Request) (*http.Request, int) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/", bytes.NewBufferString(testBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("from-elchi", "yes")
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		signing.Sign(req, []byte(testBody), secret, "agent-1", sequence, time.Now())
+		if nonceFrom != nil {
+			// Replay a captured request verbatim
+			req.Header = nonceFrom.Header.Clone()
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return req, resp.StatusCode
+	}
+
+	first, status := send(1, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Expected signed request to be accepted, got %d", status)
+	}
+	if _, status := send(0, first); status != http.StatusBadRequest {
+		t.Errorf("Expected replayed request to be rejected, got %d", status)
+	}
+	if _, status := send(1, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected reused sequence to be rejected, got %d", status)
+	}
+	if _, status := send(4, nil); status != http.StatusOK {
+		t.Errorf("Expected later sequence to be accepted, got %d", status)
+	}
+
+	requests := mock.Requests()
+	if last := requests[len(requests)-1]; last.SequenceGap != 2 {
+		t.Errorf("Expected a gap of 2, got %d", last.SequenceGap)
+	}
+}
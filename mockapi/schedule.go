@@ -0,0 +1,71 @@
+package mockapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault is applied to a single request
+type Fault struct {
+	// Latency delays the response
+	Latency time.Duration `json:"latency,omitempty"`
+	// Status answers with this HTTP status instead of processing the request
+	Status int `json:"status,omitempty"`
+	// Error is the error message; without Status it is returned as a
+	// success:false body with HTTP 200
+	Error string `json:"error,omitempty"`
+	// RetryAfter is sent with 429 responses
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// ParseSchedule parses a comma separated list of faults. Each entry is "ok",
+// an HTTP status code, "fail" for a success:false response, "delay=<duration>"
+// or "retry=<duration>", combined with "+", e.g. "ok,500,429+retry=5s,ok+delay=2s".
+func ParseSchedule(s string) ([]Fault, error) {
+	var schedule []Fault
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		var fault Fault
+		for _, part := range strings.Split(strings.TrimSpace(entry), "+") {
+			part = strings.TrimSpace(part)
+			key, value, hasValue := strings.Cut(part, "=")
+
+			switch {
+			case part == "ok":
+			case part == "fail":
+				fault.Error = "injected failure"
+			case hasValue && key == "delay":
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid delay %q: %w", value, err)
+				}
+				fault.Latency = d
+			case hasValue && key == "retry":
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid retry %q: %w", value, err)
+				}
+				fault.RetryAfter = d
+			default:
+				status, err := strconv.Atoi(part)
+				if err != nil || status < 100 || status > 599 {
+					return nil, fmt.Errorf("invalid schedule entry %q", part)
+				}
+				fault.Status = status
+			}
+		}
+		if fault.Status == 429 && fault.RetryAfter == 0 {
+			fault.RetryAfter = time.Second
+		}
+		schedule = append(schedule, fault)
+	}
+
+	return schedule, nil
+}
@@ -0,0 +1,43 @@
+package mockapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Fault
+		wantErr  bool
+	}{
+		{"empty", "", nil, false},
+		{"ok", "ok", []Fault{{}}, false},
+		{"status", "500", []Fault{{Status: 500}}, false},
+		{"rate limited", "429", []Fault{{Status: 429, RetryAfter: time.Second}}, false},
+		{"retry after", "429+retry=5s", []Fault{{Status: 429, RetryAfter: 5 * time.Second}}, false},
+		{"fail", "fail", []Fault{{Error: "injected failure"}}, false},
+		{"combined", "ok, 503+delay=2s", []Fault{{}, {Status: 503, Latency: 2 * time.Second}}, false},
+		{"invalid status", "700", nil, true},
+		{"invalid entry", "boom", nil, true},
+		{"invalid delay", "delay=soon", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseSchedule(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %d faults, got %d", len(tt.expected), len(result))
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("Expected %+v, got %+v", tt.expected[i], result[i])
+				}
+			}
+		})
+	}
+}
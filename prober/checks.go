@@ -0,0 +1,220 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/http2"
+)
+
+// maxResponseBody bounds how much of a probe response is read
+const maxResponseBody = 64 * 1024
+
+// gRPC health checking protocol, see grpc/health/v1/health.proto
+const (
+	grpcHealthPath = "/grpc.health.v1.Health/Check"
+	grpcServing    = 1
+)
+
+// grpcStatusNames names the HealthCheckResponse.ServingStatus values
+var grpcStatusNames = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+func newHTTPClient(dialer *net.Dialer) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			// Probes go straight to the node, never through a proxy
+			Proxy:             nil,
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+		// Like kubelet HTTP probes a redirect counts as an answer
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// newGRPCClient speaks HTTP/2 over cleartext (h2c), which is all a gRPC
+// health check needs without depending on the gRPC module
+func newGRPCClient(dialer *net.Dialer) *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// probeTCP succeeds when a connection to target is accepted
+func (p *Prober) probeTCP(ctx context.Context, target string) error {
+	conn, err := p.dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTP succeeds when a GET of path answers with a 2xx or 3xx status
+func (p *Prober) probeHTTP(ctx context.Context, target, path string) error {
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "elchi-discovery-prober")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeGRPC calls grpc.health.v1.Health/Check and succeeds when the server
+// reports SERVING
+func (p *Prober) probeGRPC(ctx context.Context, target, service string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+target+grpcHealthPath,
+		bytes.NewReader(grpcFrame(healthCheckRequest(service))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "elchi-discovery-prober")
+
+	resp, err := p.grpcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	// Errors come as trailers, or as headers in a trailers-only response
+	code := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+	if code != "" && code != "0" {
+		return fmt.Errorf("gRPC status %s: %s", code, message)
+	}
+
+	status, err := healthCheckStatus(body)
+	if err != nil {
+		return err
+	}
+	if status != grpcServing {
+		name, ok := grpcStatusNames[status]
+		if !ok {
+			name = strconv.FormatUint(status, 10)
+		}
+		return fmt.Errorf("gRPC health status %s", name)
+	}
+	return nil
+}
+
+// grpcFrame prefixes an uncompressed message with its gRPC length header
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// healthCheckRequest encodes HealthCheckRequest{service}, field 1 as a string
+func healthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	message := []byte{0x0a}
+	message = binary.AppendUvarint(message, uint64(len(service)))
+	return append(message, service...)
+}
+
+// healthCheckStatus decodes the status, field 1, of a framed
+// HealthCheckResponse. A missing field is the proto3 default, UNKNOWN.
+func healthCheckStatus(body []byte) (uint64, error) {
+	if len(body) < 5 {
+		return 0, errors.New("gRPC response has no message")
+	}
+	if body[0] != 0 {
+		return 0, errors.New("gRPC response is compressed")
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) < uint64(length) {
+		return 0, errors.New("gRPC response message is truncated")
+	}
+	message := body[5 : 5+length]
+
+	var status uint64
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("invalid gRPC health response")
+		}
+		message = message[n:]
+
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("invalid gRPC health response")
+			}
+			message = message[n:]
+			if field == 1 {
+				status = value
+			}
+		case 1:
+			if len(message) < 8 {
+				return 0, errors.New("invalid gRPC health response")
+			}
+			message = message[8:]
+		case 2:
+			size, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < size {
+				return 0, errors.New("invalid gRPC health response")
+			}
+			message = message[n+int(size):]
+		case 5:
+			if len(message) < 4 {
+				return 0, errors.New("invalid gRPC health response")
+			}
+			message = message[4:]
+		default:
+			return 0, errors.New("invalid gRPC health response")
+		}
+	}
+	return status, nil
+}
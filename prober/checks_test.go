@@ -0,0 +1,166 @@
+package prober
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func serverTarget(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestProbeHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path      string
+		expectErr bool
+	}{
+		{"/healthz", false},
+		{"/moved", false},
+		{"/broken", true},
+		{"", true},
+	}
+
+	p := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := p.probeHTTP(context.Background(), serverTarget(t, server), tt.path)
+			if tt.expectErr && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// grpcHealthServer answers health checks over h2c with the status for the
+// requested service, or a NOT_FOUND gRPC status for unknown services
+func grpcHealthServer(t *testing.T, statuses map[string]byte) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != grpcHealthPath || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("Expected gRPC health request over HTTP/2, got %s %s %s", r.Proto, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		service := ""
+		if len(body) > 7 {
+			service = string(body[7:])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		_, _ = w.Write(grpcFrame([]byte{0x08, status}))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+	return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+}
+
+func TestProbeGRPC(t *testing.T) {
+	server := grpcHealthServer(t, map[string]byte{"": 1, "envoy": 2})
+	defer server.Close()
+
+	tests := []struct {
+		service   string
+		expectErr string
+	}{
+		{"", ""},
+		{"envoy", "NOT_SERVING"},
+		{"missing", "gRPC status 5"},
+	}
+
+	p := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			err := p.probeGRPC(context.Background(), serverTarget(t, server), tt.service)
+			if tt.expectErr == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestProbeTCP_Refused(t *testing.T) {
+	p := New(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	target := net.JoinHostPort("127.0.0.1", "1")
+	if err := p.probeTCP(ctx, target); err == nil {
+		t.Error("Expected error for a closed port")
+	}
+}
+
+func TestHealthCheckStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []byte
+		expected  uint64
+		expectErr bool
+	}{
+		{"serving", grpcFrame([]byte{0x08, 0x01}), 1, false},
+		{"default unknown", grpcFrame(nil), 0, false},
+		{"unknown fields skipped", grpcFrame([]byte{0x12, 0x02, 'h', 'i', 0x08, 0x02}), 2, false},
+		{"no message", []byte{0x00}, 0, true},
+		{"compressed", []byte{0x01, 0, 0, 0, 0}, 0, true},
+		{"truncated", []byte{0x00, 0, 0, 0, 9, 0x08}, 0, true},
+		{"invalid varint", grpcFrame([]byte{0x08}), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := healthCheckStatus(tt.body)
+			if tt.expectErr {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if status != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
+
+func TestHealthCheckRequest(t *testing.T) {
+	if request := healthCheckRequest(""); len(request) != 0 {
+		t.Errorf("Expected empty request for the whole server, got %v", request)
+	}
+	if request := string(healthCheckRequest("envoy")); request != "\x0a\x05envoy" {
+		t.Errorf("Expected encoded service name, got %q", request)
+	}
+}
@@ -0,0 +1,384 @@
+// Package prober actively health checks the ports Elchi sends traffic to on
+// every discovered node. The kubelet's Ready condition only says the node is
+// up; a probe says whether the port on the node actually answers.
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+)
+
+// Types of checks
+const (
+	TypeTCP  = "tcp"
+	TypeHTTP = "http"
+	TypeGRPC = "grpc"
+)
+
+const (
+	// DefaultConcurrency is the number of probes running at once
+	DefaultConcurrency = 10
+
+	// DefaultTimeout bounds a single probe
+	DefaultTimeout = 2 * time.Second
+
+	// DefaultInterval is the time between probe rounds, which also bounds
+	// every round
+	DefaultInterval = 30 * time.Second
+)
+
+// errNoAddress is reported for nodes without a primary address to probe
+var errNoAddress = errors.New("node has no primary address")
+
+// Check is a health check run against one port of every node
+type Check struct {
+	Name string
+	// Type is tcp, http or grpc
+	Type string
+	Port int
+	// Path is requested by http checks, "/" when empty
+	Path string
+	// Service is the gRPC health service name, empty for the whole server
+	Service string
+}
+
+// Validate reports whether the check has a name, a known type and a port
+func (c Check) Validate() error {
+	if c.Name == "" {
+		return errors.New("probe check must set a name")
+	}
+	switch c.Type {
+	case TypeTCP, TypeHTTP, TypeGRPC:
+	default:
+		return fmt.Errorf("probe check %q has unknown type %q: expected %s, %s or %s", c.Name, c.Type, TypeTCP, TypeHTTP, TypeGRPC)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("probe check %q has invalid port %d", c.Name, c.Port)
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("probe check %q path %q must start with /", c.Name, c.Path)
+	}
+	return nil
+}
+
+// ValidateChecks validates every check and rejects duplicate names, which
+// would share their history
+func ValidateChecks(checks []Check) error {
+	names := make(map[string]bool, len(checks))
+	for _, check := range checks {
+		if err := check.Validate(); err != nil {
+			return err
+		}
+		if names[check.Name] {
+			return fmt.Errorf("duplicate probe check name %q", check.Name)
+		}
+		names[check.Name] = true
+	}
+	return nil
+}
+
+// target is a node to probe
+type target struct {
+	name    string
+	address string
+}
+
+// history is what a probe remembers between rounds
+type history struct {
+	lastSuccess   time.Time
+	failureStreak int
+	// result is the outcome of the last probe
+	result discovery.ProbeResult
+}
+
+// Prober probes every node in rounds running apart from discovery, so slow
+// or unreachable nodes never delay a snapshot. Discovery attaches the last
+// results to each snapshot.
+type Prober struct {
+	checks      []Check
+	concurrency int
+	timeout     time.Duration
+	interval    time.Duration
+	now         func() time.Time
+	log         *logger.Logger
+
+	dialer     *net.Dialer
+	httpClient *http.Client
+	grpcClient *http.Client
+
+	// started is signalled when the first nodes arrive
+	started chan struct{}
+
+	mu      sync.Mutex
+	targets []target
+	// next is the node a round starts with, so a round cut short by its
+	// deadline is continued by the next one
+	next    int
+	history map[string]*history
+}
+
+// Option configures optional Prober behaviour
+type Option func(*Prober)
+
+// WithConcurrency bounds the number of probes running at once
+func WithConcurrency(concurrency int) Option {
+	return func(p *Prober) {
+		if concurrency > 0 {
+			p.concurrency = concurrency
+		}
+	}
+}
+
+// WithTimeout bounds every single probe
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *Prober) {
+		if timeout > 0 {
+			p.timeout = timeout
+		}
+	}
+}
+
+// WithInterval sets the time between probe rounds. A round still running
+// after the interval is cut short; the nodes it missed are probed first in
+// the next round.
+func WithInterval(interval time.Duration) Option {
+	return func(p *Prober) {
+		if interval > 0 {
+			p.interval = interval
+		}
+	}
+}
+
+// WithLogger sets the logger reporting rounds cut short
+func WithLogger(log *logger.Logger) Option {
+	return func(p *Prober) {
+		p.log = log
+	}
+}
+
+// New creates a prober for checks, which must pass ValidateChecks
+func New(checks []Check, opts ...Option) *Prober {
+	p := &Prober{
+		checks:      checks,
+		concurrency: DefaultConcurrency,
+		timeout:     DefaultTimeout,
+		interval:    DefaultInterval,
+		now:         time.Now,
+		dialer:      &net.Dialer{},
+		started:     make(chan struct{}, 1),
+		history:     make(map[string]*history),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.log == nil {
+		p.log = logger.NewDefault()
+	}
+
+	p.httpClient = newHTTPClient(p.dialer)
+	p.grpcClient = newGRPCClient(p.dialer)
+	return p
+}
+
+// Run probes the nodes set with SetNodes every interval until ctx is
+// cancelled. The first round starts as soon as nodes are known.
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.started:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		p.round(ctx)
+	}
+}
+
+// SetNodes replaces the nodes probed by the following rounds and forgets the
+// history of nodes that are gone
+func (p *Prober) SetNodes(nodes []discovery.NodeInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	first := len(p.targets) == 0
+	p.targets = make([]target, 0, len(nodes))
+	keep := make(map[string]bool, len(nodes)*len(p.checks))
+	for _, node := range nodes {
+		p.targets = append(p.targets, target{name: node.Name, address: node.PrimaryAddress})
+		for _, check := range p.checks {
+			keep[historyKey(node.Name, check)] = true
+		}
+	}
+	for key := range p.history {
+		if !keep[key] {
+			delete(p.history, key)
+		}
+	}
+
+	if first && len(p.targets) > 0 {
+		select {
+		case p.started <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Attach sets the Probes of every node in result to the last probe results.
+// Nodes not probed yet have none.
+func (p *Prober) Attach(result *discovery.DiscoveryResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range result.Nodes {
+		node := &result.Nodes[i]
+		node.Probes = nil
+		for _, check := range p.checks {
+			if h := p.history[historyKey(node.Name, check)]; h != nil {
+				node.Probes = append(node.Probes, h.result)
+			}
+		}
+	}
+}
+
+// round runs every check against every node, at most concurrency at once,
+// and returns once every probe has finished or the interval has passed.
+// Probes cut short keep their previous result.
+func (p *Prober) round(ctx context.Context) {
+	p.mu.Lock()
+	targets := p.targets
+	start := p.next
+	p.mu.Unlock()
+	if len(targets) == 0 || len(p.checks) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+	// Do not keep HTTP/2 connections to every node open between rounds
+	defer p.grpcClient.CloseIdleConnections()
+
+	type job struct {
+		target target
+		check  Check
+	}
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(p.concurrency, len(targets)*len(p.checks)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				begin := time.Now()
+				err := p.run(ctx, j.target.address, j.check)
+				if err != nil && ctx.Err() != nil {
+					// Cut short by the round deadline, not a node failure
+					continue
+				}
+				p.record(j.target.name, j.check, err, time.Since(begin))
+			}
+		}()
+	}
+
+	dispatched := 0
+dispatch:
+	for ; dispatched < len(targets); dispatched++ {
+		t := targets[(start+dispatched)%len(targets)]
+		for _, check := range p.checks {
+			select {
+			case jobs <- job{target: t, check: check}:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	p.mu.Lock()
+	p.next = (start + dispatched) % len(targets)
+	p.mu.Unlock()
+
+	if dispatched < len(targets) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		p.log.WithFields(map[string]interface{}{
+			"nodes":    len(targets),
+			"probed":   dispatched,
+			"interval": p.interval.String(),
+		}).Warn("Probe round did not finish within the interval, continuing with the remaining nodes next round")
+	}
+}
+
+// record stores the outcome of one probe, carrying the last success and
+// failure streak over from previous rounds
+func (p *Prober) record(node string, check Check, err error, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := historyKey(node, check)
+	h := p.history[key]
+	if h == nil {
+		h = &history{}
+		p.history[key] = h
+	}
+
+	probe := discovery.ProbeResult{
+		Name:      check.Name,
+		Type:      check.Type,
+		Port:      check.Port,
+		Healthy:   err == nil,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+	}
+	if probe.Healthy {
+		h.lastSuccess = p.now()
+		h.failureStreak = 0
+	} else {
+		h.failureStreak++
+		probe.Error = err.Error()
+	}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		probe.LastSuccess = &lastSuccess
+	}
+	probe.FailureStreak = h.failureStreak
+	h.result = probe
+}
+
+func historyKey(node string, check Check) string {
+	return node + "/" + check.Name
+}
+
+// run probes one check against address with the probe timeout
+func (p *Prober) run(ctx context.Context, address string, check Check) error {
+	if address == "" {
+		return errNoAddress
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	target := net.JoinHostPort(address, strconv.Itoa(check.Port))
+	switch check.Type {
+	case TypeHTTP:
+		return p.probeHTTP(ctx, target, check.Path)
+	case TypeGRPC:
+		return p.probeGRPC(ctx, target, check.Service)
+	default:
+		return p.probeTCP(ctx, target)
+	}
+}
@@ -0,0 +1,313 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+)
+
+// listen opens a TCP listener accepting and closing every connection
+func listen(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// probeRound runs one round over the nodes of result and attaches the results
+func probeRound(p *Prober, result *discovery.DiscoveryResult) {
+	p.SetNodes(result.Nodes)
+	p.round(context.Background())
+	p.Attach(result)
+}
+
+func TestCheckValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		check     Check
+		expectErr bool
+	}{
+		{"tcp", Check{Name: "ingress", Type: TypeTCP, Port: 80}, false},
+		{"http with path", Check{Name: "ingress", Type: TypeHTTP, Port: 8080, Path: "/healthz"}, false},
+		{"grpc", Check{Name: "xds", Type: TypeGRPC, Port: 18000, Service: "envoy"}, false},
+		{"missing name", Check{Type: TypeTCP, Port: 80}, true},
+		{"unknown type", Check{Name: "ingress", Type: "udp", Port: 80}, true},
+		{"invalid port", Check{Name: "ingress", Type: TypeTCP, Port: 70000}, true},
+		{"relative path", Check{Name: "ingress", Type: TypeHTTP, Port: 80, Path: "healthz"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	open, closed := listen(t), closedPort(t)
+	p := New([]Check{
+		{Name: "open", Type: TypeTCP, Port: open},
+		{Name: "closed", Type: TypeTCP, Port: closed},
+	}, WithTimeout(time.Second))
+
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{
+		{Name: "node-1", PrimaryAddress: "127.0.0.1"},
+		{Name: "node-2"},
+	}}
+	probeRound(p, result)
+	probeRound(p, result)
+
+	probes := result.Nodes[0].Probes
+	if len(probes) != 2 {
+		t.Fatalf("Expected 2 probe results, got %+v", probes)
+	}
+	if !probes[0].Healthy || probes[0].LastSuccess == nil || probes[0].FailureStreak != 0 || probes[0].Port != open {
+		t.Errorf("Expected healthy open port, got %+v", probes[0])
+	}
+	if probes[1].Healthy || probes[1].LastSuccess != nil || probes[1].FailureStreak != 2 || probes[1].Error == "" {
+		t.Errorf("Expected closed port failing twice, got %+v", probes[1])
+	}
+
+	for _, probe := range result.Nodes[1].Probes {
+		if probe.Healthy || probe.Error != errNoAddress.Error() {
+			t.Errorf("Expected node without address to fail, got %+v", probe)
+		}
+	}
+}
+
+func TestRound_HistoryRecovers(t *testing.T) {
+	port := listen(t)
+	p := New([]Check{{Name: "ingress", Type: TypeTCP, Port: port}})
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	failing := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{{Name: "node-1", PrimaryAddress: "127.0.0.1"}}}
+	probeRound(p, failing)
+
+	// A failure after a success keeps the last success time
+	p.checks[0].Port = closedPort(t)
+	now = now.Add(time.Minute)
+	probeRound(p, failing)
+
+	probe := failing.Nodes[0].Probes[0]
+	if probe.Healthy || probe.FailureStreak != 1 || probe.LastSuccess == nil || !probe.LastSuccess.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected one failure after a success, got %+v", probe)
+	}
+
+	// Nodes that are gone are forgotten
+	probeRound(p, &discovery.DiscoveryResult{})
+	if len(p.history) != 0 {
+		t.Errorf("Expected history to be pruned, got %d entries", len(p.history))
+	}
+}
+
+func TestRound_Concurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// Keep the connection open so overlapping probes are visible
+				n := active.Add(1)
+				for {
+					current := peak.Load()
+					if n <= current || peak.CompareAndSwap(current, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				active.Add(-1)
+				conn.Close()
+			}()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	p := New([]Check{{Name: "http", Type: TypeHTTP, Port: port}}, WithConcurrency(2), WithTimeout(time.Second))
+	result := &discovery.DiscoveryResult{}
+	for i := 0; i < 8; i++ {
+		result.Nodes = append(result.Nodes, discovery.NodeInfo{Name: "node-" + strconv.Itoa(i), PrimaryAddress: "127.0.0.1"})
+	}
+	probeRound(p, result)
+
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent probes, got %d", peak.Load())
+	}
+}
+
+func TestRound_Timeout(t *testing.T) {
+	// Accepted but never answered, so the HTTP probe runs into its timeout
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	p := New([]Check{{Name: "http", Type: TypeHTTP, Port: port}}, WithTimeout(50*time.Millisecond))
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{{Name: "node-1", PrimaryAddress: "127.0.0.1"}}}
+
+	start := time.Now()
+	probeRound(p, result)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected probe to time out quickly, took %s", elapsed)
+	}
+	if result.Nodes[0].Probes[0].Healthy {
+		t.Error("Expected unanswered probe to fail")
+	}
+}
+
+func TestValidateChecks(t *testing.T) {
+	checks := []Check{
+		{Name: "ingress", Type: TypeTCP, Port: 80},
+		{Name: "ingress", Type: TypeHTTP, Port: 8080},
+	}
+	if err := ValidateChecks(checks); err == nil {
+		t.Error("Expected error for duplicate check names")
+	}
+	if err := ValidateChecks(checks[:1]); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRound_Deadline(t *testing.T) {
+	// Accepted but never answered, so every HTTP probe outlives the round
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	p := New([]Check{{Name: "http", Type: TypeHTTP, Port: port}},
+		WithConcurrency(1), WithTimeout(5*time.Second), WithInterval(100*time.Millisecond))
+	result := &discovery.DiscoveryResult{}
+	for i := 0; i < 3; i++ {
+		result.Nodes = append(result.Nodes, discovery.NodeInfo{Name: "node-" + strconv.Itoa(i), PrimaryAddress: "127.0.0.1"})
+	}
+
+	start := time.Now()
+	probeRound(p, result)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the round to stop at its deadline, took %s", elapsed)
+	}
+
+	// A probe cut short is not a failure of the node
+	for _, node := range result.Nodes {
+		if len(node.Probes) != 0 {
+			t.Errorf("Expected no result for %s, got %+v", node.Name, node.Probes)
+		}
+	}
+	if p.next != 1 {
+		t.Errorf("Expected the next round to continue with node 1, got %d", p.next)
+	}
+}
+
+func TestRun(t *testing.T) {
+	port := listen(t)
+	p := New([]Check{{Name: "ingress", Type: TypeTCP, Port: port}}, WithInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	// The first round starts once nodes are known, without waiting an interval
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{{Name: "node-1", PrimaryAddress: "127.0.0.1"}}}
+	p.SetNodes(result.Nodes)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.Attach(result)
+		if len(result.Nodes[0].Probes) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the node to be probed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !result.Nodes[0].Probes[0].Healthy {
+		t.Errorf("Expected healthy probe, got %+v", result.Nodes[0].Probes[0])
+	}
+}
+
+func TestRound_ResumesAfterDeadline(t *testing.T) {
+	// Accepted but never answered on 127.0.0.1, refused on other loopback
+	// addresses, so only node-0 outlives the round
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	p := New([]Check{{Name: "http", Type: TypeHTTP, Port: port}},
+		WithConcurrency(1), WithTimeout(5*time.Second), WithInterval(100*time.Millisecond))
+	result := &discovery.DiscoveryResult{Nodes: []discovery.NodeInfo{
+		{Name: "node-0", PrimaryAddress: "127.0.0.1"},
+		{Name: "node-1", PrimaryAddress: "127.0.0.2"},
+		{Name: "node-2", PrimaryAddress: "127.0.0.3"},
+	}}
+
+	// The first round is cut short while probing node-0
+	probeRound(p, result)
+	if p.next != 1 {
+		t.Fatalf("Expected the next round to continue with node 1, got %d", p.next)
+	}
+
+	// The next round starts with the nodes the first one missed
+	probeRound(p, result)
+	for _, node := range result.Nodes[1:] {
+		if len(node.Probes) != 1 || node.Probes[0].Healthy {
+			t.Errorf("Expected %s to be probed in the second round, got %+v", node.Name, node.Probes)
+		}
+	}
+	if len(result.Nodes[0].Probes) != 0 {
+		t.Errorf("Expected no result for node-0, got %+v", result.Nodes[0].Probes)
+	}
+}
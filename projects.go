@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/recording"
+)
+
+// project is an Elchi project receiving the part of the cluster selected by
+// its scope. Every project has its own client and so its own initial
+// handshake.
+type project struct {
+	name   string
+	client *api.Client
+	// scope limits the nodes sent to the project, nil sends every node
+	scope *discovery.Scope
+	// lastSentFingerprint and lastSend are those of the last snapshot the
+	// project accepted
+	lastSentFingerprint string
+	lastSend            time.Time
+}
+
+// newProject creates the client and scope for one entry of elchi.projects
+func newProject(cfg *config.Config, log *logger.Logger, projectCfg config.ProjectConfig, opts ...api.Option) (*project, error) {
+	client := newAPIClient(cfg, log, append(opts, api.WithToken(projectCfg.Token))...)
+	if err := client.TokenError(); err != nil {
+		return nil, err
+	}
+	name := client.Project()
+
+	scope, err := discovery.NewScope(projectCfg.NodeSelector, projectCfg.NamespaceSelector, projectCfg.ServiceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("project %s: %w", name, err)
+	}
+
+	return &project{name: name, client: client, scope: scope}, nil
+}
+
+// sendToProjects sends result to the additional projects. They only receive
+// snapshots; heartbeats and directives are exchanged with the primary
+// project. Like the primary, a project is not resent an unchanged snapshot
+// within the full snapshot interval.
+func (a *agent) sendToProjects(ctx context.Context, result *discovery.DiscoveryResult) error {
+	var errs []error
+	for _, p := range a.projects {
+		if err := a.sendToProject(ctx, p, result); err != nil {
+			a.log.WithError(err).WithField("project", p.name).Error("Failed to send discovery result to project")
+			errs = append(errs, fmt.Errorf("project %s: %w", p.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *agent) sendToProject(ctx context.Context, p *project, result *discovery.DiscoveryResult) error {
+	scoped, err := a.discovery.ApplyScope(ctx, result, p.scope)
+	if err != nil {
+		return err
+	}
+
+	payload, err := p.client.GetDiscoveryPayload(scoped)
+	if err != nil {
+		return err
+	}
+
+	fingerprint := scoped.Fingerprint()
+	if result.Hold == nil && result.Stale == nil && a.unchangedFor(p.client, fingerprint, p.lastSentFingerprint, p.lastSend) {
+		a.log.WithFields(map[string]interface{}{
+			"project":     p.name,
+			"fingerprint": fingerprint,
+		}).Debug("Cluster unchanged since last snapshot to project, skipping send")
+		a.recordPayload(&recording.Entry{Payload: payload, Status: recording.StatusSkipped})
+		return nil
+	}
+
+	initial := !p.client.HandshakeCompleted()
+	sendStart := time.Now()
+	err = p.client.SendPayload(ctx, payload)
+	a.recordSend(payload, initial, sendStart, err)
+	if err != nil {
+		return err
+	}
+	if p.client.Enabled() {
+		p.lastSend = time.Now()
+		p.lastSentFingerprint = fingerprint
+	}
+
+	a.log.WithFields(map[string]interface{}{
+		"project":    p.name,
+		"node_count": scoped.NodeCount,
+	}).Debug("Discovery result sent to project")
+	return nil
+}
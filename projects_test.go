@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/config"
+	"github.com/CloudNativeWorks/elchi-discovery/internal/logger"
+	"github.com/CloudNativeWorks/elchi-discovery/mockapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newProjectsAgent returns an agent reporting team-a as its primary project
+// and team-b as an additional project to endpoint
+func newProjectsAgent(t *testing.T, endpoint string) *agent {
+	t.Helper()
+
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"team": "a"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"team": "b"}}},
+	)
+	cfg := &config.Config{
+		ClusterName: "test-cluster",
+		Elchi:       config.ElchiConfig{APIEndpoint: endpoint},
+	}
+	log := logger.NewDefault()
+
+	var projects []*project
+	for _, projectCfg := range []config.ProjectConfig{
+		{Token: "96688e4c-6737-4230-9591-6a3332115871--team-a", NodeSelector: "team=a"},
+		{Token: "96688e4c-6737-4230-9591-6a3332115871--team-b", NodeSelector: "team=b"},
+	} {
+		p, err := newProject(cfg, log, projectCfg)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		projects = append(projects, p)
+	}
+
+	agent := newAgent(log, discovery.NewService(client, cfg.ClusterName), projects[0].client)
+	agent.scope = projects[0].scope
+	agent.projects = projects[1:]
+	return agent
+}
+
+func TestAgentSendsToProjects(t *testing.T) {
+	mock := mockapi.New(mockapi.WithRequireInitial(true))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	agent := newProjectsAgent(t, server.URL)
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+
+	nodes := map[string][]string{}
+	initial := map[string][]string{}
+	for _, request := range mock.Requests() {
+		if request.Status != 200 {
+			t.Fatalf("Expected every request to be accepted, got %+v", request)
+		}
+		var payload api.DiscoveryPayload
+		if err := request.Decode(&payload); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		initial[request.Project] = append(initial[request.Project], request.Initial)
+		if len(nodes[request.Project]) == 0 {
+			for _, node := range payload.Data.Nodes {
+				nodes[request.Project] = append(nodes[request.Project], node.Name)
+			}
+		}
+	}
+
+	for project, expected := range map[string]string{"team-a": "node-a", "team-b": "node-b"} {
+		if len(nodes[project]) != 1 || nodes[project][0] != expected {
+			t.Errorf("Expected project %s to receive %s, got %v", project, expected, nodes[project])
+		}
+		if len(initial[project]) != 2 || initial[project][0] != "true" || initial[project][1] != "false" {
+			t.Errorf("Expected a separate handshake for project %s, got initial %v", project, initial[project])
+		}
+	}
+}
+
+func TestAgentSkipsUnchangedSnapshotsToProjects(t *testing.T) {
+	mock := mockapi.New()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	agent := newProjectsAgent(t, server.URL)
+	agent.fullSnapshotInterval = time.Hour
+	sent := func() map[string]int {
+		counts := map[string]int{}
+		for _, request := range mock.Requests() {
+			counts[request.Project]++
+		}
+		return counts
+	}
+
+	agent.runDiscovery(context.Background())
+	agent.runDiscovery(context.Background())
+	if counts := sent(); counts["team-a"] != 1 || counts["team-b"] != 1 {
+		t.Fatalf("Expected unchanged snapshots to be skipped for every project, got %v", counts)
+	}
+
+	// Every project tracks its own full snapshot interval
+	agent.projects[0].lastSend = time.Now().Add(-2 * time.Hour)
+	agent.runDiscovery(context.Background())
+	if counts := sent(); counts["team-a"] != 1 || counts["team-b"] != 2 {
+		t.Fatalf("Expected only the project past its interval to be resent, got %v", counts)
+	}
+}
+
+func TestNewProject_InvalidToken(t *testing.T) {
+	cfg := &config.Config{ClusterName: "test-cluster"}
+	if _, err := newProject(cfg, logger.NewDefault(), config.ProjectConfig{Token: "no-project"}); err == nil {
+		t.Error("Expected error for a token without project")
+	}
+	if _, err := newProject(cfg, logger.NewDefault(), config.ProjectConfig{
+		Token:        "96688e4c-6737-4230-9591-6a3332115871--team-a",
+		NodeSelector: "team in (a",
+	}); err == nil {
+		t.Error("Expected error for an invalid node selector")
+	}
+}
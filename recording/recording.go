@@ -0,0 +1,166 @@
+// Package recording stores the payloads sent by the agent as JSON lines and
+// replays them against an API endpoint.
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+)
+
+// Outcomes of a recorded payload
+const (
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Entry is a single recorded payload
+type Entry struct {
+	// Time is when the payload was sent, or skipped
+	Time    time.Time             `json:"time"`
+	Payload *api.DiscoveryPayload `json:"payload"`
+	// Initial is the value of the initial header the payload was sent with
+	Initial bool   `json:"initial"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// SendDuration is how long the API request took, zero when not sent
+	SendDuration time.Duration `json:"send_duration,omitempty"`
+}
+
+// Writer appends entries to a JSONL file
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewWriter opens path for appending, creating it if needed
+func NewWriter(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording file: %w", err)
+	}
+	return &Writer{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Write appends entry as a single line
+func (w *Writer) Write(entry *Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.enc.Encode(entry); err != nil {
+		return fmt.Errorf("failed to write recording entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// Read decodes all entries from r
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	// Snapshots of large clusters exceed the default token size
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid recording entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Sender is the part of api.Client used for replay
+type Sender interface {
+	ResetHandshake(ctx context.Context)
+	SendPayload(ctx context.Context, payload *api.DiscoveryPayload) error
+}
+
+// ReplayResult summarises a replay
+type ReplayResult struct {
+	Sent    int
+	Failed  int
+	Skipped int
+}
+
+// Replay re-sends recorded payloads in order. Entries that were skipped when
+// recorded are not sent. The original gaps between payloads are divided by
+// speed; a speed of zero or less sends them back to back. Payloads recorded
+// as initial are sent with the initial header again.
+func Replay(ctx context.Context, entries []Entry, sender Sender, speed float64, onSend func(Entry, error)) (ReplayResult, error) {
+	var result ReplayResult
+	var previous time.Time
+
+	for _, entry := range entries {
+		if entry.Status == StatusSkipped || entry.Payload == nil {
+			result.Skipped++
+			continue
+		}
+
+		if speed > 0 && !previous.IsZero() {
+			if err := sleep(ctx, time.Duration(float64(entry.Time.Sub(previous))/speed)); err != nil {
+				return result, err
+			}
+		}
+		previous = entry.Time
+
+		if entry.Initial {
+			sender.ResetHandshake(ctx)
+		}
+
+		err := sender.SendPayload(ctx, entry.Payload)
+		if err != nil {
+			result.Failed++
+		} else {
+			result.Sent++
+		}
+		if onSend != nil {
+			onSend(entry, err)
+		}
+	}
+
+	return result, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,156 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-discovery/api"
+	"github.com/CloudNativeWorks/elchi-discovery/discovery"
+)
+
+func testEntry(at time.Time, status string, initial bool) *Entry {
+	return &Entry{
+		Time: at,
+		Payload: &api.DiscoveryPayload{
+			Project: "project",
+			Data:    &discovery.DiscoveryResult{Timestamp: at, NodeCount: 1},
+		},
+		Initial: initial,
+		Status:  status,
+	}
+}
+
+func TestWriterAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	writer, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	writer.Write(testEntry(now, StatusSent, true))
+	failed := testEntry(now.Add(time.Second), StatusFailed, false)
+	failed.Error = "API returned non-success status: 500"
+	failed.SendDuration = 25 * time.Millisecond
+	writer.Write(failed)
+	writer.Close()
+
+	// Reopening appends
+	writer, err = NewWriter(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	writer.Write(testEntry(now.Add(2*time.Second), StatusSkipped, false))
+	writer.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	entries, err := Read(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if !entries[0].Initial || entries[0].Status != StatusSent || entries[0].Payload.Project != "project" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Error == "" || entries[1].SendDuration != 25*time.Millisecond {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+	if !entries[2].Time.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("Expected time %s, got %s", now.Add(2*time.Second), entries[2].Time)
+	}
+}
+
+func TestRead_InvalidLine(t *testing.T) {
+	_, err := Read(strings.NewReader("{\"status\":\"sent\"}\nnot json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected error for line 2, got %v", err)
+	}
+}
+
+type fakeSender struct {
+	resets int
+	sent   []*api.DiscoveryPayload
+	times  []time.Time
+	err    error
+}
+
+func (f *fakeSender) ResetHandshake(context.Context) { f.resets++ }
+
+func (f *fakeSender) SendPayload(ctx context.Context, payload *api.DiscoveryPayload) error {
+	f.sent = append(f.sent, payload)
+	f.times = append(f.times, time.Now())
+	return f.err
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Now()
+	entries := []Entry{
+		*testEntry(now, StatusSent, true),
+		*testEntry(now.Add(time.Second), StatusSkipped, false),
+		*testEntry(now.Add(2*time.Second), StatusFailed, false),
+	}
+
+	sender := &fakeSender{}
+	var callbacks int
+	result, err := Replay(context.Background(), entries, sender, 20, func(Entry, error) { callbacks++ })
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Sent != 2 || result.Skipped != 1 || result.Failed != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if callbacks != 2 {
+		t.Errorf("Expected 2 callbacks, got %d", callbacks)
+	}
+	if sender.resets != 1 {
+		t.Errorf("Expected handshake reset for the initial entry, got %d", sender.resets)
+	}
+	// Two seconds at 20x is 100ms
+	if gap := sender.times[1].Sub(sender.times[0]); gap < 90*time.Millisecond {
+		t.Errorf("Expected paced gap of about 100ms, got %s", gap)
+	}
+}
+
+func TestReplay_Failures(t *testing.T) {
+	now := time.Now()
+	entries := []Entry{*testEntry(now, StatusSent, false), *testEntry(now.Add(time.Hour), StatusSent, false)}
+
+	sender := &fakeSender{err: errors.New("boom")}
+	result, err := Replay(context.Background(), entries, sender, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Failed != 2 {
+		t.Errorf("Expected 2 failures, got %+v", result)
+	}
+}
+
+func TestReplay_Cancelled(t *testing.T) {
+	now := time.Now()
+	entries := []Entry{*testEntry(now, StatusSent, false), *testEntry(now.Add(time.Hour), StatusSent, false)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	sender := &fakeSender{}
+	result, err := Replay(ctx, entries, sender, 1, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if result.Sent != 1 {
+		t.Errorf("Expected 1 payload before cancellation, got %d", result.Sent)
+	}
+}
@@ -0,0 +1,235 @@
+// Package state keeps the API handshake state across agent restarts, in a
+// JSON file or in a ConfigMap, so a restarted agent does not make Elchi
+// treat the cluster as new.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kinds of state storage
+const (
+	KindFile      = "file"
+	KindConfigMap = "configmap"
+)
+
+// configMapKey is the ConfigMap data key holding the state document
+const configMapKey = "state.json"
+
+// Entry is the handshake state with one endpoint for one project
+type Entry struct {
+	Endpoint string `json:"endpoint"`
+	Project  string `json:"project"`
+	// HandshakeCompleted is set once the endpoint accepted an initial payload
+	HandshakeCompleted bool `json:"handshake_completed"`
+	// Epoch identifies the handshake on the API side; the API rejects
+	// payloads carrying an epoch it no longer knows
+	Epoch string `json:"epoch,omitempty"`
+	// Fingerprint is the fingerprint of the last snapshot the API accepted
+	Fingerprint    string    `json:"fingerprint,omitempty"`
+	AcknowledgedAt time.Time `json:"acknowledged_at,omitempty"`
+	// AgentID and Sequence are the signing agent ID and the highest sequence
+	// number reserved for it, at least the last one signed; the API tracks
+	// sequences per agent ID
+	AgentID   string    `json:"agent_id,omitempty"`
+	Sequence  uint64    `json:"sequence,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// document is the stored form
+type document struct {
+	Entries []Entry `json:"entries"`
+}
+
+// backend reads and writes the stored document, nil data meaning none yet
+type backend interface {
+	read(ctx context.Context) ([]byte, error)
+	write(ctx context.Context, data []byte) error
+}
+
+// Store holds the entries of every endpoint and project. It is safe for
+// concurrent use and can be shared by several API clients.
+type Store struct {
+	mu      sync.Mutex
+	backend backend
+	entries []Entry
+	loaded  bool
+}
+
+// NewFileStore keeps the state in a JSON file
+func NewFileStore(path string) *Store {
+	return &Store{backend: &fileBackend{path: path}}
+}
+
+// NewConfigMapStore keeps the state in a ConfigMap, created on first save
+func NewConfigMapStore(client kubernetes.Interface, namespace, name string) *Store {
+	return &Store{backend: &configMapBackend{client: client, namespace: namespace, name: name}}
+}
+
+// Get returns the entry for endpoint and project, or nil
+func (s *Store) Get(ctx context.Context, endpoint, project string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	if i := s.index(endpoint, project); i >= 0 {
+		entry := s.entries[i]
+		return &entry, nil
+	}
+	return nil, nil
+}
+
+// Put stores entry, replacing the entry for the same endpoint and project
+func (s *Store) Put(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	if i := s.index(entry.Endpoint, entry.Project); i >= 0 {
+		s.entries[i] = entry
+	} else {
+		s.entries = append(s.entries, entry)
+	}
+
+	data, err := json.MarshalIndent(document{Entries: s.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal handshake state: %w", err)
+	}
+	return s.backend.write(ctx, data)
+}
+
+func (s *Store) load(ctx context.Context) error {
+	if s.loaded {
+		return nil
+	}
+
+	data, err := s.backend.read(ctx)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		var doc document
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse handshake state: %w", err)
+		}
+		s.entries = doc.Entries
+	}
+	s.loaded = true
+	return nil
+}
+
+func (s *Store) index(endpoint, project string) int {
+	for i, entry := range s.entries {
+		if entry.Endpoint == endpoint && entry.Project == project {
+			return i
+		}
+	}
+	return -1
+}
+
+type fileBackend struct {
+	path string
+}
+
+func (b *fileBackend) read(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return data, nil
+}
+
+func (b *fileBackend) write(_ context.Context, data []byte) error {
+	// Write to a temporary file first so a crash never leaves a truncated file
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), ".elchi-discovery-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+type configMapBackend struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func (b *configMapBackend) read(ctx context.Context) ([]byte, error) {
+	cm, err := b.client.CoreV1().ConfigMaps(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state configmap: %w", err)
+	}
+	if data, ok := cm.Data[configMapKey]; ok {
+		return []byte(data), nil
+	}
+	return nil, nil
+}
+
+func (b *configMapBackend) write(ctx context.Context, data []byte) error {
+	configMaps := b.client.CoreV1().ConfigMaps(b.namespace)
+
+	cm, err := configMaps.Get(ctx, b.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      b.name,
+				Namespace: b.namespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/name":       "elchi-discovery",
+					"app.kubernetes.io/managed-by": "elchi-discovery",
+				},
+			},
+			Data: map[string]string{configMapKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create state configmap: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get state configmap: %w", err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[configMapKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update state configmap: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	store := NewFileStore(path)
+	entry, err := store.Get(ctx, "https://eu.example.com", "team-a")
+	if err != nil || entry != nil {
+		t.Fatalf("Expected no entry before the first save, got %+v (%v)", entry, err)
+	}
+
+	entries := []Entry{
+		{Endpoint: "https://eu.example.com", Project: "team-a", HandshakeCompleted: true, Epoch: "e1", Sequence: 7, UpdatedAt: now},
+		{Endpoint: "https://us.example.com", Project: "team-a", Sequence: 3, UpdatedAt: now},
+		{Endpoint: "https://eu.example.com", Project: "team-a", HandshakeCompleted: true, Epoch: "e2", Fingerprint: "abc", Sequence: 9, UpdatedAt: now},
+	}
+	for _, entry := range entries {
+		if err := store.Put(ctx, entry); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// A new store reads what the previous one saved
+	restored, err := NewFileStore(path).Get(ctx, "https://eu.example.com", "team-a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored == nil || restored.Epoch != "e2" || restored.Fingerprint != "abc" || restored.Sequence != 9 || !restored.UpdatedAt.Equal(now) {
+		t.Errorf("Expected the latest eu entry, got %+v", restored)
+	}
+	other, _ := NewFileStore(path).Get(ctx, "https://eu.example.com", "team-b")
+	if other != nil {
+		t.Errorf("Expected entries to be keyed by project, got %+v", other)
+	}
+}
+
+func TestFileStore_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := NewFileStore(path).Get(context.Background(), "https://eu.example.com", "team-a"); err == nil {
+		t.Error("Expected error for an invalid state file")
+	}
+}
+
+func TestConfigMapStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	store := NewConfigMapStore(client, "elchi", "elchi-discovery-state")
+	for _, sequence := range []uint64{1, 2} {
+		entry := Entry{Endpoint: "https://eu.example.com", Project: "team-a", HandshakeCompleted: true, Sequence: sequence}
+		if err := store.Put(ctx, entry); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("elchi").Get(ctx, "elchi-discovery-state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected state configmap, got %v", err)
+	}
+	if cm.Data[configMapKey] == "" || cm.Labels["app.kubernetes.io/managed-by"] != "elchi-discovery" {
+		t.Errorf("Expected labelled configmap with state, got %+v", cm)
+	}
+
+	restored, err := NewConfigMapStore(client, "elchi", "elchi-discovery-state").Get(ctx, "https://eu.example.com", "team-a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored == nil || restored.Sequence != 2 || !restored.HandshakeCompleted {
+		t.Errorf("Expected updated entry, got %+v", restored)
+	}
+}
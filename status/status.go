@@ -0,0 +1,188 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kinds of status object the publisher can write
+const (
+	KindConfigMap      = "configmap"
+	KindCustomResource = "custom-resource"
+)
+
+// ElchiDiscoveryResource is the ElchiDiscovery custom resource, see
+// deploy/elchidiscovery-crd.yaml
+var ElchiDiscoveryResource = schema.GroupVersionResource{
+	Group:    "elchi.io",
+	Version:  "v1alpha1",
+	Resource: "elchidiscoveries",
+}
+
+var managedLabels = map[string]string{
+	"app.kubernetes.io/name":       "elchi-discovery",
+	"app.kubernetes.io/managed-by": "elchi-discovery",
+}
+
+// Report is the agent state written into the cluster after every cycle
+type Report struct {
+	ClusterName        string     `json:"clusterName"`
+	ClusterID          string     `json:"clusterID,omitempty"`
+	NodeCount          int        `json:"nodeCount"`
+	ReadyNodeCount     int        `json:"readyNodeCount"`
+	Fingerprint        string     `json:"fingerprint,omitempty"`
+	LastDiscoveryTime  *time.Time `json:"lastDiscoveryTime,omitempty"`
+	LastSuccessfulSend *time.Time `json:"lastSuccessfulSendTime,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+	LastErrorTime      *time.Time `json:"lastErrorTime,omitempty"`
+	APIMessage         string     `json:"apiMessage,omitempty"`
+	// ActiveEndpoint is the Elchi API endpoint that accepted the last request
+	ActiveEndpoint string `json:"activeEndpoint,omitempty"`
+	// TokenExpiresAt is set for API tokens with an expiry
+	TokenExpiresAt *time.Time `json:"tokenExpiresAt,omitempty"`
+}
+
+// Publisher writes the Report into a ConfigMap or an ElchiDiscovery resource
+type Publisher struct {
+	kind      string
+	namespace string
+	name      string
+	client    kubernetes.Interface
+	dynamic   dynamic.Interface
+}
+
+// NewConfigMapPublisher publishes the report as ConfigMap data
+func NewConfigMapPublisher(client kubernetes.Interface, namespace, name string) *Publisher {
+	return &Publisher{
+		kind:      KindConfigMap,
+		namespace: namespace,
+		name:      name,
+		client:    client,
+	}
+}
+
+// NewCustomResourcePublisher publishes the report in the status subresource
+// of an ElchiDiscovery object, creating the object when it does not exist
+func NewCustomResourcePublisher(client dynamic.Interface, namespace, name string) *Publisher {
+	return &Publisher{
+		kind:      KindCustomResource,
+		namespace: namespace,
+		name:      name,
+		dynamic:   client,
+	}
+}
+
+func (p *Publisher) Publish(ctx context.Context, report *Report) error {
+	if p.kind == KindCustomResource {
+		return p.publishCustomResource(ctx, report)
+	}
+	return p.publishConfigMap(ctx, report)
+}
+
+func (p *Publisher) publishConfigMap(ctx context.Context, report *Report) error {
+	configMaps := p.client.CoreV1().ConfigMaps(p.namespace)
+
+	cm, err := configMaps.Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.name,
+				Namespace: p.namespace,
+				Labels:    managedLabels,
+			},
+			Data: report.configMapData(),
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create status configmap: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get status configmap: %w", err)
+	}
+
+	cm.Data = report.configMapData()
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status configmap: %w", err)
+	}
+	return nil
+}
+
+func (p *Publisher) publishCustomResource(ctx context.Context, report *Report) error {
+	resources := p.dynamic.Resource(ElchiDiscoveryResource).Namespace(p.namespace)
+
+	obj, err := resources.Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		obj = &unstructured.Unstructured{}
+		obj.SetAPIVersion(ElchiDiscoveryResource.GroupVersion().String())
+		obj.SetKind("ElchiDiscovery")
+		obj.SetName(p.name)
+		obj.SetNamespace(p.namespace)
+		obj.SetLabels(managedLabels)
+		if err := unstructured.SetNestedField(obj.Object, report.ClusterName, "spec", "clusterName"); err != nil {
+			return fmt.Errorf("failed to build status resource: %w", err)
+		}
+
+		// The status subresource is ignored on create, so it is set afterwards
+		obj, err = resources.Create(ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create status resource: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get status resource: %w", err)
+	}
+
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(report)
+	if err != nil {
+		return fmt.Errorf("failed to convert status report: %w", err)
+	}
+	obj.Object["status"] = status
+
+	if _, err := resources.UpdateStatus(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status resource: %w", err)
+	}
+	return nil
+}
+
+// configMapData flattens the report so each field shows up in kubectl describe
+func (r *Report) configMapData() map[string]string {
+	data := map[string]string{
+		"clusterName":    r.ClusterName,
+		"nodeCount":      strconv.Itoa(r.NodeCount),
+		"readyNodeCount": strconv.Itoa(r.ReadyNodeCount),
+	}
+
+	setIfNotEmpty := func(key, value string) {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	setTime := func(key string, value *time.Time) {
+		if value != nil {
+			data[key] = value.UTC().Format(time.RFC3339)
+		}
+	}
+
+	setIfNotEmpty("clusterID", r.ClusterID)
+	setIfNotEmpty("fingerprint", r.Fingerprint)
+	setTime("lastDiscoveryTime", r.LastDiscoveryTime)
+	setTime("lastSuccessfulSendTime", r.LastSuccessfulSend)
+	setIfNotEmpty("lastError", r.LastError)
+	setTime("lastErrorTime", r.LastErrorTime)
+	setIfNotEmpty("apiMessage", r.APIMessage)
+	setIfNotEmpty("activeEndpoint", r.ActiveEndpoint)
+	setTime("tokenExpiresAt", r.TokenExpiresAt)
+
+	return data
+}
@@ -0,0 +1,108 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testReport() *Report {
+	sent := time.Date(2025, 8, 7, 22, 42, 30, 0, time.UTC)
+	return &Report{
+		ClusterName:        "test-cluster",
+		ClusterID:          "3f6b1c2e",
+		NodeCount:          3,
+		ReadyNodeCount:     2,
+		Fingerprint:        "abc123",
+		LastDiscoveryTime:  &sent,
+		LastSuccessfulSend: &sent,
+		APIMessage:         "Discovery processed",
+	}
+}
+
+func TestConfigMapPublisher(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	publisher := NewConfigMapPublisher(client, "elchi", "elchi-discovery-status")
+	ctx := context.Background()
+
+	// First publish creates the ConfigMap
+	if err := publisher.Publish(ctx, testReport()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("elchi").Get(ctx, "elchi-discovery-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected status configmap to exist: %v", err)
+	}
+	if cm.Data["nodeCount"] != "3" || cm.Data["readyNodeCount"] != "2" {
+		t.Errorf("Unexpected node counts: %v", cm.Data)
+	}
+	if cm.Data["lastSuccessfulSendTime"] != "2025-08-07T22:42:30Z" {
+		t.Errorf("Unexpected last send time: %s", cm.Data["lastSuccessfulSendTime"])
+	}
+	if _, ok := cm.Data["lastError"]; ok {
+		t.Error("Expected empty lastError to be omitted")
+	}
+	if cm.Labels["app.kubernetes.io/name"] != "elchi-discovery" {
+		t.Errorf("Expected managed labels, got %v", cm.Labels)
+	}
+
+	// Second publish updates it in place
+	report := testReport()
+	report.LastError = "API returned non-success status: 503"
+	if err := publisher.Publish(ctx, report); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	cm, err = client.CoreV1().ConfigMaps("elchi").Get(ctx, "elchi-discovery-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get configmap: %v", err)
+	}
+	if cm.Data["lastError"] != report.LastError {
+		t.Errorf("Expected lastError to be updated, got %q", cm.Data["lastError"])
+	}
+}
+
+func TestCustomResourcePublisher(t *testing.T) {
+	scheme := runtime.NewScheme()
+	client := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		ElchiDiscoveryResource: "ElchiDiscoveryList",
+	})
+	publisher := NewCustomResourcePublisher(client, "elchi", "elchi-discovery")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := publisher.Publish(ctx, testReport()); err != nil {
+			t.Fatalf("Publish() #%d error = %v", i+1, err)
+		}
+	}
+
+	obj, err := client.Resource(ElchiDiscoveryResource).Namespace("elchi").Get(ctx, "elchi-discovery", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected status resource to exist: %v", err)
+	}
+
+	clusterName, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterName")
+	if clusterName != "test-cluster" {
+		t.Errorf("Expected spec.clusterName test-cluster, got %q", clusterName)
+	}
+	nodeCount, _, _ := unstructured.NestedInt64(obj.Object, "status", "nodeCount")
+	if nodeCount != 3 {
+		t.Errorf("Expected status.nodeCount 3, got %d", nodeCount)
+	}
+	message, _, _ := unstructured.NestedString(obj.Object, "status", "apiMessage")
+	if message != "Discovery processed" {
+		t.Errorf("Expected status.apiMessage, got %q", message)
+	}
+	sent, _, _ := unstructured.NestedString(obj.Object, "status", "lastSuccessfulSendTime")
+	if sent != "2025-08-07T22:42:30Z" {
+		t.Errorf("Expected RFC3339 status.lastSuccessfulSendTime, got %q", sent)
+	}
+}